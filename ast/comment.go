@@ -0,0 +1,37 @@
+package ast
+
+// Comment is a // or /* */ comment. Text includes the // or /* and */.
+type Comment struct {
+	Span
+	Text string
+}
+
+// CommentGroup is the comments attached to a node.
+type CommentGroup struct {
+	// Leading are the comments on the lines before the node, after the node
+	// before it.
+	Leading []Comment
+	// Trailing are the comments inside the node which no node within it takes,
+	// those after it on the line it ends on, and for the last node of a block
+	// or program, the rest up to the end of it.
+	Trailing []Comment
+	// Inner are the comments of a block or program which has no nodes.
+	Inner []Comment
+}
+
+// CommentMap maps the span of a node to the comments attached to it. The
+// nodes comments are attached to are the top-level definitions and the
+// expressions of blocks, which a formatter starts a line with, and the blocks
+// themselves. The comments of an empty program are the Inner of the zero Span.
+type CommentMap map[Span]*CommentGroup
+
+// Group returns the comments attached to the node with the given span,
+// adding an empty group for them if there is none.
+func (m CommentMap) Group(span Span) *CommentGroup {
+	g, ok := m[span]
+	if !ok {
+		g = &CommentGroup{}
+		m[span] = g
+	}
+	return g
+}
@@ -1,18 +1,20 @@
 package ast
 
+import "github.com/TOMOFUMI-KONDO/toy/value"
+
 type Environment struct {
-	Bindings map[string]int
+	Bindings map[string]value.Value
 	next     *Environment
 }
 
 func NewEnvironment(next *Environment) *Environment {
 	return &Environment{
-		Bindings: map[string]int{},
+		Bindings: map[string]value.Value{},
 		next:     next,
 	}
 }
 
-func (e *Environment) FindBinding(name string) map[string]int {
+func (e *Environment) FindBinding(name string) map[string]value.Value {
 	if _, ok := e.Bindings[name]; ok {
 		return e.Bindings
 	}
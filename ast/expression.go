@@ -1,11 +1,22 @@
 package ast
 
+import "math/big"
+
+type Node interface {
+	Pos() Position
+	Range() Span
+}
+
 type Expression interface {
+	Node
 	expression()
 }
 
 type IntegerLiteral struct {
+	Span
 	Value int
+	// Big is the value of a literal which does not fit in an int, or nil.
+	Big *big.Int
 }
 
 func (IntegerLiteral) expression() {}
@@ -14,7 +25,69 @@ func NewInteger(value int) IntegerLiteral {
 	return IntegerLiteral{Value: value}
 }
 
+func NewBigInteger(value *big.Int) IntegerLiteral {
+	return IntegerLiteral{Big: value}
+}
+
+type FloatLiteral struct {
+	Span
+	Value float64
+}
+
+func (FloatLiteral) expression() {}
+
+func NewFloat(value float64) FloatLiteral {
+	return FloatLiteral{Value: value}
+}
+
+type StringLiteral struct {
+	Span
+	Value string
+}
+
+func (StringLiteral) expression() {}
+
+func NewString(value string) StringLiteral {
+	return StringLiteral{Value: value}
+}
+
+type BooleanLiteral struct {
+	Span
+	Value bool
+}
+
+func (BooleanLiteral) expression() {}
+
+func NewBool(value bool) BooleanLiteral {
+	return BooleanLiteral{Value: value}
+}
+
+// UnaryExpression applies Operator to Operand.
+type UnaryExpression struct {
+	Span
+	Operator Operator
+	Operand  Expression
+}
+
+func (UnaryExpression) expression() {}
+
+func NewUnary(op Operator, operand Expression) UnaryExpression {
+	return UnaryExpression{
+		Operator: op,
+		Operand:  operand,
+	}
+}
+
+func NewNot(operand Expression) UnaryExpression {
+	return NewUnary(Not, operand)
+}
+
+func NewNegate(operand Expression) UnaryExpression {
+	return NewUnary(Negate, operand)
+}
+
 type BinaryExpression struct {
+	Span
 	Operator Operator
 	Lhs      Expression
 	Rhs      Expression
@@ -62,6 +135,22 @@ func NewDivide(lhs, rhs Expression) BinaryExpression {
 	}
 }
 
+func NewModulo(lhs, rhs Expression) BinaryExpression {
+	return BinaryExpression{
+		Operator: Modulo,
+		Lhs:      lhs,
+		Rhs:      rhs,
+	}
+}
+
+func NewPower(lhs, rhs Expression) BinaryExpression {
+	return BinaryExpression{
+		Operator: Power,
+		Lhs:      lhs,
+		Rhs:      rhs,
+	}
+}
+
 func NewLessThan(lhs, rhs Expression) BinaryExpression {
 	return BinaryExpression{
 		Operator: LessThan,
@@ -110,7 +199,24 @@ func NewNotEqual(lhs, rhs Expression) BinaryExpression {
 	}
 }
 
+func NewAnd(lhs, rhs Expression) BinaryExpression {
+	return BinaryExpression{
+		Operator: And,
+		Lhs:      lhs,
+		Rhs:      rhs,
+	}
+}
+
+func NewOr(lhs, rhs Expression) BinaryExpression {
+	return BinaryExpression{
+		Operator: Or,
+		Lhs:      lhs,
+		Rhs:      rhs,
+	}
+}
+
 type Assignment struct {
+	Span
 	Name       string
 	Expression Expression
 }
@@ -125,6 +231,7 @@ func NewAssignment(name string, exp Expression) Assignment {
 }
 
 type Identifier struct {
+	Span
 	Name string
 }
 
@@ -135,6 +242,7 @@ func NewIdentifier(name string) Identifier {
 }
 
 type BlockExpression struct {
+	Span
 	Expressions []Expression
 }
 
@@ -145,6 +253,7 @@ func NewBlock(exps []Expression) BlockExpression {
 }
 
 type WhileExpression struct {
+	Span
 	Condition Expression
 	Body      BlockExpression
 }
@@ -158,7 +267,43 @@ func NewWhile(cond Expression, body BlockExpression) WhileExpression {
 	}
 }
 
+// ReturnExpression returns Value from the function it appears in. Value is nil
+// for a bare return, which returns 0.
+type ReturnExpression struct {
+	Span
+	Value Expression
+}
+
+func (ReturnExpression) expression() {}
+
+func NewReturn(v Expression) ReturnExpression {
+	return ReturnExpression{Value: v}
+}
+
+// BreakExpression leaves the innermost WhileExpression.
+type BreakExpression struct {
+	Span
+}
+
+func (BreakExpression) expression() {}
+
+func NewBreak() BreakExpression {
+	return BreakExpression{}
+}
+
+// ContinueExpression starts the next iteration of the innermost WhileExpression.
+type ContinueExpression struct {
+	Span
+}
+
+func (ContinueExpression) expression() {}
+
+func NewContinue() ContinueExpression {
+	return ContinueExpression{}
+}
+
 type IfExpression struct {
+	Span
 	Condition  Expression
 	ThenClause BlockExpression
 	ElseClause BlockExpression
@@ -182,6 +327,7 @@ func NewIfWithoutElse(cond Expression, thenClause BlockExpression) IfExpression
 }
 
 type Println struct {
+	Span
 	Arg Expression
 }
 
@@ -193,9 +339,13 @@ func NewPrintln(arg Expression) Println {
 	}
 }
 
+// FunctionCall calls the function named Name, or the function Callee evaluates
+// to if Callee is not nil.
 type FunctionCall struct {
-	Name string
-	Args []Expression
+	Span
+	Name   string
+	Callee Expression
+	Args   []Expression
 }
 
 func (FunctionCall) expression() {}
@@ -206,3 +356,211 @@ func NewFuncCall(name string, args []Expression) FunctionCall {
 		Args: args,
 	}
 }
+
+func NewCall(callee Expression, args []Expression) FunctionCall {
+	return FunctionCall{
+		Callee: callee,
+		Args:   args,
+	}
+}
+
+// FunctionLiteral is an anonymous function, which closes over the scope it is
+// evaluated in.
+type FunctionLiteral struct {
+	Span
+	Args       []string
+	Defaults   []Expression
+	Variadic   bool
+	ArgTypes   []string
+	ReturnType string
+	Body       BlockExpression
+}
+
+func (FunctionLiteral) expression() {}
+
+// Definition returns the function of l as a FunctionDefinition without a name.
+func (l FunctionLiteral) Definition() FunctionDefinition {
+	return FunctionDefinition{
+		Span:       l.Span,
+		Args:       l.Args,
+		Defaults:   l.Defaults,
+		Variadic:   l.Variadic,
+		ArgTypes:   l.ArgTypes,
+		ReturnType: l.ReturnType,
+		Body:       l.Body,
+	}
+}
+
+func NewFuncLiteral(args []string, body BlockExpression) FunctionLiteral {
+	return FunctionLiteral{
+		Args: args,
+		Body: body,
+	}
+}
+
+// ListLiteral evaluates Elements in order into a new list.
+type ListLiteral struct {
+	Span
+	Elements []Expression
+}
+
+func (ListLiteral) expression() {}
+
+func NewListLiteral(elements []Expression) ListLiteral {
+	return ListLiteral{Elements: elements}
+}
+
+// IndexExpression reads the element of Collection at Index.
+type IndexExpression struct {
+	Span
+	Collection Expression
+	Index      Expression
+}
+
+func (IndexExpression) expression() {}
+
+func NewIndex(collection, index Expression) IndexExpression {
+	return IndexExpression{
+		Collection: collection,
+		Index:      index,
+	}
+}
+
+// IndexAssignment replaces the element of Collection at Index with Value, and
+// evaluates to Value like Assignment.
+type IndexAssignment struct {
+	Span
+	Collection Expression
+	Index      Expression
+	Value      Expression
+}
+
+func (IndexAssignment) expression() {}
+
+func NewIndexAssignment(collection, index, v Expression) IndexAssignment {
+	return IndexAssignment{
+		Collection: collection,
+		Index:      index,
+		Value:      v,
+	}
+}
+
+// MapLiteral evaluates Keys[i] and then Values[i] for each entry in order into
+// a new map.
+type MapLiteral struct {
+	Span
+	Keys   []Expression
+	Values []Expression
+}
+
+func (MapLiteral) expression() {}
+
+func NewMapLiteral(keys, values []Expression) MapLiteral {
+	return MapLiteral{
+		Keys:   keys,
+		Values: values,
+	}
+}
+
+// StructLiteral creates a value of the struct named Name, whose field Fields[i]
+// is Values[i]. The fields which are not given are 0.
+type StructLiteral struct {
+	Span
+	Name   string
+	Fields []string
+	Values []Expression
+}
+
+func (StructLiteral) expression() {}
+
+func NewStructLiteral(name string, fields []string, values []Expression) StructLiteral {
+	return StructLiteral{
+		Name:   name,
+		Fields: fields,
+		Values: values,
+	}
+}
+
+// FieldAccess reads the field Field of the struct Object evaluates to.
+type FieldAccess struct {
+	Span
+	Object Expression
+	Field  string
+}
+
+func (FieldAccess) expression() {}
+
+func NewFieldAccess(object Expression, field string) FieldAccess {
+	return FieldAccess{
+		Object: object,
+		Field:  field,
+	}
+}
+
+// FieldAssignment replaces the field Field of the struct Object evaluates to
+// with Value, and evaluates to Value like Assignment.
+type FieldAssignment struct {
+	Span
+	Object Expression
+	Field  string
+	Value  Expression
+}
+
+func (FieldAssignment) expression() {}
+
+func NewFieldAssignment(object Expression, field string, v Expression) FieldAssignment {
+	return FieldAssignment{
+		Object: object,
+		Field:  field,
+		Value:  v,
+	}
+}
+
+// Children returns the subexpressions of exp, except the body of a function
+// literal, which is a scope of its own.
+func Children(intf Expression) []Expression {
+	switch exp := intf.(type) {
+	case BinaryExpression:
+		return []Expression{exp.Lhs, exp.Rhs}
+	case UnaryExpression:
+		return []Expression{exp.Operand}
+	case Assignment:
+		return []Expression{exp.Expression}
+	case IfExpression:
+		return []Expression{exp.Condition, exp.ThenClause, exp.ElseClause}
+	case WhileExpression:
+		return []Expression{exp.Condition, exp.Body}
+	case BlockExpression:
+		return exp.Expressions
+	case Println:
+		return []Expression{exp.Arg}
+	case ListLiteral:
+		return exp.Elements
+	case MapLiteral:
+		var entries []Expression
+		for j := range exp.Keys {
+			entries = append(entries, exp.Keys[j], exp.Values[j])
+		}
+		return entries
+	case StructLiteral:
+		return exp.Values
+	case FieldAccess:
+		return []Expression{exp.Object}
+	case FieldAssignment:
+		return []Expression{exp.Object, exp.Value}
+	case IndexExpression:
+		return []Expression{exp.Collection, exp.Index}
+	case IndexAssignment:
+		return []Expression{exp.Collection, exp.Index, exp.Value}
+	case ReturnExpression:
+		if exp.Value != nil {
+			return []Expression{exp.Value}
+		}
+	case FunctionCall:
+		if exp.Callee != nil {
+			return append([]Expression{exp.Callee}, exp.Args...)
+		}
+		return exp.Args
+	}
+	return nil
+}
@@ -7,12 +7,18 @@ const (
 	Subtract
 	Multiply
 	Divide
+	Modulo
+	Power
 	LessThan
 	LessOrEqual
 	GreaterThan
 	GreaterOrEqual
 	Equal
 	NotEqual
+	And
+	Or
+	Not
+	Negate
 )
 
 func (o Operator) Name() string {
@@ -21,11 +27,61 @@ func (o Operator) Name() string {
 		"Subtract",
 		"Multiply",
 		"Divide",
+		"Modulo",
+		"Power",
 		"LessThan",
 		"LessOrEqual",
 		"GreaterThan",
 		"GreaterOrEqual",
 		"Equal",
 		"NotEqual",
+		"And",
+		"Or",
+		"Not",
+		"Negate",
 	}[o]
 }
+
+// Symbol returns how o is written in toy source.
+func (o Operator) Symbol() string {
+	return [...]string{
+		"+",
+		"-",
+		"*",
+		"/",
+		"%",
+		"**",
+		"<",
+		"<=",
+		">",
+		">=",
+		"==",
+		"!=",
+		"&&",
+		"||",
+		"!",
+		"-",
+	}[o]
+}
+
+// Precedence returns how tightly o binds as the parser reads it, from 1 for ||
+// up to 7 for **. Binary operators are left-associative except **, whose
+// exponent is a unary expression.
+func (o Operator) Precedence() int {
+	switch o {
+	case Or:
+		return 1
+	case And:
+		return 2
+	case LessThan, LessOrEqual, GreaterThan, GreaterOrEqual, Equal, NotEqual:
+		return 3
+	case Add, Subtract:
+		return 4
+	case Multiply, Divide, Modulo:
+		return 5
+	case Not, Negate:
+		return 6
+	default:
+		return 7
+	}
+}
@@ -0,0 +1,43 @@
+package ast
+
+import "fmt"
+
+// Position is a location in toy source. Line and Column start at 1, and
+// Column counts runes.
+type Position struct {
+	File   string
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.File == "" {
+			return "-"
+		}
+		return p.File
+	}
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// Span is the range of source a node was parsed from. End is exclusive.
+type Span struct {
+	Start Position
+	End   Position
+}
+
+func (s Span) Pos() Position {
+	return s.Start
+}
+
+func (s Span) Range() Span {
+	return s
+}
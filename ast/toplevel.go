@@ -1,17 +1,66 @@
 package ast
 
 type TopLevel interface {
+	Node
 	topLevel()
 }
 
 type FunctionDefinition struct {
+	Span
 	Name string
 	Args []string
-	Body BlockExpression
+	// Defaults[i] is the default value of Args[i], or nil if it is required.
+	// Defaults is nil if no argument has a default value.
+	Defaults []Expression
+	// Variadic makes the last of Args a list of the remaining arguments.
+	Variadic bool
+	// ArgTypes[i] is the type annotation of Args[i], or "" if it has none.
+	// ArgTypes is nil if no argument has one. ReturnType is "" unless
+	// annotated. Annotations are only read by the typecheck package.
+	ArgTypes   []string
+	ReturnType string
+	Body       BlockExpression
 }
 
 func (FunctionDefinition) topLevel() {}
 
+// Arity returns the minimum and maximum number of arguments of the function.
+// The maximum is -1 if the function is variadic.
+func (f FunctionDefinition) Arity() (int, int) {
+	fixed := len(f.Args)
+	if f.Variadic {
+		fixed--
+	}
+
+	min := 0
+	for j := 0; j < fixed; j++ {
+		if f.Default(j) == nil {
+			min = j + 1
+		}
+	}
+
+	if f.Variadic {
+		return min, -1
+	}
+	return min, fixed
+}
+
+// Default returns the default value of the j-th argument, or nil.
+func (f FunctionDefinition) Default(j int) Expression {
+	if j >= len(f.Defaults) {
+		return nil
+	}
+	return f.Defaults[j]
+}
+
+// ArgType returns the type annotation of the j-th argument, or "".
+func (f FunctionDefinition) ArgType(j int) string {
+	if j >= len(f.ArgTypes) {
+		return ""
+	}
+	return f.ArgTypes[j]
+}
+
 func NewFuncDef(name string, args []string, body BlockExpression) FunctionDefinition {
 	return FunctionDefinition{
 		Name: name,
@@ -21,12 +70,24 @@ func NewFuncDef(name string, args []string, body BlockExpression) FunctionDefini
 }
 
 type GlobalVariableDefinition struct {
+	Span
 	Name string
+	// Type is the type annotation of the variable, or "" if it has none.
+	Type string
 	Expression
 }
 
 func (GlobalVariableDefinition) topLevel() {}
 
+// NOTE: Pos and Range are defined explicitly because both Span and Expression provide them.
+func (g GlobalVariableDefinition) Pos() Position {
+	return g.Span.Pos()
+}
+
+func (g GlobalVariableDefinition) Range() Span {
+	return g.Span
+}
+
 func NewGlobalVarDef(name string, exp Expression) GlobalVariableDefinition {
 	return GlobalVariableDefinition{
 		Name:       name,
@@ -34,8 +95,46 @@ func NewGlobalVarDef(name string, exp Expression) GlobalVariableDefinition {
 	}
 }
 
+// StructDefinition defines a struct type named Name with the fields Fields.
+type StructDefinition struct {
+	Span
+	Name   string
+	Fields []string
+}
+
+func (StructDefinition) topLevel() {}
+
+func NewStructDef(name string, fields []string) StructDefinition {
+	return StructDefinition{
+		Name:   name,
+		Fields: fields,
+	}
+}
+
+// ImportDeclaration imports the module at Path, whose top-level definitions
+// are then referred to as Name.f. Name is the base name of Path without .toy
+// unless given with as. The loader package resolves imports; they are an
+// error where a program is run without it.
+type ImportDeclaration struct {
+	Span
+	Path string
+	Name string
+}
+
+func (ImportDeclaration) topLevel() {}
+
+func NewImport(path, name string) ImportDeclaration {
+	return ImportDeclaration{
+		Path: path,
+		Name: name,
+	}
+}
+
 type Program struct {
 	Definitions []TopLevel
+	// Comments are the comments of the source the program was parsed from,
+	// or nil if it has none.
+	Comments CommentMap
 }
 
 func NewProgram(topLevels []TopLevel) Program {
@@ -0,0 +1,134 @@
+// Package builtins is the standard library of toy: the functions every program
+// can call without defining them. interpreter.Interpreter and vm.VM call them
+// unless a variable, a defined function or a host function of the same name
+// hides them.
+package builtins
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/TOMOFUMI-KONDO/toy/value"
+)
+
+// Func is a builtin. MaxArity is -1 if it takes any number of arguments from
+// MinArity.
+type Func struct {
+	Name     string
+	MinArity int
+	MaxArity int
+	// Params and Doc describe the function in the listing of toy builtins.
+	Params string
+	Doc    string
+	// ArgTypes and ReturnType name the types of the first len(ArgTypes)
+	// arguments and of the result, as annotations do, for typecheck.
+	ArgTypes   []string
+	ReturnType string
+	Fn         func(env *Env, args []value.Value) (value.Value, error)
+}
+
+// Env is what builtins use of the engine which calls them.
+type Env struct {
+	// Reader is where readln reads lines from.
+	Reader *bufio.Reader
+	// Negate returns -n, or an error if it overflows, as the arithmetic of the
+	// engine does. If it is nil, overflow is an error.
+	Negate func(n value.Int) (value.Value, error)
+}
+
+// NewEnv returns an Env which reads from r. r is used as it is if it is a
+// *bufio.Reader, so that its owner can read from it between calls to readln.
+func NewEnv(r io.Reader) *Env {
+	return &Env{Reader: bufio.NewReader(r)}
+}
+
+// ExitError is raised by exit to stop the program with the exit status Code.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+var funcs = map[string]*Func{}
+
+func init() {
+	for _, f := range []*Func{
+		{Name: "len", MinArity: 1, MaxArity: 1, Params: "x", Doc: "number of elements of a list or map, or of characters of a string", ReturnType: "int", Fn: builtinLen},
+		{Name: "push", MinArity: 2, MaxArity: -1, Params: "list, x...", Doc: "append the xs to list and return it", ArgTypes: []string{"list"}, ReturnType: "list", Fn: builtinPush},
+		{Name: "pop", MinArity: 1, MaxArity: 1, Params: "list", Doc: "remove the last element of list and return it", ArgTypes: []string{"list"}, ReturnType: "any", Fn: builtinPop},
+		{Name: "slice", MinArity: 2, MaxArity: 3, Params: "list, start, end=len(list)", Doc: "new list of the elements of list from start up to end", ArgTypes: []string{"list", "int", "int"}, ReturnType: "list", Fn: builtinSlice},
+		{Name: "has", MinArity: 2, MaxArity: 2, Params: "map, key", Doc: "whether map has key", ArgTypes: []string{"map"}, ReturnType: "bool", Fn: builtinHas},
+		{Name: "delete", MinArity: 2, MaxArity: 2, Params: "map, key", Doc: "remove key from map and return whether it was there", ArgTypes: []string{"map"}, ReturnType: "bool", Fn: builtinDelete},
+		{Name: "keys", MinArity: 1, MaxArity: 1, Params: "map", Doc: "list of the keys of map in the order they were first set", ArgTypes: []string{"map"}, ReturnType: "list", Fn: builtinKeys},
+		{Name: "int", MinArity: 1, MaxArity: 1, Params: "x", Doc: "x converted to an int, truncating a float and parsing a string", ReturnType: "int", Fn: builtinInt},
+		{Name: "float", MinArity: 1, MaxArity: 1, Params: "x", Doc: "x converted to a float, parsing a string", ReturnType: "float", Fn: builtinFloat},
+		{Name: "abs", MinArity: 1, MaxArity: 1, Params: "x", Doc: "absolute value of the number x", ReturnType: "any", Fn: builtinAbs},
+		{Name: "min", MinArity: 1, MaxArity: -1, Params: "x...", Doc: "least of the numbers xs", ReturnType: "any", Fn: builtinMin},
+		{Name: "max", MinArity: 1, MaxArity: -1, Params: "x...", Doc: "greatest of the numbers xs", ReturnType: "any", Fn: builtinMax},
+		{Name: "pow", MinArity: 2, MaxArity: 2, Params: "x, y", Doc: "x to the power y as a float", ReturnType: "float", Fn: builtinPow},
+		{Name: "sqrt", MinArity: 1, MaxArity: 1, Params: "x", Doc: "square root of x as a float", ReturnType: "float", Fn: builtinSqrt},
+		{Name: "substring", MinArity: 2, MaxArity: 3, Params: "s, start, end=len(s)", Doc: "characters of s from start up to end", ArgTypes: []string{"string", "int", "int"}, ReturnType: "string", Fn: builtinSubstring},
+		{Name: "split", MinArity: 2, MaxArity: 2, Params: "s, sep", Doc: "list of the strings between the seps in s, or of its characters if sep is empty", ArgTypes: []string{"string", "string"}, ReturnType: "list", Fn: builtinSplit},
+		{Name: "join", MinArity: 2, MaxArity: 2, Params: "list, sep", Doc: "string of the elements of list with sep between them", ArgTypes: []string{"list", "string"}, ReturnType: "string", Fn: builtinJoin},
+		{Name: "format", MinArity: 1, MaxArity: -1, Params: "f, x...", Doc: "f with each {} replaced by the next x, and {{ and }} by { and }", ArgTypes: []string{"string"}, ReturnType: "string", Fn: builtinFormat},
+		{Name: "readln", MinArity: 0, MaxArity: 0, Params: "", Doc: "next line of the input without its line ending, or false at the end of it", ReturnType: "any", Fn: builtinReadln},
+		{Name: "exit", MinArity: 0, MaxArity: 1, Params: "code=0", Doc: "stop the program with the exit status code", ArgTypes: []string{"int"}, ReturnType: "any", Fn: builtinExit},
+	} {
+		funcs[f.Name] = f
+	}
+}
+
+// Lookup returns the builtin named name.
+func Lookup(name string) (*Func, bool) {
+	f, ok := funcs[name]
+	return f, ok
+}
+
+// All returns every builtin sorted by name.
+func All() []*Func {
+	var all []*Func
+	for _, f := range funcs {
+		all = append(all, f)
+	}
+	sort.Slice(all, func(a, b int) bool { return all[a].Name < all[b].Name })
+	return all
+}
+
+func listArg(name string, v value.Value) (*value.List, error) {
+	list, ok := v.(*value.List)
+	if !ok {
+		return nil, fmt.Errorf("%s: argument must be list, not %s", name, v.Type())
+	}
+	return list, nil
+}
+
+func mapArg(name string, v value.Value) (*value.Map, error) {
+	m, ok := v.(*value.Map)
+	if !ok {
+		return nil, fmt.Errorf("%s: argument must be map, not %s", name, v.Type())
+	}
+	return m, nil
+}
+
+func intArg(name string, v value.Value) (int, error) {
+	if _, ok := v.(value.BigInt); ok {
+		return 0, fmt.Errorf("%s: integer too large for argument", name)
+	}
+	i, ok := v.(value.Int)
+	if !ok {
+		return 0, fmt.Errorf("%s: argument must be int, not %s", name, v.Type())
+	}
+	return int(i), nil
+}
+
+func stringArg(name string, v value.Value) (string, error) {
+	s, ok := v.(value.String)
+	if !ok {
+		return "", fmt.Errorf("%s: argument must be string, not %s", name, v.Type())
+	}
+	return string(s), nil
+}
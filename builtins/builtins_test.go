@@ -0,0 +1,134 @@
+package builtins_test
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/TOMOFUMI-KONDO/toy/builtins"
+	"github.com/TOMOFUMI-KONDO/toy/value"
+)
+
+func call(env *builtins.Env, name string, args ...value.Value) (value.Value, error) {
+	f, ok := builtins.Lookup(name)
+	if !ok {
+		return nil, errors.New("no builtin " + name)
+	}
+	return f.Fn(env, args)
+}
+
+func list(elements ...value.Value) *value.List {
+	return value.NewList(elements)
+}
+
+func TestFuncs(t *testing.T) {
+	huge := value.BigInt{N: new(big.Int).Lsh(big.NewInt(-1), 70)}
+
+	tests := []struct {
+		name     string
+		args     []value.Value
+		expected string
+	}{
+		{"abs", []value.Value{value.Int(-3)}, "3"},
+		{"abs", []value.Value{value.Float(-2.5)}, "2.5"},
+		{"abs", []value.Value{huge}, "1180591620717411303424"},
+		{"abs", []value.Value{value.Int(math.MinInt64)}, "abs: -9223372036854775808 is out of range"},
+		{"int", []value.Value{huge}, "-1180591620717411303424"},
+		{"float", []value.Value{huge}, "-1.1805916207174113e+21"},
+		{"abs", []value.Value{value.String("x")}, "abs: argument must be int or float, not string"},
+		{"min", []value.Value{value.Int(3), value.Float(2.5), value.Int(7)}, "2.5"},
+		{"min", []value.Value{value.Int(1), huge}, "-1180591620717411303424"},
+		{"max", []value.Value{value.Int(2), value.Float(2), value.Int(1)}, "2"},
+		{"max", []value.Value{value.Int(1), value.Float(math.NaN())}, "max: argument is NaN"},
+		{"pow", []value.Value{value.Int(2), value.Int(10)}, "1024.0"},
+		{"pow", []value.Value{value.Int(4), value.Float(0.5)}, "2.0"},
+		{"sqrt", []value.Value{value.Int(2)}, "1.4142135623730951"},
+		{"sqrt", []value.Value{value.Bool(true)}, "sqrt: argument must be int or float, not bool"},
+		{"len", []value.Value{value.String("héllo")}, "5"},
+		{"substring", []value.Value{value.String("héllo"), value.Int(1), value.Int(3)}, "él"},
+		{"substring", []value.Value{value.String("héllo"), value.Int(2)}, "llo"},
+		{"substring", []value.Value{value.String("abc"), value.Int(2), value.Int(4)}, "substring bounds 2:4 out of range for string of length 3"},
+		{"split", []value.Value{value.String("a,b,,c"), value.String(",")}, `["a", "b", "", "c"]`},
+		{"split", []value.Value{value.String("héy"), value.String("")}, `["h", "é", "y"]`},
+		{"join", []value.Value{list(value.String("a"), value.Int(1), value.Bool(true)), value.String("-")}, "a-1-true"},
+		{"join", []value.Value{list(), value.String("-")}, ""},
+		{"join", []value.Value{value.String("ab"), value.String("")}, "join: argument must be list, not string"},
+		{"format", []value.Value{value.String("{} + {} = {}"), value.Int(1), value.Float(2), value.String("3")}, "1 + 2.0 = 3"},
+		{"format", []value.Value{value.String("{{}} {}"), list(value.Int(1))}, "{} [1]"},
+		{"format", []value.Value{value.String("{} {}"), value.Int(1)}, `format: too few arguments for "{} {}"`},
+		{"format", []value.Value{value.String("{}"), value.Int(1), value.Int(2)}, `format: too many arguments for "{}"`},
+		{"exit", nil, "exit status 0"},
+		{"exit", []value.Value{value.Int(3)}, "exit status 3"},
+	}
+
+	for _, test := range tests {
+		var got string
+		if result, err := call(nil, test.name, test.args...); err != nil {
+			got = err.Error()
+		} else {
+			got = result.String()
+		}
+		if got != test.expected {
+			t.Errorf("%s%v = %s; want %s", test.name, test.args, got, test.expected)
+		}
+	}
+}
+
+func TestAbsNegate(t *testing.T) {
+	env := &builtins.Env{Negate: func(n value.Int) (value.Value, error) {
+		return value.BigInt{N: new(big.Int).Neg(big.NewInt(int64(n)))}, nil
+	}}
+
+	result, err := call(env, "abs", value.Int(math.MinInt64))
+	if err != nil || result.String() != "9223372036854775808" {
+		t.Errorf("abs(MinInt64) = %v, %v; want 9223372036854775808", result, err)
+	}
+}
+
+func TestReadln(t *testing.T) {
+	env := builtins.NewEnv(strings.NewReader("first\r\n\nlast"))
+
+	var lines []string
+	for {
+		line, err := call(env, "readln")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == value.Bool(false) {
+			break
+		}
+		lines = append(lines, line.String())
+	}
+
+	if got, want := strings.Join(lines, "|"), "first||last"; got != want {
+		t.Errorf("lines = %q; want %q", got, want)
+	}
+}
+
+func TestAll(t *testing.T) {
+	// types are the names an annotation may use for the type of a builtin
+	types := map[string]bool{"any": true, "int": true, "float": true, "bool": true, "string": true, "list": true, "map": true, "function": true}
+
+	all := builtins.All()
+	for j, f := range all {
+		if f.Doc == "" {
+			t.Errorf("%s has no Doc", f.Name)
+		}
+		if !types[f.ReturnType] {
+			t.Errorf("%s has ReturnType %q; want a type", f.Name, f.ReturnType)
+		}
+		for _, name := range f.ArgTypes {
+			if !types[name] {
+				t.Errorf("%s has ArgTypes %q; want types", f.Name, f.ArgTypes)
+			}
+		}
+		if f.MaxArity >= 0 && len(f.ArgTypes) > f.MaxArity {
+			t.Errorf("%s has %d ArgTypes but takes at most %d arguments", f.Name, len(f.ArgTypes), f.MaxArity)
+		}
+		if j > 0 && all[j-1].Name >= f.Name {
+			t.Errorf("%s is listed after %s", f.Name, all[j-1].Name)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package builtins
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/TOMOFUMI-KONDO/toy/value"
+)
+
+func builtinLen(_ *Env, args []value.Value) (value.Value, error) {
+	switch v := args[0].(type) {
+	case *value.List:
+		return value.Int(len(v.Elements)), nil
+	case *value.Map:
+		return value.Int(v.Len()), nil
+	case value.String:
+		return value.Int(utf8.RuneCountInString(string(v))), nil
+	default:
+		return nil, fmt.Errorf("len: argument must be list, map or string, not %s", v.Type())
+	}
+}
+
+// builtinPush appends the rest of args to the list args[0], and returns it.
+func builtinPush(_ *Env, args []value.Value) (value.Value, error) {
+	list, err := listArg("push", args[0])
+	if err != nil {
+		return nil, err
+	}
+	list.Elements = append(list.Elements, args[1:]...)
+	return list, nil
+}
+
+// builtinPop removes the last element of the list args[0], and returns it.
+func builtinPop(_ *Env, args []value.Value) (value.Value, error) {
+	list, err := listArg("pop", args[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Elements) == 0 {
+		return nil, fmt.Errorf("pop from empty list")
+	}
+
+	last := list.Elements[len(list.Elements)-1]
+	list.Elements = list.Elements[:len(list.Elements)-1]
+	return last, nil
+}
+
+// builtinSlice returns a new list of the elements of args[0] from args[1] up to,
+// but not including, args[2], or up to the end if args[2] is omitted.
+func builtinSlice(_ *Env, args []value.Value) (value.Value, error) {
+	list, err := listArg("slice", args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := intArg("slice", args[1])
+	if err != nil {
+		return nil, err
+	}
+	end := len(list.Elements)
+	if len(args) > 2 {
+		if end, err = intArg("slice", args[2]); err != nil {
+			return nil, err
+		}
+	}
+	if start < 0 || end < start || end > len(list.Elements) {
+		return nil, fmt.Errorf("slice bounds %d:%d out of range for list of length %d", start, end, len(list.Elements))
+	}
+
+	elements := make([]value.Value, end-start)
+	copy(elements, list.Elements[start:end])
+	return value.NewList(elements), nil
+}
+
+// builtinHas reports whether the map args[0] has the key args[1].
+func builtinHas(_ *Env, args []value.Value) (value.Value, error) {
+	m, err := mapArg("has", args[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := value.CheckKey(args[1]); err != nil {
+		return nil, err
+	}
+	_, ok := m.Get(args[1])
+	return value.Bool(ok), nil
+}
+
+// builtinDelete removes the key args[1] from the map args[0], and reports
+// whether it was there.
+func builtinDelete(_ *Env, args []value.Value) (value.Value, error) {
+	m, err := mapArg("delete", args[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := value.CheckKey(args[1]); err != nil {
+		return nil, err
+	}
+	return value.Bool(m.Delete(args[1])), nil
+}
+
+// builtinKeys returns a list of the keys of the map args[0], in the order they
+// were first set.
+func builtinKeys(_ *Env, args []value.Value) (value.Value, error) {
+	m, err := mapArg("keys", args[0])
+	if err != nil {
+		return nil, err
+	}
+	return value.NewList(m.Keys()), nil
+}
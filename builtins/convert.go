@@ -0,0 +1,59 @@
+package builtins
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/TOMOFUMI-KONDO/toy/value"
+)
+
+// builtinInt converts args[0] to an int. A float is truncated toward zero, and
+// a string is parsed.
+func builtinInt(_ *Env, args []value.Value) (value.Value, error) {
+	switch v := args[0].(type) {
+	case value.Int, value.BigInt:
+		return v, nil
+	case value.Float:
+		if math.IsNaN(float64(v)) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return nil, fmt.Errorf("int: %s is out of range", v)
+		}
+		return value.Int(v), nil
+	case value.Bool:
+		if v {
+			return value.Int(1), nil
+		}
+		return value.Int(0), nil
+	case value.String:
+		i, err := strconv.Atoi(strings.TrimSpace(string(v)))
+		if err != nil {
+			return nil, fmt.Errorf("int: cannot parse %q", string(v))
+		}
+		return value.Int(i), nil
+	default:
+		return nil, fmt.Errorf("int: cannot convert %s", v.Type())
+	}
+}
+
+// builtinFloat converts args[0] to a float. A string is parsed.
+func builtinFloat(_ *Env, args []value.Value) (value.Value, error) {
+	switch v := args[0].(type) {
+	case value.Int:
+		return value.Float(v), nil
+	case value.BigInt:
+		f, _ := new(big.Float).SetInt(v.N).Float64()
+		return value.Float(f), nil
+	case value.Float:
+		return v, nil
+	case value.String:
+		f, err := strconv.ParseFloat(strings.TrimSpace(string(v)), 64)
+		if err != nil {
+			return nil, fmt.Errorf("float: cannot parse %q", string(v))
+		}
+		return value.Float(f), nil
+	default:
+		return nil, fmt.Errorf("float: cannot convert %s", v.Type())
+	}
+}
@@ -0,0 +1,37 @@
+package builtins
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/TOMOFUMI-KONDO/toy/value"
+)
+
+// builtinReadln returns the next line of env.Reader without its line ending,
+// or false at the end of the input.
+func builtinReadln(env *Env, _ []value.Value) (value.Value, error) {
+	line, err := env.Reader.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return value.Bool(false), nil
+	}
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("readln: %w", err)
+	}
+
+	line = strings.TrimSuffix(line, "\n")
+	return value.String(strings.TrimSuffix(line, "\r")), nil
+}
+
+// builtinExit raises an ExitError, which unwinds the program like any other
+// error for the embedding program to end it with the status args[0].
+func builtinExit(_ *Env, args []value.Value) (value.Value, error) {
+	code := 0
+	if len(args) > 0 {
+		var err error
+		if code, err = intArg("exit", args[0]); err != nil {
+			return nil, err
+		}
+	}
+	return nil, &ExitError{Code: code}
+}
@@ -0,0 +1,117 @@
+package builtins
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/TOMOFUMI-KONDO/toy/value"
+)
+
+// builtinAbs returns the absolute value of args[0], of the same type but for
+// the least int, which is negated as env.Negate does.
+func builtinAbs(env *Env, args []value.Value) (value.Value, error) {
+	switch v := args[0].(type) {
+	case value.Int:
+		if v == math.MinInt64 {
+			if env != nil && env.Negate != nil {
+				if abs, err := env.Negate(v); err == nil {
+					return abs, nil
+				}
+			}
+			return nil, fmt.Errorf("abs: %s is out of range", v)
+		}
+		if v < 0 {
+			return -v, nil
+		}
+		return v, nil
+	case value.BigInt:
+		return value.BigInt{N: new(big.Int).Abs(v.N)}, nil
+	case value.Float:
+		return value.Float(math.Abs(float64(v))), nil
+	default:
+		return nil, fmt.Errorf("abs: argument must be int or float, not %s", v.Type())
+	}
+}
+
+func builtinMin(_ *Env, args []value.Value) (value.Value, error) {
+	return extreme("min", args, -1)
+}
+
+func builtinMax(_ *Env, args []value.Value) (value.Value, error) {
+	return extreme("max", args, 1)
+}
+
+// extreme returns the first of args which is the least of them if sign is -1,
+// or the greatest if sign is 1.
+func extreme(name string, args []value.Value, sign int) (value.Value, error) {
+	result := args[0]
+	best, err := exactArg(name, result)
+	if err != nil {
+		return nil, err
+	}
+	for _, arg := range args[1:] {
+		n, err := exactArg(name, arg)
+		if err != nil {
+			return nil, err
+		}
+		if n.Cmp(best) == sign {
+			result, best = arg, n
+		}
+	}
+	return result, nil
+}
+
+// builtinPow returns args[0] to the power args[1] as a float. Unlike **, it
+// takes floats and never makes an int.
+func builtinPow(_ *Env, args []value.Value) (value.Value, error) {
+	x, err := floatArg("pow", args[0])
+	if err != nil {
+		return nil, err
+	}
+	y, err := floatArg("pow", args[1])
+	if err != nil {
+		return nil, err
+	}
+	return value.Float(math.Pow(x, y)), nil
+}
+
+func builtinSqrt(_ *Env, args []value.Value) (value.Value, error) {
+	x, err := floatArg("sqrt", args[0])
+	if err != nil {
+		return nil, err
+	}
+	return value.Float(math.Sqrt(x)), nil
+}
+
+func floatArg(name string, v value.Value) (float64, error) {
+	switch v := v.(type) {
+	case value.Int:
+		return float64(v), nil
+	case value.BigInt:
+		f, _ := new(big.Float).SetInt(v.N).Float64()
+		return f, nil
+	case value.Float:
+		return float64(v), nil
+	default:
+		return 0, fmt.Errorf("%s: argument must be int or float, not %s", name, v.Type())
+	}
+}
+
+// exactArg returns the number v as a big.Float, which holds ints and floats
+// exactly so that they compare as numbers.
+func exactArg(name string, v value.Value) (*big.Float, error) {
+	switch v := v.(type) {
+	case value.Int:
+		return new(big.Float).SetInt64(int64(v)), nil
+	case value.BigInt:
+		return new(big.Float).SetInt(v.N), nil
+	case value.Float:
+		if math.IsNaN(float64(v)) {
+			return nil, fmt.Errorf("%s: argument is NaN", name)
+		}
+		return big.NewFloat(float64(v)), nil
+	default:
+		return nil, fmt.Errorf("%s: argument must be int or float, not %s", name, v.Type())
+	}
+}
@@ -0,0 +1,104 @@
+package builtins
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/TOMOFUMI-KONDO/toy/value"
+)
+
+// builtinSubstring returns the characters of the string args[0] from args[1]
+// up to, but not including, args[2], or up to the end if args[2] is omitted.
+func builtinSubstring(_ *Env, args []value.Value) (value.Value, error) {
+	s, err := stringArg("substring", args[0])
+	if err != nil {
+		return nil, err
+	}
+	chars := []rune(s)
+
+	start, err := intArg("substring", args[1])
+	if err != nil {
+		return nil, err
+	}
+	end := len(chars)
+	if len(args) > 2 {
+		if end, err = intArg("substring", args[2]); err != nil {
+			return nil, err
+		}
+	}
+	if start < 0 || end < start || end > len(chars) {
+		return nil, fmt.Errorf("substring bounds %d:%d out of range for string of length %d", start, end, len(chars))
+	}
+	return value.String(chars[start:end]), nil
+}
+
+func builtinSplit(_ *Env, args []value.Value) (value.Value, error) {
+	s, err := stringArg("split", args[0])
+	if err != nil {
+		return nil, err
+	}
+	sep, err := stringArg("split", args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var elements []value.Value
+	for _, part := range strings.Split(s, sep) {
+		elements = append(elements, value.String(part))
+	}
+	return value.NewList(elements), nil
+}
+
+// builtinJoin returns the elements of the list args[0], as println prints
+// them, separated by the string args[1].
+func builtinJoin(_ *Env, args []value.Value) (value.Value, error) {
+	list, err := listArg("join", args[0])
+	if err != nil {
+		return nil, err
+	}
+	sep, err := stringArg("join", args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]string, len(list.Elements))
+	for j, element := range list.Elements {
+		parts[j] = element.String()
+	}
+	return value.String(strings.Join(parts, sep)), nil
+}
+
+// builtinFormat replaces each {} in the string args[0] with the next of the
+// rest of args as println prints it. {{ and }} stand for { and }.
+func builtinFormat(_ *Env, args []value.Value) (value.Value, error) {
+	f, err := stringArg("format", args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	next := 1
+	for j := 0; j < len(f); j++ {
+		switch {
+		case strings.HasPrefix(f[j:], "{{"):
+			b.WriteByte('{')
+			j++
+		case strings.HasPrefix(f[j:], "}}"):
+			b.WriteByte('}')
+			j++
+		case strings.HasPrefix(f[j:], "{}"):
+			if next == len(args) {
+				return nil, fmt.Errorf("format: too few arguments for %q", f)
+			}
+			b.WriteString(args[next].String())
+			next++
+			j++
+		default:
+			b.WriteByte(f[j])
+		}
+	}
+	if next < len(args) {
+		return nil, fmt.Errorf("format: too many arguments for %q", f)
+	}
+	return value.String(b.String()), nil
+}
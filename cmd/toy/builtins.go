@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/TOMOFUMI-KONDO/toy/builtins"
+)
+
+// listBuiltins writes every builtin with its parameters and what it does.
+func listBuiltins(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, f := range builtins.All() {
+		fmt.Fprintf(tw, "%s(%s)\t%s\n", f.Name, f.Params, f.Doc)
+	}
+	return tw.Flush()
+}
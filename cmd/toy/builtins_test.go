@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestListBuiltins(t *testing.T) {
+	var out bytes.Buffer
+	if err := listBuiltins(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	listed := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		listed[strings.Join(strings.Fields(line), " ")] = true
+	}
+	for _, want := range []string{
+		"abs(x) absolute value of the number x",
+		"exit(code=0) stop the program with the exit status code",
+		"substring(s, start, end=len(s)) characters of s from start up to end",
+	} {
+		if !listed[want] {
+			t.Errorf("listing does not have %q:\n%s", want, out.String())
+		}
+	}
+}
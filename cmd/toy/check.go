@@ -0,0 +1,32 @@
+package main
+
+import (
+	"io"
+
+	"github.com/TOMOFUMI-KONDO/toy/interpreter"
+	"github.com/TOMOFUMI-KONDO/toy/loader"
+	"github.com/TOMOFUMI-KONDO/toy/typecheck"
+)
+
+// check loads the program at path with l and reports to w every undefined
+// name, unknown function, wrong number of arguments and type error of it and
+// the modules it imports, for a program run with the functions of b. It
+// reports whether there was none.
+func check(w io.Writer, l *loader.Loader, path string, b *interpreter.Builtins) bool {
+	program, err := l.Load(path)
+	if err != nil {
+		reportLoaded(w, err, l)
+		return false
+	}
+
+	ok := true
+	for _, err := range interpreter.CheckBuiltins(program, b) {
+		reportLoaded(w, err, l)
+		ok = false
+	}
+	for _, err := range typecheck.Check(program, b) {
+		reportLoaded(w, err, l)
+		ok = false
+	}
+	return ok
+}
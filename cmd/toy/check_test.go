@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TOMOFUMI-KONDO/toy/interpreter"
+	"github.com/TOMOFUMI-KONDO/toy/loader"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"prog.toy": "import \"lib.toy\"\ndefine main() {\n\tx+1\n\tlib.f()-2\n\tgreet(5)\n}\n",
+		"lib.toy":  "define f() {\n\t1+\"a\"\n}\n",
+	}
+	for name, source := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if check(&out, loader.NewLoader(nil), filepath.Join(dir, "prog.toy"), interpreter.NewBuiltins(nil)) {
+		t.Errorf("check succeeded; want errors")
+	}
+
+	expected := filepath.Join(dir, "prog.toy") + ":3:2: variable x is not defined\n" +
+		"\t\tx+1\n" +
+		"\t\t^\n" +
+		filepath.Join(dir, "prog.toy") + ":5:2: function greet is not found\n" +
+		"\t\tgreet(5)\n" +
+		"\t\t^^^^^^^^\n" +
+		filepath.Join(dir, "prog.toy") + ":4:2: unsupported operand types for Subtract: string and int\n" +
+		"\t\tlib.f()-2\n" +
+		"\t\t^^^^^^^^^\n"
+	if out.String() != expected {
+		t.Errorf("out = %q; want %q", out.String(), expected)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around a change.
+const diffContext = 3
+
+type edit struct {
+	// op is ' ' for a line kept, '-' for one removed and '+' for one added.
+	op   byte
+	line string
+}
+
+// writeDiff writes to w the unified diff which turns a, the old contents of
+// the file name, into b. It writes nothing if they are the same.
+func writeDiff(w io.Writer, name string, a, b []byte) {
+	edits := diffLines(splitLines(string(a)), splitLines(string(b)))
+
+	header := false
+	for start := 0; start < len(edits); {
+		first := start
+		for first < len(edits) && edits[first].op == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+
+		// a hunk takes in the next change unless more lines than its context
+		// and the next one's are kept between them
+		last := first
+		for k := first + 1; k < len(edits) && k-last-1 <= 2*diffContext; k++ {
+			if edits[k].op != ' ' {
+				last = k
+			}
+		}
+		from, to := first-diffContext, last+diffContext+1
+		if from < 0 {
+			from = 0
+		}
+		if to > len(edits) {
+			to = len(edits)
+		}
+
+		if !header {
+			fmt.Fprintf(w, "--- %s.orig\n+++ %s\n", name, name)
+			header = true
+		}
+		aStart, aLen := count(edits[:from], '+'), count(edits[from:to], '+')
+		bStart, bLen := count(edits[:from], '-'), count(edits[from:to], '-')
+		fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(aStart, aLen), hunkRange(bStart, bLen))
+		for _, e := range edits[from:to] {
+			fmt.Fprintf(w, "%c%s", e.op, e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				fmt.Fprint(w, "\n\\ No newline at end of file\n")
+			}
+		}
+
+		start = to
+	}
+}
+
+// diffLines returns the shortest edits which turn the lines a into b, made of
+// their longest common subsequence.
+func diffLines(a, b []string) []edit {
+	// NOTE: the lines before the first change and after the last one are kept
+	// as they are, so that the table is only as large as what lies between
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var edits []edit
+	for _, line := range a[:prefix] {
+		edits = append(edits, edit{' ', line})
+	}
+	edits = append(edits, lcsEdits(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, edit{' ', line})
+	}
+	return edits
+}
+
+// lcsEdits returns the edits of diffLines, which it finds with a table of the
+// longest common subsequences of all suffixes of a and b.
+func lcsEdits(a, b []string) []edit {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j]})
+			j++
+		}
+	}
+	return edits
+}
+
+// splitLines splits s after each newline.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// count returns the number of edits which are not op.
+func count(edits []edit, op byte) int {
+	n := 0
+	for _, e := range edits {
+		if e.op != op {
+			n++
+		}
+	}
+	return n
+}
+
+// hunkRange returns the range of n lines after the first start of a file in
+// a hunk header.
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected string
+	}{
+		{"", "", ""},
+		{"a b c", "a b c", "  a   b   c"},
+		{"a b c", "a x c", "  a - b + x   c"},
+		{"a b c", "b c", "- a   b   c"},
+		{"a b c", "a b", "  a   b - c"},
+		{"a b", "", "- a - b"},
+		{"", "a", "+ a"},
+		{"a b c d e", "a c b d e", "  a - b   c + b   d   e"},
+		{"a a a", "a a", "  a   a - a"},
+	}
+
+	for _, test := range tests {
+		var got []string
+		for _, e := range diffLines(strings.Fields(test.a), strings.Fields(test.b)) {
+			got = append(got, string(e.op)+" "+e.line)
+		}
+		if strings.Join(got, " ") != test.expected {
+			t.Errorf("diffLines(%q, %q) = %q; want %q", test.a, test.b, strings.Join(got, " "), test.expected)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/TOMOFUMI-KONDO/toy/parser"
+	"github.com/TOMOFUMI-KONDO/toy/printer"
+)
+
+// formatFile formats the toy source at path. It writes the result back to
+// the file if write, and prints to w the changes as a diff if diff, or else
+// the result unless it was written.
+func formatFile(w io.Writer, path string, write, diff bool) error {
+	input, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	formatted, err := format(path, input)
+	if err != nil {
+		return err
+	}
+
+	if diff {
+		writeDiff(w, path, input, formatted)
+	} else if !write {
+		w.Write(formatted)
+	}
+	if !write || bytes.Equal(input, formatted) {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, formatted, info.Mode().Perm())
+}
+
+// format returns the toy source src of the file filename in canonical form.
+func format(filename string, src []byte) ([]byte, error) {
+	toy := &parser.Toy{Buffer: string(src), Filename: filename}
+	if err := toy.Init(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if err := toy.Parse(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if err := toy.ConvertAst(); err != nil {
+		return nil, err
+	}
+	return printer.Format(toy.Program), nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormatFile(t *testing.T) {
+	source := "define f(x) {\n\tx\n}\n\n" +
+		"define main() {\n" +
+		"\ta = 1\n\tb = 2\n\tc = a+b\n\td = 4\n\te = 5\n\tf = 6\n\tg = 7\n\th = 8\n\ti = 9\n\tj = 10\n\tk = j*2\n" +
+		"}\n"
+	path := filepath.Join(t.TempDir(), "prog.toy")
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := formatFile(&out, path, false, true); err != nil {
+		t.Fatal(err)
+	}
+	expected := "--- " + path + ".orig\n+++ " + path + "\n" +
+		"@@ -5,7 +5,7 @@\n" +
+		" define main() {\n \ta = 1\n \tb = 2\n-\tc = a+b\n+\tc = a + b\n \td = 4\n \te = 5\n \tf = 6\n" +
+		"@@ -13,5 +13,5 @@\n" +
+		" \th = 8\n \ti = 9\n \tj = 10\n-\tk = j*2\n+\tk = j * 2\n }\n"
+	if out.String() != expected {
+		t.Errorf("diff = %q; want %q", out.String(), expected)
+	}
+
+	out.Reset()
+	if err := formatFile(&out, path, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() > 0 {
+		t.Errorf("formatFile printed %q; want nothing with write", out.String())
+	}
+
+	// the written file is formatted, so there is no diff
+	if err := formatFile(&out, path, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() > 0 {
+		t.Errorf("diff of written file = %q; want nothing", out.String())
+	}
+}
+
+func TestFormatSyntaxError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prog.toy")
+	if err := os.WriteFile(path, []byte("define main( {\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := formatFile(&out, path, true, false); err == nil {
+		t.Errorf("formatFile succeeded; want a syntax error")
+	}
+	if got, err := os.ReadFile(path); err != nil || string(got) != "define main( {\n}\n" {
+		t.Errorf("file = %q, %v; want it unchanged", got, err)
+	}
+}
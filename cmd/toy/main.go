@@ -1,43 +1,146 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/builtins"
 	"github.com/TOMOFUMI-KONDO/toy/interpreter"
-	"github.com/TOMOFUMI-KONDO/toy/parser"
+	"github.com/TOMOFUMI-KONDO/toy/loader"
+	"github.com/TOMOFUMI-KONDO/toy/value"
+	"github.com/TOMOFUMI-KONDO/toy/vm"
 )
 
+const usage = `usage:
+  toy [-vm] [-arith wrap|checked|big] [-depth n] [-path dirs] <file>  run main() of file
+  toy [-path dirs] check <file>...                                  report errors of files without running them
+  toy builtins                                                      list the builtin functions
+  toy fmt [-w] [-d] <file>...                                       print files in canonical form
+  toy [-depth n] [-path dirs] [repl]                                start an interactive session
+`
+
+// defaultMaxCallDepth is the call depth at which a program is stopped unless
+// -depth is given, well below the depth at which the interpreter would run out
+// of Go stack.
+const defaultMaxCallDepth = 10000
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintln(os.Stderr, "toy file path must be passed.")
-		os.Exit(1)
+	useVM := flag.Bool("vm", false, "run the program on the bytecode vm instead of the interpreter")
+	arith := flag.String("arith", interpreter.WrappingArithmetic.Name(), "what int operations do on overflow: wrap around, raise an error if checked, or make big ints")
+	maxDepth := flag.Int("depth", defaultMaxCallDepth, "the number of calls which may be in progress at once, or 0 for no limit")
+	searchPath := flag.String("path", os.Getenv("TOYPATH"), "directories searched for imports not found next to the importing file, separated by "+string(os.PathListSeparator))
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
 	}
+	flag.Parse()
 
-	path := os.Args[1]
-	input, err := os.ReadFile(path)
-	if err != nil {
-		log.Fatalf("failed to read file %q: %v", path, err)
+	l := loader.NewLoader(filepath.SplitList(*searchPath))
+	if flag.NArg() == 0 || flag.Arg(0) == "repl" {
+		r := newRepl(os.Stdin, os.Stdout, os.Stderr)
+		r.loader = l
+		r.limits.MaxCallDepth = *maxDepth
+		r.reset()
+		os.Exit(r.run())
 	}
+	if flag.Arg(0) == "builtins" {
+		if err := listBuiltins(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "fmt" {
+		fmtFlags := flag.NewFlagSet("fmt", flag.ExitOnError)
+		write := fmtFlags.Bool("w", false, "write the result to the file instead of printing it")
+		diff := fmtFlags.Bool("d", false, "print the changes as a diff instead of the result")
+		fmtFlags.Parse(flag.Args()[1:])
 
-	toy := &parser.Toy{Buffer: string(input)}
-	if err := toy.Init(); err != nil {
-		panic(err)
+		ok := true
+		for _, path := range fmtFlags.Args() {
+			if err := formatFile(os.Stdout, path, *write, *diff); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				ok = false
+			}
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
 	}
-	if err := toy.Parse(); err != nil {
-		panic(err)
+	if flag.Arg(0) == "check" {
+		b := interpreter.NewBuiltins(builtins.NewEnv(os.Stdin))
+		ok := true
+		for _, path := range flag.Args()[1:] {
+			if !check(os.Stderr, l, path, b) {
+				ok = false
+			}
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
 	}
-	if err := toy.ConvertAst(); err != nil {
-		panic(err)
+
+	arithmetic, ok := parseArithmetic(*arith)
+	if !ok {
+		log.Fatalf("unknown arithmetic %q", *arith)
 	}
 
-	itpr := interpreter.NewInterpreter()
+	limits := interpreter.Limits{MaxCallDepth: *maxDepth}
+	run(l, flag.Arg(0), *useVM, arithmetic, limits)
+}
+
+func parseArithmetic(name string) (interpreter.Arithmetic, bool) {
+	for _, a := range []interpreter.Arithmetic{interpreter.WrappingArithmetic, interpreter.CheckedArithmetic, interpreter.BigArithmetic} {
+		if a.Name() == name {
+			return a, true
+		}
+	}
+	return 0, false
+}
+
+func run(l *loader.Loader, path string, useVM bool, arithmetic interpreter.Arithmetic, limits interpreter.Limits) {
+	program, err := l.Load(path)
+	if err != nil {
+		exit(err, l)
+	}
 
-	result, err := itpr.CallMain(toy.Program)
+	result, err := callMain(program, useVM, arithmetic, limits)
 	if err != nil {
-		panic(err)
+		exit(err, l)
 	}
 
 	fmt.Println(result)
 }
+
+// callMain calls the main function of program on the engine useVM selects.
+func callMain(program ast.Program, useVM bool, arithmetic interpreter.Arithmetic, limits interpreter.Limits) (value.Value, error) {
+	if useVM {
+		m := vm.NewVM()
+		m.Arithmetic = arithmetic
+		m.Limits = limits
+		return m.CallMain(program)
+	}
+
+	itpr := interpreter.NewInterpreter()
+	itpr.Arithmetic = arithmetic
+	itpr.Limits = limits
+	return itpr.CallMain(program)
+}
+
+// exit reports err and exits with status 1, or with the status given to the
+// builtin exit if err is a builtins.ExitError.
+func exit(err error, l *loader.Loader) {
+	var exitErr *builtins.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.Code)
+	}
+
+	reportLoaded(os.Stderr, err, l)
+	os.Exit(1)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TOMOFUMI-KONDO/toy/interpreter"
+	"github.com/TOMOFUMI-KONDO/toy/loader"
+)
+
+func TestCallMainDepth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prog.toy")
+	source := "define down(n) {\n\tdown(n+1)\n}\n\ndefine main() {\n\tdown(0)\n}\n"
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	program, err := loader.NewLoader(nil).Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limits := interpreter.Limits{MaxCallDepth: defaultMaxCallDepth}
+	for _, useVM := range []bool{false, true} {
+		_, err := callMain(program, useVM, interpreter.WrappingArithmetic, limits)
+
+		var rerr *interpreter.RuntimeError
+		var lerr *interpreter.LimitExceededError
+		if !errors.As(err, &rerr) || !errors.As(err, &lerr) {
+			t.Fatalf("vm %t: err = %v; want RuntimeError of LimitExceededError", useVM, err)
+		}
+		// down is called defaultMaxCallDepth-1 times below main
+		if len(rerr.Trace) != defaultMaxCallDepth {
+			t.Errorf("vm %t: trace has %d frames; want %d", useVM, len(rerr.Trace), defaultMaxCallDepth)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/builtins"
+	"github.com/TOMOFUMI-KONDO/toy/interpreter"
+	"github.com/TOMOFUMI-KONDO/toy/loader"
+	"github.com/TOMOFUMI-KONDO/toy/parser"
+)
+
+const (
+	prompt             = ">> "
+	continuationPrompt = ".. "
+	replFilename       = "<repl>"
+)
+
+const replHelp = `toy REPL. Enter define/struct/global forms or expressions.
+  :load <file>  define the functions and globals of file
+  :reset        forget every function and global
+  :env          list global variables
+  :funcs        list functions
+  :help         show this message
+  :quit         exit (or Ctrl-D)
+`
+
+type repl struct {
+	// in is shared with readln, which reads the lines after the input calling
+	// it.
+	in     *bufio.Reader
+	out    *lineWriter
+	errOut io.Writer
+	itpr   interpreter.Interpreter
+	// limits are the Limits of itpr, which reset keeps.
+	limits interpreter.Limits
+	// loader loads the files of :load with the modules they import.
+	loader *loader.Loader
+}
+
+func newRepl(in io.Reader, out, errOut io.Writer) *repl {
+	r := &repl{
+		in:     bufio.NewReader(in),
+		out:    &lineWriter{w: out},
+		errOut: errOut,
+		limits: interpreter.Limits{MaxCallDepth: defaultMaxCallDepth},
+		loader: loader.NewLoader(nil),
+	}
+	r.reset()
+	return r
+}
+
+// run reads and evaluates inputs until the end of the input, :quit or a call
+// of exit, and returns the exit status given to exit or else 0.
+func (r *repl) run() int {
+	for {
+		input, ok := r.read()
+		if !ok {
+			fmt.Fprintln(r.out)
+			return 0
+		}
+
+		trimmed := strings.TrimSpace(input)
+		switch {
+		case trimmed == "":
+			continue
+		case strings.HasPrefix(trimmed, ":"):
+			if quit := r.command(trimmed); quit {
+				return 0
+			}
+		default:
+			if exitErr := r.eval(input); exitErr != nil {
+				return exitErr.Code
+			}
+		}
+	}
+}
+
+// read returns the next input, reading more lines while braces or parentheses
+// are left open.
+func (r *repl) read() (string, bool) {
+	var lines []string
+	depth := 0
+
+	r.out.prompt(prompt)
+	for {
+		line, err := r.in.ReadString('\n')
+		if err != nil && line == "" {
+			break
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		lines = append(lines, line)
+
+		depth += nesting(line)
+		if depth <= 0 {
+			return strings.Join(lines, "\n") + "\n", true
+		}
+		r.out.prompt(continuationPrompt)
+	}
+
+	if len(lines) > 0 {
+		return strings.Join(lines, "\n") + "\n", true
+	}
+	return "", false
+}
+
+// nesting returns how many more brackets line opens than it closes, outside
+// of strings and // comments.
+func nesting(line string) int {
+	depth := 0
+	inString, escaped := false, false
+	for j, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && r == '\\':
+			escaped = true
+		case r == '"':
+			inString = !inString
+		case inString:
+		case strings.HasPrefix(line[j:], "//"):
+			return depth
+		case r == '{' || r == '(' || r == '[':
+			depth++
+		case r == '}' || r == ')' || r == ']':
+			depth--
+		}
+	}
+	return depth
+}
+
+// eval evaluates input and reports its errors, except the one of exit, which
+// it returns.
+func (r *repl) eval(input string) *builtins.ExitError {
+	toy := &parser.Toy{Buffer: input, Filename: replFilename}
+	if err := toy.Init(); err != nil {
+		report(r.errOut, err, input)
+		return nil
+	}
+	if err := toy.ParseReplInput(); err != nil {
+		report(r.errOut, err, input)
+		return nil
+	}
+	nodes, err := toy.ConvertReplInput()
+	if err != nil {
+		report(r.errOut, err, input)
+		return nil
+	}
+
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case ast.TopLevel:
+			if err := r.itpr.Define(n); err != nil {
+				r.out.endLine()
+				return r.fail(err, input)
+			}
+
+		case ast.Expression:
+			result, err := r.itpr.Interpret(n)
+			r.out.endLine()
+			if err != nil {
+				return r.fail(err, input)
+			}
+			fmt.Fprintln(r.out, result)
+		}
+	}
+	return nil
+}
+
+// fail reports err of input unless it is the error of exit, which it returns.
+func (r *repl) fail(err error, input string) *builtins.ExitError {
+	var exitErr *builtins.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr
+	}
+	report(r.errOut, err, input)
+	return nil
+}
+
+// command runs a meta-command and reports whether the REPL should quit.
+func (r *repl) command(line string) bool {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case ":load":
+		if len(fields) != 2 {
+			fmt.Fprintln(r.errOut, "usage: :load <file>")
+			return false
+		}
+		r.load(fields[1])
+
+	case ":reset":
+		r.reset()
+
+	case ":env":
+		globals := r.itpr.Globals()
+		var names []string
+		for name := range globals {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(r.out, "%s = %v\n", name, globals[name])
+		}
+
+	case ":funcs":
+		for _, funcDef := range r.itpr.Functions() {
+			args := append([]string(nil), funcDef.Args...)
+			if funcDef.Variadic {
+				args[len(args)-1] += "..."
+			}
+			fmt.Fprintf(r.out, "%s(%s)\n", funcDef.Name, strings.Join(args, ", "))
+		}
+
+	case ":help":
+		fmt.Fprint(r.out, replHelp)
+
+	case ":quit":
+		return true
+
+	default:
+		fmt.Fprintf(r.errOut, "unknown command %s; type :help for help\n", fields[0])
+	}
+
+	return false
+}
+
+func (r *repl) load(path string) {
+	program, err := r.loader.Load(path)
+	if err != nil {
+		reportLoaded(r.errOut, err, r.loader)
+		return
+	}
+
+	for _, topLevel := range program.Definitions {
+		if err := r.itpr.Define(topLevel); err != nil {
+			r.out.endLine()
+			reportLoaded(r.errOut, err, r.loader)
+			return
+		}
+	}
+	r.out.endLine()
+}
+
+func (r *repl) reset() {
+	r.itpr = interpreter.NewInterpreterWithWriter(r.out)
+	r.itpr.SetReader(r.in)
+	r.itpr.Limits = r.limits
+}
+
+// lineWriter remembers whether the last byte written ended a line, so that
+// results are not printed on the same line as the output of println.
+type lineWriter struct {
+	w       io.Writer
+	pending bool
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		l.pending = p[len(p)-1] != '\n'
+	}
+	return l.w.Write(p)
+}
+
+// prompt writes s, which the user finishes with a newline of their own.
+func (l *lineWriter) prompt(s string) {
+	fmt.Fprint(l.w, s)
+	l.pending = false
+}
+
+func (l *lineWriter) endLine() {
+	if l.pending {
+		fmt.Fprintln(l)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRepl(t *testing.T) {
+	lib := filepath.Join(t.TempDir(), "lib.toy")
+	if err := os.WriteFile(lib, []byte("define double(n) {\n\tn*2\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	input := strings.Join([]string{
+		"global g=2",
+		"define sq(x) {",
+		"  x*x",
+		"}",
+		"sq(g)",
+		"n=println(3)",
+		":env",
+		":load " + lib,
+		":funcs",
+		"double(n)",
+		"foo(1)",
+		`"}{"+1`,
+		"xs=[1, 2, // (",
+		"3] // ]",
+		"len(xs) // {",
+		":reset",
+		":funcs",
+		"g",
+		":quit",
+		"1",
+	}, "\n")
+
+	var out, errOut bytes.Buffer
+	newRepl(strings.NewReader(input), &out, &errOut).run()
+
+	results := strings.ReplaceAll(strings.ReplaceAll(out.String(), continuationPrompt, ""), prompt, "")
+	expected := strings.Join([]string{
+		"4",
+		"3",
+		"3",
+		"g = 2",
+		"n = 3",
+		"double(n)",
+		"sq(x)",
+		"6",
+		"}{1",
+		"[1, 2, 3]",
+		"3",
+		"",
+	}, "\n")
+	if results != expected {
+		t.Errorf("out = %q; want %q", results, expected)
+	}
+
+	if !strings.HasPrefix(errOut.String(), "<repl>:1:1: function foo is not found\n") {
+		t.Errorf("errOut = %q; want function foo is not found", errOut.String())
+	}
+	// g is forgotten by :reset
+	if !strings.Contains(errOut.String(), "<repl>:1:1: variable g is not defined\n") {
+		t.Errorf("errOut = %q; want variable g is not defined", errOut.String())
+	}
+}
+
+func TestReplReadlnAndExit(t *testing.T) {
+	input := strings.Join([]string{
+		"name=readln()",
+		"Ada",
+		`format("hello, {}",name)`,
+		"exit(3)",
+		"1",
+	}, "\n")
+
+	var out, errOut bytes.Buffer
+	code := newRepl(strings.NewReader(input), &out, &errOut).run()
+
+	results := strings.ReplaceAll(out.String(), prompt, "")
+	if want := "Ada\nhello, Ada\n"; results != want || code != 3 {
+		t.Errorf("out = %q, code = %d; want %q, 3", results, code, want)
+	}
+	if errOut.Len() > 0 {
+		t.Errorf("errOut = %q; want nothing", errOut.String())
+	}
+}
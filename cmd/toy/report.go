@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/interpreter"
+	"github.com/TOMOFUMI-KONDO/toy/loader"
+	"github.com/TOMOFUMI-KONDO/toy/typecheck"
+)
+
+// report prints err to w. A RuntimeError is printed as its position and
+// message followed by the offending line of source with the node underlined,
+// then the toy call stack. A type error is printed the same way without the
+// stack. An error without a position is printed as its message alone.
+func report(w io.Writer, err error, source string) {
+	var terr *typecheck.Error
+	if errors.As(err, &terr) {
+		fmt.Fprintln(w, terr)
+		if terr.Span.Start.IsValid() {
+			underline(w, terr.Span, source)
+		}
+		return
+	}
+
+	var rerr *interpreter.RuntimeError
+	if !errors.As(err, &rerr) || !rerr.Span.Start.IsValid() {
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	fmt.Fprintln(w, rerr)
+	underline(w, rerr.Span, source)
+
+	if len(rerr.Trace) > 0 {
+		fmt.Fprintln(w, "stack trace:")
+		for _, frame := range rerr.Trace {
+			fmt.Fprintf(w, "\t%s\n", frame)
+		}
+	}
+}
+
+// reportLoaded prints err as report does, with the source of the file of the
+// program loaded by l which err is in.
+func reportLoaded(w io.Writer, err error, l *loader.Loader) {
+	var file string
+	var terr *typecheck.Error
+	var rerr *interpreter.RuntimeError
+	switch {
+	case errors.As(err, &terr):
+		file = terr.Span.Start.File
+	case errors.As(err, &rerr):
+		file = rerr.Span.Start.File
+	}
+	report(w, err, l.Source(file))
+}
+
+// underline prints the line of source where span starts with span underlined.
+func underline(w io.Writer, span ast.Span, source string) {
+	lines := strings.Split(source, "\n")
+	start, end := span.Start, span.End
+	if start.Line > len(lines) {
+		return
+	}
+	line := []rune(strings.TrimRight(lines[start.Line-1], "\r"))
+
+	width := 1
+	if end.Line == start.Line && end.Column > start.Column {
+		width = end.Column - start.Column
+	}
+
+	// keep tabs in the indent so that the carets line up with the source
+	var indent strings.Builder
+	for _, r := range line[:min(start.Column-1, len(line))] {
+		if r == '\t' {
+			indent.WriteRune('\t')
+		} else {
+			indent.WriteRune(' ')
+		}
+	}
+
+	fmt.Fprintf(w, "\t%s\n", string(line))
+	fmt.Fprintf(w, "\t%s%s\n", indent.String(), strings.Repeat("^", width))
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/interpreter"
+	"github.com/TOMOFUMI-KONDO/toy/typecheck"
+)
+
+func TestReport(t *testing.T) {
+	source := "define main() {\n\tn/0\n}\n"
+	err := &interpreter.RuntimeError{
+		Span: ast.Span{
+			Start: ast.Position{File: "prog.toy", Offset: 17, Line: 2, Column: 2},
+			End:   ast.Position{File: "prog.toy", Offset: 20, Line: 2, Column: 5},
+		},
+		Err:   &interpreter.DivisionByZeroError{},
+		Trace: []interpreter.Frame{{Function: "main"}},
+	}
+
+	var out bytes.Buffer
+	report(&out, err, source)
+
+	expected := "prog.toy:2:2: division by zero\n" +
+		"\t\tn/0\n" +
+		"\t\t^^^\n" +
+		"stack trace:\n" +
+		"\tmain\n"
+	if out.String() != expected {
+		t.Errorf("out = %q; want %q", out.String(), expected)
+	}
+}
+
+func TestReportWithoutPosition(t *testing.T) {
+	errs := []error{
+		&typecheck.Error{Msg: "type Pointer is not defined"},
+		&interpreter.RuntimeError{Err: &interpreter.DivisionByZeroError{}},
+	}
+	for _, err := range errs {
+		var out bytes.Buffer
+		report(&out, err, "define main() {\n\tn/0\n}\n")
+		if want := err.Error() + "\n"; out.String() != want {
+			t.Errorf("out = %q; want %q", out.String(), want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/value"
+)
+
+// Arithmetic selects what happens when an operation on ints overflows.
+type Arithmetic int
+
+const (
+	// WrappingArithmetic wraps around like Go ints do.
+	WrappingArithmetic Arithmetic = iota
+	// CheckedArithmetic raises an OverflowError.
+	CheckedArithmetic
+	// BigArithmetic makes a value.BigInt, so that ints are unbounded.
+	BigArithmetic
+)
+
+func (a Arithmetic) Name() string {
+	return [...]string{
+		"wrap",
+		"checked",
+		"big",
+	}[a]
+}
+
+// Literal returns the value of an integer literal which does not fit in an int.
+func (a Arithmetic) Literal(n *big.Int) (value.Value, error) {
+	if a != BigArithmetic {
+		return nil, &LiteralRangeError{}
+	}
+	return value.BigInt{N: n}, nil
+}
+
+// Negate returns -n on the arithmetic a, which builtins.Env holds for abs.
+func (a Arithmetic) Negate(n value.Int) (value.Value, error) {
+	return a.UnaryOperation(ast.Negate, n)
+}
+
+// operateInts applies op to lhs and rhs, and handles overflow as selected by a.
+func (a Arithmetic) operateInts(op ast.Operator, lhs, rhs value.Int) (value.Value, error) {
+	switch a {
+	case CheckedArithmetic:
+		return checkedOperation(op, lhs, rhs)
+	case BigArithmetic:
+		// NOTE: operate on big.Ints only if Go ints would overflow
+		result, err := checkedOperation(op, lhs, rhs)
+		if _, overflow := err.(*OverflowError); overflow {
+			return bigOperation(op, big.NewInt(int64(lhs)), big.NewInt(int64(rhs)))
+		}
+		return result, err
+	default:
+		return intOperation(op, lhs, rhs)
+	}
+}
+
+// checkedOperation is intOperation which reports overflow.
+func checkedOperation(op ast.Operator, lhs, rhs value.Int) (value.Value, error) {
+	overflow := &OverflowError{Operator: op}
+	switch op {
+	case ast.Add:
+		sum := lhs + rhs
+		if (sum > lhs) != (rhs > 0) {
+			return nil, overflow
+		}
+		return sum, nil
+	case ast.Subtract:
+		diff := lhs - rhs
+		if (diff < lhs) != (rhs > 0) {
+			return nil, overflow
+		}
+		return diff, nil
+	case ast.Multiply:
+		product, ok := multiply(lhs, rhs)
+		if !ok {
+			return nil, overflow
+		}
+		return product, nil
+	case ast.Divide:
+		if lhs == math.MinInt && rhs == -1 {
+			return nil, overflow
+		}
+	case ast.Power:
+		if rhs < 0 {
+			break
+		}
+		// NOTE: the same squaring as power, with every product checked
+		result, base := value.Int(1), lhs
+		for exponent := rhs; exponent > 0; exponent >>= 1 {
+			var ok bool
+			if exponent&1 == 1 {
+				if result, ok = multiply(result, base); !ok {
+					return nil, overflow
+				}
+			}
+			if exponent > 1 {
+				if base, ok = multiply(base, base); !ok {
+					return nil, overflow
+				}
+			}
+		}
+		return result, nil
+	}
+	return intOperation(op, lhs, rhs)
+}
+
+// multiply returns lhs*rhs, unless it overflows.
+func multiply(lhs, rhs value.Int) (value.Int, bool) {
+	if lhs == 0 || rhs == 0 {
+		return 0, true
+	}
+	product := lhs * rhs
+	if product/rhs != lhs || (lhs == -1 && rhs == math.MinInt) || (rhs == -1 && lhs == math.MinInt) {
+		return 0, false
+	}
+	return product, true
+}
+
+// bigOperation applies op to ints of any size. The result is an Int if it is
+// in range, so that equal ints are always equal values.
+func bigOperation(op ast.Operator, lhs, rhs *big.Int) (value.Value, error) {
+	switch op {
+	case ast.Add:
+		return normalize(new(big.Int).Add(lhs, rhs)), nil
+	case ast.Subtract:
+		return normalize(new(big.Int).Sub(lhs, rhs)), nil
+	case ast.Multiply:
+		return normalize(new(big.Int).Mul(lhs, rhs)), nil
+	case ast.Divide:
+		if rhs.Sign() == 0 {
+			return nil, &DivisionByZeroError{}
+		}
+		return normalize(new(big.Int).Quo(lhs, rhs)), nil
+	case ast.Modulo:
+		if rhs.Sign() == 0 {
+			return nil, &ModuloByZeroError{}
+		}
+		return normalize(new(big.Int).Rem(lhs, rhs)), nil
+	case ast.Power:
+		if rhs.Sign() < 0 {
+			return nil, fmt.Errorf("negative exponent: %s", rhs)
+		}
+		return normalize(new(big.Int).Exp(lhs, rhs, nil)), nil
+	case ast.Equal:
+		return value.Bool(lhs.Cmp(rhs) == 0), nil
+	case ast.NotEqual:
+		return value.Bool(lhs.Cmp(rhs) != 0), nil
+	case ast.LessThan:
+		return value.Bool(lhs.Cmp(rhs) < 0), nil
+	case ast.LessOrEqual:
+		return value.Bool(lhs.Cmp(rhs) <= 0), nil
+	case ast.GreaterThan:
+		return value.Bool(lhs.Cmp(rhs) > 0), nil
+	case ast.GreaterOrEqual:
+		return value.Bool(lhs.Cmp(rhs) >= 0), nil
+	default:
+		return nil, fmt.Errorf("invalid operator: %v", op)
+	}
+}
+
+// bigOperands returns lhs and rhs as big.Ints if both are ints of any size.
+func bigOperands(lhs, rhs value.Value) (*big.Int, *big.Int, bool) {
+	l, ok := toBig(lhs)
+	if !ok {
+		return nil, nil, false
+	}
+	r, ok := toBig(rhs)
+	if !ok {
+		return nil, nil, false
+	}
+	return l, r, true
+}
+
+func toBig(v value.Value) (*big.Int, bool) {
+	switch v := v.(type) {
+	case value.Int:
+		return big.NewInt(int64(v)), true
+	case value.BigInt:
+		return v.N, true
+	default:
+		return nil, false
+	}
+}
+
+func normalize(n *big.Int) value.Value {
+	if n.IsInt64() && n.Int64() >= math.MinInt && n.Int64() <= math.MaxInt {
+		return value.Int(n.Int64())
+	}
+	return value.BigInt{N: n}
+}
@@ -0,0 +1,103 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/builtins"
+	"github.com/TOMOFUMI-KONDO/toy/value"
+)
+
+// Builtin is a function implemented in Go: one of package builtins, or a host
+// function. A call to its name calls it unless a variable or a defined function
+// of the same name hides it. MaxArity is -1 if it takes any number of arguments
+// from MinArity.
+type Builtin struct {
+	Name     string
+	MinArity int
+	MaxArity int
+	Fn       func(args []value.Value) (value.Value, error)
+	// Host is set for a function registered with RegisterFunc rather than one
+	// every program can call.
+	Host bool
+}
+
+func (*Builtin) Type() string {
+	return "function"
+}
+
+func (b *Builtin) String() string {
+	return fmt.Sprintf("<builtin %s>", b.Name)
+}
+
+// Call checks the number of args and calls b. Errors are not RuntimeErrors;
+// the caller reports them at the call.
+func (b *Builtin) Call(args []value.Value) (value.Value, error) {
+	if err := checkArity(b.Name, b.MinArity, b.MaxArity, len(args)); err != nil {
+		return nil, err
+	}
+	return b.Fn(args)
+}
+
+// NewCallError returns the error of a call at span to b which returned err.
+// A host function is on the stack trace of it, like a defined function, unless
+// it was given a wrong number of arguments.
+func NewCallError(b *Builtin, span ast.Span, err error) *RuntimeError {
+	rerr := &RuntimeError{Span: span, Err: err}
+	var arityErr *ArityMismatchError
+	if b.Host && !errors.As(err, &arityErr) {
+		rerr.Trace = []Frame{{Function: b.Name, Call: span}}
+	}
+	return rerr
+}
+
+// Builtins resolves the name of a call to a Builtin: a host function, or else
+// a function of package builtins. The engines, the compiler and Check look up
+// names with it.
+type Builtins struct {
+	host    map[string]*Builtin
+	library map[string]*Builtin
+}
+
+// NewBuiltins returns Builtins with no host functions, whose functions of
+// package builtins are called with env.
+func NewBuiltins(env *builtins.Env) *Builtins {
+	library := map[string]*Builtin{}
+	for _, f := range builtins.All() {
+		f := f
+		library[f.Name] = &Builtin{
+			Name:     f.Name,
+			MinArity: f.MinArity,
+			MaxArity: f.MaxArity,
+			Fn:       func(args []value.Value) (value.Value, error) { return f.Fn(env, args) },
+		}
+	}
+	return &Builtins{host: map[string]*Builtin{}, library: library}
+}
+
+// Register adds the host function fn named name, as Interpreter.RegisterFunc
+// does.
+func (b *Builtins) Register(name string, minArity, maxArity int, fn func(args []value.Value) (value.Value, error)) {
+	b.host[name] = &Builtin{Name: name, MinArity: minArity, MaxArity: maxArity, Fn: fn, Host: true}
+}
+
+// Lookup returns the host function or else the function of package builtins
+// named name.
+func (b *Builtins) Lookup(name string) (*Builtin, bool) {
+	if f, ok := b.host[name]; ok {
+		return f, true
+	}
+	f, ok := b.library[name]
+	return f, ok
+}
+
+var defaultBuiltins = NewBuiltins(builtins.NewEnv(os.Stdin))
+
+// LookupBuiltin returns the builtin named name, whose readln reads from
+// os.Stdin. An Interpreter calls builtins of its own, which read from what it
+// is given by SetReader.
+func LookupBuiltin(name string) (*Builtin, bool) {
+	return defaultBuiltins.Lookup(name)
+}
@@ -0,0 +1,303 @@
+package interpreter
+
+import (
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/value"
+)
+
+// Check reports, without running program, every variable of it which is read
+// before anything is assigned to it, every call to an unknown function or to a
+// defined or builtin function with a wrong number of arguments, every struct
+// literal of an unknown struct or with an unknown field, and every return,
+// break or continue with nothing to leave. Names are resolved lexically, so
+// the result does not apply to an Interpreter with DynamicScope set.
+//
+// Check follows the order of the source: a function body sees every global and
+// function, and its own variables once assigned. A function literal is called
+// later than it is created, so it sees every variable of the enclosing scope.
+// Names which are assigned only in some branches, or in a loop body to be read
+// on a later iteration, are left for the runtime.
+func Check(program ast.Program) []error {
+	return CheckBuiltins(program, defaultBuiltins)
+}
+
+// CheckBuiltins is Check for a program run with b, which holds the host
+// functions registered with RegisterFunc.
+func CheckBuiltins(program ast.Program, b *Builtins) []error {
+	globals := newScope(nil)
+	globals.funcs = map[string]ast.FunctionDefinition{}
+	globals.structs = map[string]ast.StructDefinition{}
+	globals.builtins = b
+	for _, topLevel := range program.Definitions {
+		switch def := topLevel.(type) {
+		case ast.FunctionDefinition:
+			globals.funcs[def.Name] = def
+		case ast.StructDefinition:
+			globals.structs[def.Name] = def
+		}
+	}
+
+	// NOTE: global variables are initialized in order, before any function runs
+	for _, topLevel := range program.Definitions {
+		if globalVarDef, ok := topLevel.(ast.GlobalVariableDefinition); ok {
+			globals.check(globalVarDef.Expression)
+			globals.names[globalVarDef.Name] = true
+		}
+	}
+	globals.checkLiterals()
+
+	for _, topLevel := range program.Definitions {
+		if funcDef, ok := topLevel.(ast.FunctionDefinition); ok {
+			globals.checkFunction(funcDef)
+		}
+	}
+
+	return globals.errs
+}
+
+type scope struct {
+	names  map[string]bool
+	parent *scope
+	// funcs and structs hold the definitions in the outermost scope, and
+	// builtins the functions implemented in Go.
+	funcs    map[string]ast.FunctionDefinition
+	structs  map[string]ast.StructDefinition
+	builtins *Builtins
+	// errs holds the errors found, in the outermost scope.
+	errs []error
+	// literals are checked once every name of the scope is known.
+	literals []ast.FunctionLiteral
+	// inFunction is set in the scope of a function body, and loops is the
+	// number of WhileExpressions enclosing what is being checked in it.
+	inFunction bool
+	loops      int
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{
+		names:  map[string]bool{},
+		parent: parent,
+	}
+}
+
+func (s *scope) defined(name string) bool {
+	for ; s != nil; s = s.parent {
+		if _, ok := s.funcs[name]; ok || s.names[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// function returns the function a call to name calls, unless name is a variable.
+func (s *scope) function(name string) (ast.FunctionDefinition, bool) {
+	for ; s.parent != nil; s = s.parent {
+		if s.names[name] {
+			return ast.FunctionDefinition{}, false
+		}
+	}
+
+	// NOTE: a global variable hides the function of the same name
+	funcDef, ok := s.funcs[name]
+	return funcDef, ok && !s.names[name]
+}
+
+// builtin returns the host function or else the builtin named name.
+func (s *scope) builtin(name string) (*Builtin, bool) {
+	for s.parent != nil {
+		s = s.parent
+	}
+
+	return s.builtins.Lookup(name)
+}
+
+// report adds err to the errors found.
+func (s *scope) report(err error) {
+	for s.parent != nil {
+		s = s.parent
+	}
+	s.errs = append(s.errs, err)
+}
+
+// checkStruct reports a struct literal of an unknown struct or with an unknown
+// field.
+func (s *scope) checkStruct(exp ast.StructLiteral) {
+	globals := s
+	for globals.parent != nil {
+		globals = globals.parent
+	}
+
+	structDef, ok := globals.structs[exp.Name]
+	if !ok {
+		s.report(&RuntimeError{Span: exp.Span, Err: &UnknownStructError{Name: exp.Name}})
+		return
+	}
+	def := &value.StructType{Name: structDef.Name, Fields: structDef.Fields}
+	for _, field := range exp.Fields {
+		if _, ok := def.Field(field); !ok {
+			s.report(&RuntimeError{Span: exp.Span, Err: &UnknownFieldError{Type: exp.Name, Field: field}})
+		}
+	}
+}
+
+func (s *scope) checkFunction(funcDef ast.FunctionDefinition) {
+	fs := newScope(s)
+	fs.inFunction = true
+	for j, arg := range funcDef.Args {
+		if defaultValue := funcDef.Default(j); defaultValue != nil {
+			fs.check(defaultValue)
+		}
+		fs.names[arg] = true
+	}
+
+	fs.check(funcDef.Body)
+	fs.checkLiterals()
+}
+
+func (s *scope) checkLiterals() {
+	for _, literal := range s.literals {
+		s.checkFunction(literal.Definition())
+	}
+}
+
+func (s *scope) check(intf ast.Expression) {
+	switch exp := intf.(type) {
+	case ast.BinaryExpression:
+		s.check(exp.Lhs)
+		s.check(exp.Rhs)
+
+	case ast.UnaryExpression:
+		s.check(exp.Operand)
+
+	case ast.Identifier:
+		if _, ok := s.builtin(exp.Name); !ok && !s.defined(exp.Name) {
+			s.report(NewUndefinedVariableError(exp.Span, exp.Name))
+		}
+
+	case ast.Assignment:
+		s.check(exp.Expression)
+		if !s.defined(exp.Name) {
+			s.names[exp.Name] = true
+		}
+
+	case ast.IfExpression:
+		s.check(exp.Condition)
+		s.check(exp.ThenClause)
+		s.check(exp.ElseClause)
+
+	case ast.WhileExpression:
+		// NOTE: a later iteration sees what the body assigns on earlier ones
+		s.declare(exp.Body)
+		s.check(exp.Condition)
+		s.loops++
+		s.check(exp.Body)
+		s.loops--
+
+	case ast.ReturnExpression:
+		if !s.inFunction {
+			s.report(&RuntimeError{Span: exp.Span, Err: &returnSignal{}})
+		}
+		if exp.Value != nil {
+			s.check(exp.Value)
+		}
+
+	case ast.BreakExpression:
+		if s.loops == 0 {
+			s.report(&RuntimeError{Span: exp.Span, Err: &loopSignal{isBreak: true}})
+		}
+
+	case ast.ContinueExpression:
+		if s.loops == 0 {
+			s.report(&RuntimeError{Span: exp.Span, Err: &loopSignal{}})
+		}
+
+	case ast.BlockExpression:
+		for _, e := range exp.Expressions {
+			s.check(e)
+		}
+
+	case ast.Println:
+		s.check(exp.Arg)
+
+	case ast.FunctionLiteral:
+		s.literals = append(s.literals, exp)
+
+	case ast.ListLiteral:
+		for _, e := range exp.Elements {
+			s.check(e)
+		}
+
+	case ast.MapLiteral:
+		for j := range exp.Keys {
+			s.check(exp.Keys[j])
+			s.check(exp.Values[j])
+		}
+
+	case ast.StructLiteral:
+		s.checkStruct(exp)
+		for _, v := range exp.Values {
+			s.check(v)
+		}
+
+	case ast.FieldAccess:
+		s.check(exp.Object)
+
+	case ast.FieldAssignment:
+		s.check(exp.Object)
+		s.check(exp.Value)
+
+	case ast.IndexExpression:
+		s.check(exp.Collection)
+		s.check(exp.Index)
+
+	case ast.IndexAssignment:
+		s.check(exp.Collection)
+		s.check(exp.Index)
+		s.check(exp.Value)
+
+	case ast.FunctionCall:
+		if exp.Callee != nil {
+			s.check(exp.Callee)
+		} else {
+			s.checkCall(exp)
+		}
+		for _, arg := range exp.Args {
+			s.check(arg)
+		}
+	}
+}
+
+// declare adds every name which exp assigns, outside of function literals.
+func (s *scope) declare(intf ast.Expression) {
+	if assignment, ok := intf.(ast.Assignment); ok && !s.defined(assignment.Name) {
+		s.names[assignment.Name] = true
+	}
+	for _, child := range ast.Children(intf) {
+		s.declare(child)
+	}
+}
+
+// checkCall reports a call by name to a function which is not defined, and a
+// call with a wrong number of arguments. A variable of the name is called as a
+// function value, which is left to the runtime.
+func (s *scope) checkCall(exp ast.FunctionCall) {
+	if funcDef, ok := s.function(exp.Name); ok {
+		min, max := funcDef.Arity()
+		if err := checkArity(funcDef.Name, min, max, len(exp.Args)); err != nil {
+			s.report(&RuntimeError{Span: exp.Span, Err: err})
+		}
+		return
+	}
+	if s.defined(exp.Name) {
+		return
+	}
+
+	b, ok := s.builtin(exp.Name)
+	if !ok {
+		s.report(&RuntimeError{Span: exp.Span, Err: &UnknownFunctionError{Name: exp.Name}})
+		return
+	}
+	if err := checkArity(b.Name, b.MinArity, b.MaxArity, len(exp.Args)); err != nil {
+		s.report(&RuntimeError{Span: exp.Span, Err: err})
+	}
+}
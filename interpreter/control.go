@@ -0,0 +1,43 @@
+package interpreter
+
+import (
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/value"
+)
+
+// NOTE: return, break and continue unwind the interpreter as errors, which are
+// caught by the call or the loop they leave. One which is not caught there is
+// reported as a RuntimeError.
+
+type returnSignal struct {
+	span  ast.Span
+	value value.Value
+}
+
+func (*returnSignal) Error() string {
+	return "return outside function"
+}
+
+type loopSignal struct {
+	span ast.Span
+	// isBreak is false for continue.
+	isBreak bool
+}
+
+func (s *loopSignal) Error() string {
+	if s.isBreak {
+		return "break outside loop"
+	}
+	return "continue outside loop"
+}
+
+// uncaught reports a signal which has unwound to a function boundary.
+func uncaught(err error) error {
+	switch sig := err.(type) {
+	case *returnSignal:
+		return &RuntimeError{Span: sig.span, Err: sig}
+	case *loopSignal:
+		return &RuntimeError{Span: sig.span, Err: sig}
+	}
+	return err
+}
@@ -0,0 +1,229 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/value"
+)
+
+// RuntimeError is an error raised while evaluating the node at Span. Err is
+// one of the error types of this package when the cause is known, so that it
+// can be told apart with errors.As.
+type RuntimeError struct {
+	Span ast.Span
+	Err  error
+	// Trace is the toy call stack at the time of the error, innermost first.
+	Trace []Frame
+}
+
+// Frame is a function on the call stack, and the call it was entered by
+// unless it is main.
+type Frame struct {
+	Function string
+	Call     ast.Span
+}
+
+func (f Frame) String() string {
+	name := f.Function
+	if name == "" {
+		name = "<function>"
+	}
+	if !f.Call.Start.IsValid() {
+		return name
+	}
+	return fmt.Sprintf("%s called at %s", name, f.Call.Start)
+}
+
+func newRuntimeError(span ast.Span, format string, a ...interface{}) *RuntimeError {
+	return &RuntimeError{
+		Span: span,
+		Err:  fmt.Errorf(format, a...),
+	}
+}
+
+func (e *RuntimeError) Error() string {
+	if !e.Span.Start.IsValid() {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Span.Start, e.Err)
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// trace records that the function named name was called at span, if err is a
+// RuntimeError.
+func trace(err error, name string, span ast.Span) error {
+	var rerr *RuntimeError
+	if errors.As(err, &rerr) {
+		rerr.Trace = append(rerr.Trace, Frame{Function: name, Call: span})
+	}
+	return err
+}
+
+// DivisionByZeroError is raised by dividing an int by 0.
+type DivisionByZeroError struct{}
+
+func (*DivisionByZeroError) Error() string {
+	return "division by zero"
+}
+
+// ModuloByZeroError is raised by taking the remainder of an int divided by 0.
+type ModuloByZeroError struct{}
+
+func (*ModuloByZeroError) Error() string {
+	return "modulo by zero"
+}
+
+// OverflowError is raised by an operation on ints whose result is out of range,
+// if the arithmetic is CheckedArithmetic.
+type OverflowError struct {
+	Operator ast.Operator
+}
+
+func (e *OverflowError) Error() string {
+	return fmt.Sprintf("integer overflow in %s", e.Operator.Name())
+}
+
+// LiteralRangeError is raised by an integer literal which does not fit in an
+// int, unless the arithmetic is BigArithmetic.
+type LiteralRangeError struct{}
+
+func (*LiteralRangeError) Error() string {
+	return "integer literal out of range"
+}
+
+// UnknownFunctionError is raised by calling a function which is not defined.
+type UnknownFunctionError struct {
+	Name string
+}
+
+func (e *UnknownFunctionError) Error() string {
+	return fmt.Sprintf("function %s is not found", e.Name)
+}
+
+// ArityMismatchError is raised by calling a function with a wrong number of
+// arguments. Function is empty for a function literal, and Max is -1 for a
+// variadic function.
+type ArityMismatchError struct {
+	Function string
+	Min      int
+	Max      int
+	Got      int
+}
+
+func (e *ArityMismatchError) Error() string {
+	name := "function"
+	if e.Function != "" {
+		name += " " + e.Function
+	}
+
+	var want string
+	switch {
+	case e.Min == e.Max:
+		want = fmt.Sprint(e.Min)
+	case e.Max < 0:
+		want = fmt.Sprintf("at least %d", e.Min)
+	default:
+		want = fmt.Sprintf("%d to %d", e.Min, e.Max)
+	}
+	return fmt.Sprintf("%s takes %s arguments but %d were given", name, want, e.Got)
+}
+
+// checkArity returns an ArityMismatchError if the function named name, which
+// takes min to max arguments, does not take argc arguments.
+func checkArity(name string, min, max, argc int) error {
+	if argc < min || (max >= 0 && argc > max) {
+		return &ArityMismatchError{Function: name, Min: min, Max: max, Got: argc}
+	}
+	return nil
+}
+
+// IndexOutOfRangeError is raised by indexing a list of Length elements with
+// Index.
+type IndexOutOfRangeError struct {
+	Index  int
+	Length int
+}
+
+func (e *IndexOutOfRangeError) Error() string {
+	return fmt.Sprintf("index %d out of range for list of length %d", e.Index, e.Length)
+}
+
+// KeyNotFoundError is raised by reading a key which a map does not have.
+type KeyNotFoundError struct {
+	Key value.Value
+}
+
+func (e *KeyNotFoundError) Error() string {
+	if s, ok := e.Key.(value.String); ok {
+		return fmt.Sprintf("key %q not found", string(s))
+	}
+	return fmt.Sprintf("key %s not found", e.Key)
+}
+
+// UnknownStructError is raised by creating a value of a struct which is not
+// defined.
+type UnknownStructError struct {
+	Name string
+}
+
+func (e *UnknownStructError) Error() string {
+	return fmt.Sprintf("struct %s is not defined", e.Name)
+}
+
+// UnknownFieldError is raised by using the field Field of a value of the type
+// Type which does not have it.
+type UnknownFieldError struct {
+	Type  string
+	Field string
+}
+
+func (e *UnknownFieldError) Error() string {
+	return fmt.Sprintf("%s has no field %s", e.Type, e.Field)
+}
+
+// UnresolvedImportError is raised by an import of the module at Path in a
+// program which is not loaded by the loader package, which links imported
+// modules in place of imports.
+type UnresolvedImportError struct {
+	Path string
+}
+
+func (e *UnresolvedImportError) Error() string {
+	return fmt.Sprintf("import %q is not resolved; load the program with package loader", e.Path)
+}
+
+// LimitExceededError is raised by a program which exceeds one of its Limits.
+// Limit is "step" or "call depth".
+type LimitExceededError struct {
+	Limit string
+	Max   int
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("%s limit of %d exceeded", e.Limit, e.Max)
+}
+
+// UndefinedVariableError is raised when the variable Name at Span is read
+// before anything is assigned to it.
+type UndefinedVariableError struct {
+	Span ast.Span
+	Name string
+}
+
+// NewUndefinedVariableError returns an UndefinedVariableError wrapped in a
+// RuntimeError, which prefixes its message with the position.
+func NewUndefinedVariableError(span ast.Span, name string) *RuntimeError {
+	return &RuntimeError{
+		Span: span,
+		Err:  &UndefinedVariableError{Span: span, Name: name},
+	}
+}
+
+func (e *UndefinedVariableError) Error() string {
+	return fmt.Sprintf("variable %s is not defined", e.Name)
+}
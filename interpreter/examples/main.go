@@ -26,5 +26,5 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	fmt.Printf("result: %d\n", result)
+	fmt.Printf("result: %v\n", result)
 }
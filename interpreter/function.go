@@ -0,0 +1,25 @@
+package interpreter
+
+import (
+	"fmt"
+
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+)
+
+// Function is a function value. Env is the scope it was created in, which its
+// body sees unless DynamicScope is set.
+type Function struct {
+	Def ast.FunctionDefinition
+	Env *ast.Environment
+}
+
+func (*Function) Type() string {
+	return "function"
+}
+
+func (f *Function) String() string {
+	if f.Def.Name == "" {
+		return "<function>"
+	}
+	return fmt.Sprintf("<function %s>", f.Def.Name)
+}
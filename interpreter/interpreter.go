@@ -1,107 +1,178 @@
 package interpreter
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 
 	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/builtins"
+	"github.com/TOMOFUMI-KONDO/toy/value"
 )
 
 const MainFuncName = "main"
 
 type Interpreter struct {
-	varEnv  *ast.Environment
-	funcEnv map[string]ast.FunctionDefinition
-	writer  io.Writer
+	// DynamicScope makes a function body see the variables of its caller, as
+	// toy did before function scopes were made lexical.
+	DynamicScope bool
+	// Arithmetic selects what operations on ints do when they overflow.
+	Arithmetic Arithmetic
+	// Limits stop a program run by InterpretContext or CallMainContext, or by
+	// Interpret or CallMain, which run it with context.Background().
+	Limits Limits
+
+	varEnv   *ast.Environment
+	globals  *ast.Environment
+	funcEnv  map[string]*Function
+	builtins *Builtins
+	env      *builtins.Env
+	structs  map[string]*value.StructType
+	writer   io.Writer
+	meter    *Meter
 }
 
 func NewInterpreter() Interpreter {
+	globals := ast.NewEnvironment(nil)
+	env := builtins.NewEnv(os.Stdin)
 	return Interpreter{
-		varEnv:  ast.NewEnvironment(nil),
-		funcEnv: map[string]ast.FunctionDefinition{},
-		writer:  os.Stdout,
+		varEnv:   globals,
+		globals:  globals,
+		funcEnv:  map[string]*Function{},
+		builtins: NewBuiltins(env),
+		env:      env,
+		structs:  map[string]*value.StructType{},
+		writer:   os.Stdout,
 	}
 }
 
+// SetReader makes readln read from r instead of os.Stdin.
+func (i *Interpreter) SetReader(r io.Reader) {
+	*i.env = *builtins.NewEnv(r)
+}
+
+// RegisterFunc makes the Go function fn callable from toy as name, with
+// minArity to maxArity arguments, or minArity or more if maxArity is -1. It is
+// called like a defined function: a variable or a defined function of the same
+// name hides it, and it hides a builtin. An error returned by fn is reported
+// at the call, with name on the stack trace.
+func (i *Interpreter) RegisterFunc(name string, minArity, maxArity int, fn func(args []value.Value) (value.Value, error)) {
+	i.builtins.Register(name, minArity, maxArity, fn)
+}
+
 func NewInterpreterWithWriter(w io.Writer) Interpreter {
 	i := NewInterpreter()
 	i.writer = w
 	return i
 }
 
-func (i *Interpreter) Interpret(intf ast.Expression) (int, error) {
+func (i *Interpreter) Interpret(exp ast.Expression) (value.Value, error) {
+	return i.InterpretContext(context.Background(), exp)
+}
+
+// InterpretContext is Interpret which stops with an error when ctx is done or
+// a limit of i.Limits is exceeded.
+func (i *Interpreter) InterpretContext(ctx context.Context, exp ast.Expression) (value.Value, error) {
+	defer i.start(ctx)()
+	return i.interpret(exp)
+}
+
+// start meters what i runs until the returned function is called.
+func (i *Interpreter) start(ctx context.Context) func() {
+	meter, cancel := NewMeter(ctx, i.Limits)
+	i.meter = meter
+	i.env.Negate = i.Arithmetic.Negate
+	return func() {
+		i.meter = nil
+		cancel()
+	}
+}
+
+func (i *Interpreter) interpret(intf ast.Expression) (value.Value, error) {
 	switch exp := intf.(type) {
 	case ast.BinaryExpression:
-		lhs, err := i.Interpret(exp.Lhs)
+		lhs, err := i.interpret(exp.Lhs)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-		rhs, err := i.Interpret(exp.Rhs)
-		if err != nil {
-			return 0, err
-		}
-
-		switch exp.Operator {
-		case ast.Add:
-			return lhs + rhs, nil
-		case ast.Subtract:
-			return lhs - rhs, nil
-		case ast.Multiply:
-			return lhs * rhs, nil
-		case ast.Divide:
-			return lhs / rhs, nil
-		case ast.LessThan:
-			if lhs < rhs {
-				return 1, nil
-			} else {
-				return 0, nil
-			}
-		case ast.LessOrEqual:
-			if lhs <= rhs {
-				return 1, nil
-			} else {
-				return 0, nil
-			}
-		case ast.GreaterThan:
-			if lhs > rhs {
-				return 1, nil
-			} else {
-				return 0, nil
-			}
-		case ast.GreaterOrEqual:
-			if lhs >= rhs {
-				return 1, nil
-			} else {
-				return 0, nil
+
+		if exp.Operator == ast.And || exp.Operator == ast.Or {
+			// NOTE: short-circuit, rhs is evaluated only if lhs does not decide the result
+			l, err := Truthy(lhs)
+			if err != nil {
+				return nil, &RuntimeError{Span: exp.Lhs.Range(), Err: err}
 			}
-		case ast.Equal:
-			if lhs == rhs {
-				return 1, nil
-			} else {
-				return 0, nil
+			if l == (exp.Operator == ast.Or) {
+				return value.Bool(l), nil
 			}
-		case ast.NotEqual:
-			if lhs != rhs {
-				return 1, nil
-			} else {
-				return 0, nil
+			r, err := i.evalCondition(exp.Rhs)
+			if err != nil {
+				return nil, err
 			}
-		default:
-			return 0, fmt.Errorf("invalid operator: %v", exp.Operator)
+			return value.Bool(r), nil
 		}
 
+		rhs, err := i.interpret(exp.Rhs)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := i.Arithmetic.BinaryOperation(exp.Operator, lhs, rhs)
+		if err != nil {
+			return nil, &RuntimeError{Span: exp.Span, Err: err}
+		}
+		return result, nil
+
+	case ast.UnaryExpression:
+		operand, err := i.interpret(exp.Operand)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := i.Arithmetic.UnaryOperation(exp.Operator, operand)
+		if err != nil {
+			return nil, &RuntimeError{Span: exp.Span, Err: err}
+		}
+		return result, nil
+
 	case ast.IntegerLiteral:
-		return exp.Value, nil
+		if exp.Big != nil {
+			v, err := i.Arithmetic.Literal(exp.Big)
+			if err != nil {
+				return nil, &RuntimeError{Span: exp.Span, Err: err}
+			}
+			return v, nil
+		}
+		return value.Int(exp.Value), nil
+
+	case ast.FloatLiteral:
+		return value.Float(exp.Value), nil
+
+	case ast.BooleanLiteral:
+		return value.Bool(exp.Value), nil
+
+	case ast.StringLiteral:
+		return value.String(exp.Value), nil
 
 	case ast.Identifier:
-		b := i.varEnv.FindBinding(exp.Name)
-		return b[exp.Name], nil
+		if b := i.varEnv.FindBinding(exp.Name); b != nil {
+			return b[exp.Name], nil
+		}
+		// NOTE: a function name evaluates to the function unless a variable hides it
+		if fn, ok := i.funcEnv[exp.Name]; ok {
+			return fn, nil
+		}
+		if b, ok := i.builtins.Lookup(exp.Name); ok {
+			return b, nil
+		}
+		return nil, NewUndefinedVariableError(exp.Span, exp.Name)
 
 	case ast.Assignment:
-		v, err := i.Interpret(exp.Expression)
+		v, err := i.interpret(exp.Expression)
 		if err != nil {
-			return 0, fmt.Errorf("failed to Interpert expression of assignment: %w", err)
+			return nil, err
 		}
 
 		b := i.varEnv.FindBinding(exp.Name)
@@ -118,21 +189,21 @@ func (i *Interpreter) Interpret(intf ast.Expression) (int, error) {
 	case ast.IfExpression:
 		cond, err := i.evalCondition(exp.Condition)
 		if err != nil {
-			return 0, fmt.Errorf("failed to eval condition of IfExpression")
+			return nil, err
 		}
 
-		var result int
+		var result value.Value
 		if cond /* NOTE: evaluate true if cond is not 0 */ {
-			result, err = i.Interpret(exp.ThenClause)
+			result, err = i.interpret(exp.ThenClause)
 		} else if exp.ElseClause.Expressions != nil {
-			result, err = i.Interpret(exp.ElseClause)
+			result, err = i.interpret(exp.ElseClause)
 		} else {
 			// NOTE: evaluate 1 if cond is false and elseClause is nil
-			return 1, nil
+			return value.Int(1), nil
 		}
 
 		if err != nil {
-			return 0, fmt.Errorf("failed to Interptret ThenClause of IfExpression: %w", err)
+			return nil, err
 		}
 		return result, nil
 
@@ -141,121 +212,409 @@ func (i *Interpreter) Interpret(intf ast.Expression) (int, error) {
 		for {
 			cond, err := i.evalCondition(exp.Condition)
 			if err != nil {
-				return 0, fmt.Errorf("failed to eval condition of WhileExpression: %w", err)
+				return nil, err
 			}
 
-			if cond {
-				if _, err := i.Interpret(exp.Body); err != nil {
-					return 0, fmt.Errorf("failed to Interpret body of WhileExp: %w", err)
-				}
-			} else {
+			if !cond {
 				break
 			}
+
+			if _, err := i.interpret(exp.Body); err != nil {
+				sig, ok := err.(*loopSignal)
+				if !ok {
+					return nil, err
+				}
+				if sig.isBreak {
+					break
+				}
+			}
+
+			if err := i.meter.Step(); err != nil {
+				return nil, &RuntimeError{Span: exp.Span, Err: err}
+			}
 		}
 
-		return 1, nil
+		return value.Int(1), nil
 
 	case ast.BlockExpression:
 		var err error
-		result := 0
+		var result value.Value = value.Int(0)
 
 		// evaluate all expressions, then return last expression.
 		for _, exp := range exp.Expressions {
-			result, err = i.Interpret(exp)
+			result, err = i.interpret(exp)
 			if err != nil {
-				return 0, fmt.Errorf("failed to Interpret one of Expressions of BlockExpression: %v", err)
+				return nil, err
 			}
 		}
 
 		return result, nil
 
 	case ast.Println:
-		result, err := i.Interpret(exp.Arg)
+		result, err := i.interpret(exp.Arg)
 		if err != nil {
-			return 0, fmt.Errorf("failed to Interpret Println: %v", err)
+			return nil, err
 		}
 
 		if _, err := fmt.Fprint(i.writer, result); err != nil {
-			return 0, err
+			return nil, err
 		}
 
 		return result, nil
 
-	case ast.FunctionCall:
-		funcDef, ok := i.funcEnv[exp.Name]
+	case ast.ReturnExpression:
+		var result value.Value = value.Int(0)
+		if exp.Value != nil {
+			var err error
+			result, err = i.interpret(exp.Value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, &returnSignal{span: exp.Span, value: result}
+
+	case ast.BreakExpression:
+		return nil, &loopSignal{span: exp.Span, isBreak: true}
+
+	case ast.ContinueExpression:
+		return nil, &loopSignal{span: exp.Span}
+
+	case ast.FunctionLiteral:
+		return &Function{Def: exp.Definition(), Env: i.varEnv}, nil
+
+	case ast.ListLiteral:
+		elements := make([]value.Value, len(exp.Elements))
+		for j, e := range exp.Elements {
+			v, err := i.interpret(e)
+			if err != nil {
+				return nil, err
+			}
+			elements[j] = v
+		}
+		return value.NewList(elements), nil
+
+	case ast.MapLiteral:
+		m := value.NewMap()
+		for j := range exp.Keys {
+			key, err := i.interpret(exp.Keys[j])
+			if err != nil {
+				return nil, err
+			}
+			v, err := i.interpret(exp.Values[j])
+			if err != nil {
+				return nil, err
+			}
+			if err := SetIndex(m, key, v); err != nil {
+				return nil, &RuntimeError{Span: exp.Keys[j].Range(), Err: err}
+			}
+		}
+		return m, nil
+
+	case ast.StructLiteral:
+		def, ok := i.structs[exp.Name]
 		if !ok {
-			return 0, fmt.Errorf("function %s is not found", exp.Name)
+			return nil, &RuntimeError{Span: exp.Span, Err: &UnknownStructError{Name: exp.Name}}
+		}
+
+		s := value.NewStruct(def)
+		for j, name := range exp.Fields {
+			v, err := i.interpret(exp.Values[j])
+			if err != nil {
+				return nil, err
+			}
+			if err := SetField(s, name, v); err != nil {
+				return nil, &RuntimeError{Span: exp.Span, Err: err}
+			}
+		}
+		return s, nil
+
+	case ast.FieldAccess:
+		object, err := i.interpret(exp.Object)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := Field(object, exp.Field)
+		if err != nil {
+			return nil, &RuntimeError{Span: exp.Span, Err: err}
 		}
+		return result, nil
 
-		var actualArgs []int
+	case ast.FieldAssignment:
+		object, err := i.interpret(exp.Object)
+		if err != nil {
+			return nil, err
+		}
+		v, err := i.interpret(exp.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := SetField(object, exp.Field, v); err != nil {
+			return nil, &RuntimeError{Span: exp.Span, Err: err}
+		}
+		return v, nil
+
+	case ast.IndexExpression:
+		collection, err := i.interpret(exp.Collection)
+		if err != nil {
+			return nil, err
+		}
+		index, err := i.interpret(exp.Index)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := Index(collection, index)
+		if err != nil {
+			return nil, &RuntimeError{Span: exp.Span, Err: err}
+		}
+		return result, nil
+
+	case ast.IndexAssignment:
+		collection, err := i.interpret(exp.Collection)
+		if err != nil {
+			return nil, err
+		}
+		index, err := i.interpret(exp.Index)
+		if err != nil {
+			return nil, err
+		}
+		v, err := i.interpret(exp.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := SetIndex(collection, index, v); err != nil {
+			return nil, &RuntimeError{Span: exp.Span, Err: err}
+		}
+		return v, nil
+
+	case ast.FunctionCall:
+		callee, err := i.callee(exp)
+		if err != nil {
+			return nil, err
+		}
+
+		var actualArgs []value.Value
 		for _, param := range exp.Args {
-			result, err := i.Interpret(param)
+			result, err := i.interpret(param)
 			if err != nil {
-				return 0, fmt.Errorf("failed to Interpret one of FunctionCall Args: %v", err)
+				return nil, err
 			}
 			actualArgs = append(actualArgs, result)
 		}
 
-		// make backup of variable definitions and restore later
-		varEnvBackup := i.varEnv
-		defer func() { i.varEnv = varEnvBackup }()
+		if err := i.meter.Step(); err != nil {
+			return nil, &RuntimeError{Span: exp.Span, Err: err}
+		}
 
-		// map function args to interpreter's variable definitions
-		i.varEnv = ast.NewEnvironment(i.varEnv)
-		for j, argName := range funcDef.Args {
-			i.varEnv.Bindings[argName] = actualArgs[j]
+		if b, ok := callee.(*Builtin); ok {
+			result, err := b.Call(actualArgs)
+			if err != nil {
+				return nil, NewCallError(b, exp.Span, err)
+			}
+			return result, nil
+		}
+
+		fn := callee.(*Function)
+		min, max := fn.Def.Arity()
+		if err := checkArity(fn.Def.Name, min, max, len(actualArgs)); err != nil {
+			return nil, &RuntimeError{Span: exp.Span, Err: err}
 		}
 
-		// interpret with function scoped variable definitions
-		result, err := i.Interpret(funcDef.Body)
+		if err := i.meter.Enter(); err != nil {
+			return nil, &RuntimeError{Span: exp.Span, Err: err}
+		}
+		result, err := i.call(fn, actualArgs)
+		i.meter.Leave()
 		if err != nil {
-			return 0, fmt.Errorf("failed to Interpret body of FunctionDefinition: %v", err)
+			return nil, trace(err, fn.Def.Name, exp.Span)
 		}
 
 		return result, nil
 
 	default:
-		return 0, fmt.Errorf("unexpected expression: %v", exp)
+		return nil, fmt.Errorf("unexpected expression: %v", exp)
 	}
 }
 
-func (i *Interpreter) CallMain(program ast.Program) (int, error) {
-	topLevels := program.Definitions
-	for _, topLevel := range topLevels {
-		funcDef, ok := topLevel.(ast.FunctionDefinition)
-		if ok {
-			i.funcEnv[funcDef.Name] = funcDef
-			continue
+// CallMain defines everything in program and calls its main function. Unless
+// DynamicScope is set, program is checked with CheckBuiltins first, and the
+// first error found is returned.
+func (i *Interpreter) CallMain(program ast.Program) (value.Value, error) {
+	return i.CallMainContext(context.Background(), program)
+}
+
+// CallMainContext is CallMain which stops with an error when ctx is done or a
+// limit of i.Limits is exceeded.
+func (i *Interpreter) CallMainContext(ctx context.Context, program ast.Program) (value.Value, error) {
+	defer i.start(ctx)()
+
+	if !i.DynamicScope {
+		if errs := CheckBuiltins(program, i.builtins); len(errs) > 0 {
+			return nil, errs[0]
 		}
+	}
 
-		globalVarDef, ok := topLevel.(ast.GlobalVariableDefinition)
-		if ok {
-			result, err := i.Interpret(globalVarDef.Expression)
-			if err != nil {
-				return 0, fmt.Errorf("failed to Interpret Expression of GlobalVariable Definition: %v", err)
-			}
-			i.varEnv.Bindings[globalVarDef.Name] = result
-			continue
+	for _, topLevel := range program.Definitions {
+		if err := i.Define(topLevel); err != nil {
+			return nil, err
 		}
 	}
 
 	if mainFunc, ok := i.funcEnv[MainFuncName]; ok {
-		result, err := i.Interpret(mainFunc.Body)
+		if err := i.meter.Enter(); err != nil {
+			return nil, &RuntimeError{Err: err}
+		}
+		result, err := i.call(mainFunc, nil)
+		i.meter.Leave()
 		if err != nil {
-			return 0, fmt.Errorf("failed to Interpret body of mainFunction: %v", err)
+			return nil, trace(err, MainFuncName, ast.Span{})
 		}
 		return result, nil
 	} else {
-		return 0, fmt.Errorf("this program doesn't have %s() function", MainFuncName)
+		return nil, fmt.Errorf("this program doesn't have %s() function", MainFuncName)
+	}
+}
+
+// Define registers a function or a struct, or evaluates and binds a global
+// variable.
+func (i *Interpreter) Define(topLevel ast.TopLevel) error {
+	switch def := topLevel.(type) {
+	case ast.FunctionDefinition:
+		i.funcEnv[def.Name] = &Function{Def: def, Env: i.globals}
+
+	case ast.StructDefinition:
+		i.structs[def.Name] = &value.StructType{Name: def.Name, Fields: def.Fields}
+
+	case ast.GlobalVariableDefinition:
+		result, err := i.interpret(def.Expression)
+		if err != nil {
+			return uncaught(err)
+		}
+		i.globals.Bindings[def.Name] = result
+
+	case ast.ImportDeclaration:
+		return &RuntimeError{Span: def.Span, Err: &UnresolvedImportError{Path: def.Path}}
+
+	default:
+		return fmt.Errorf("unexpected topLevel: %v", def)
+	}
+
+	return nil
+}
+
+// Globals returns a copy of the variables bound at top level.
+func (i *Interpreter) Globals() map[string]value.Value {
+	globals := map[string]value.Value{}
+	for name, v := range i.globals.Bindings {
+		globals[name] = v
+	}
+	return globals
+}
+
+// Functions returns the defined functions sorted by name.
+func (i *Interpreter) Functions() []ast.FunctionDefinition {
+	var funcDefs []ast.FunctionDefinition
+	for _, fn := range i.funcEnv {
+		funcDefs = append(funcDefs, fn.Def)
+	}
+	sort.Slice(funcDefs, func(a, b int) bool { return funcDefs[a].Name < funcDefs[b].Name })
+	return funcDefs
+}
+
+// callee returns the function called by exp, a *Function or a *Builtin. A
+// name refers to a variable holding a function before it refers to a defined
+// function, to a defined function before it refers to a host function, and to
+// a host function before it refers to a builtin.
+func (i *Interpreter) callee(exp ast.FunctionCall) (value.Value, error) {
+	var v value.Value
+	if exp.Callee != nil {
+		var err error
+		v, err = i.interpret(exp.Callee)
+		if err != nil {
+			return nil, err
+		}
+	} else if b := i.varEnv.FindBinding(exp.Name); b != nil {
+		v = b[exp.Name]
+	} else if fn, ok := i.funcEnv[exp.Name]; ok {
+		return fn, nil
+	} else if b, ok := i.builtins.Lookup(exp.Name); ok {
+		return b, nil
+	} else {
+		return nil, &RuntimeError{Span: exp.Span, Err: &UnknownFunctionError{Name: exp.Name}}
+	}
+
+	switch v.(type) {
+	case *Function, *Builtin:
+		return v, nil
+	}
+	return nil, newRuntimeError(exp.Span, "%s is not a function", v.Type())
+}
+
+// call interprets the body of fn in a new scope where its parameters are
+// bound to args. The scope encloses the scope fn was created in, or the
+// caller's scope if DynamicScope is set.
+func (i *Interpreter) call(fn *Function, args []value.Value) (value.Value, error) {
+	// make backup of variable definitions and restore later
+	varEnvBackup := i.varEnv
+	defer func() { i.varEnv = varEnvBackup }()
+
+	if i.DynamicScope {
+		i.varEnv = ast.NewEnvironment(i.varEnv)
+	} else {
+		i.varEnv = ast.NewEnvironment(fn.Env)
+	}
+
+	// map function args to interpreter's variable definitions
+	fixed := fn.Def.Args
+	if fn.Def.Variadic {
+		fixed = fixed[:len(fixed)-1]
+	}
+	for j, argName := range fixed {
+		if j < len(args) {
+			i.varEnv.Bindings[argName] = args[j]
+			continue
+		}
+
+		// NOTE: default values are evaluated for each call, after the arguments before them are bound
+		if defaultValue := fn.Def.Default(j); defaultValue != nil {
+			v, err := i.interpret(defaultValue)
+			if err != nil {
+				return nil, err
+			}
+			i.varEnv.Bindings[argName] = v
+		}
 	}
+	if fn.Def.Variadic {
+		var rest []value.Value
+		if len(args) > len(fixed) {
+			rest = append(rest, args[len(fixed):]...)
+		}
+		i.varEnv.Bindings[fn.Def.Args[len(fixed)]] = value.NewList(rest)
+	}
+
+	// interpret with function scoped variable definitions
+	result, err := i.interpret(fn.Def.Body)
+	if sig, ok := err.(*returnSignal); ok {
+		return sig.value, nil
+	}
+	if err != nil {
+		return nil, uncaught(err)
+	}
+	return result, nil
 }
 
 func (i *Interpreter) evalCondition(cond ast.Expression) (bool, error) {
-	condInt, err := i.Interpret(cond)
+	v, err := i.interpret(cond)
 	if err != nil {
-		return false, fmt.Errorf("failed to Interpret condition: %w", err)
+		return false, err
 	}
 
-	// eval as false if and only if condInt is 0
-	return condInt != 0, nil
+	ok, err := Truthy(v)
+	if err != nil {
+		return false, &RuntimeError{Span: cond.Range(), Err: err}
+	}
+	return ok, nil
 }
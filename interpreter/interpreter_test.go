@@ -1,498 +1,892 @@
-package interpreter
+package interpreter_test
 
 import (
-	"os"
+	"errors"
+	"io"
 	"testing"
 
 	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/interpreter"
+	"github.com/TOMOFUMI-KONDO/toy/value"
+	"github.com/TOMOFUMI-KONDO/toy/vm"
 )
 
-var (
-	interpreter Interpreter
-)
+type engine interface {
+	Interpret(exp ast.Expression) (value.Value, error)
+	CallMain(program ast.Program) (value.Value, error)
+}
 
-func TestMain(m *testing.M) {
-	interpreter = NewInterpreter()
+// engines are the implementations every test below runs on, which must give
+// the same results.
+var engines = []struct {
+	name string
+	new  func() engine
+}{
+	{
+		"interpreter",
+		func() engine {
+			i := interpreter.NewInterpreterWithWriter(io.Discard)
+			return &i
+		},
+	},
+	{
+		"vm",
+		func() engine {
+			m := vm.NewVMWithWriter(io.Discard)
+			return &m
+		},
+	},
+}
 
-	code := m.Run()
-	os.Exit(code)
+// forEngines runs test on a new instance of each engine.
+func forEngines(t *testing.T, test func(t *testing.T, e engine)) {
+	for _, e := range engines {
+		t.Run(e.name, func(t *testing.T) {
+			test(t, e.new())
+		})
+	}
 }
 
 func TestInterpreterInteger(t *testing.T) {
-	exp := ast.NewInteger(1)
+	forEngines(t, func(t *testing.T, e engine) {
+		exp := ast.NewInteger(1)
 
-	result, err := interpreter.Interpret(exp)
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 1 {
-		t.Errorf("result = %d; want 1", result)
-	}
+		result, err := e.Interpret(exp)
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Int(1) {
+			t.Errorf("result = %v; want 1", result)
+		}
+	})
 }
 
 func TestInterpretAdd(t *testing.T) {
-	exp := ast.NewAdd(ast.NewInteger(1), ast.NewInteger(2))
+	forEngines(t, func(t *testing.T, e engine) {
+		exp := ast.NewAdd(ast.NewInteger(1), ast.NewInteger(2))
 
-	result, err := interpreter.Interpret(exp)
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 3 {
-		t.Errorf("result = %d; want 3", result)
-	}
+		result, err := e.Interpret(exp)
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Int(3) {
+			t.Errorf("result = %v; want 3", result)
+		}
+	})
 }
 
 func TestInterpretSubtract(t *testing.T) {
-	exp := ast.NewSubtract(ast.NewInteger(10), ast.NewInteger(3))
+	forEngines(t, func(t *testing.T, e engine) {
+		exp := ast.NewSubtract(ast.NewInteger(10), ast.NewInteger(3))
 
-	result, err := interpreter.Interpret(exp)
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 7 {
-		t.Errorf("result = %d; want 7", result)
-	}
+		result, err := e.Interpret(exp)
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Int(7) {
+			t.Errorf("result = %v; want 7", result)
+		}
+	})
 }
 
 func TestInterpretMultiply(t *testing.T) {
-	exp := ast.NewMultiply(ast.NewInteger(2), ast.NewInteger(5))
+	forEngines(t, func(t *testing.T, e engine) {
+		exp := ast.NewMultiply(ast.NewInteger(2), ast.NewInteger(5))
 
-	result, err := interpreter.Interpret(exp)
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 10 {
-		t.Errorf("result = %d; want 10", result)
-	}
+		result, err := e.Interpret(exp)
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Int(10) {
+			t.Errorf("result = %v; want 10", result)
+		}
+	})
 }
 
 func TestInterpretDivide(t *testing.T) {
-	exp := ast.NewDivide(ast.NewInteger(10), ast.NewInteger(2))
+	forEngines(t, func(t *testing.T, e engine) {
+		exp := ast.NewDivide(ast.NewInteger(10), ast.NewInteger(2))
 
-	result, err := interpreter.Interpret(exp)
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 5 {
-		t.Errorf("result = %d; want 5", result)
-	}
+		result, err := e.Interpret(exp)
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Int(5) {
+			t.Errorf("result = %v; want 5", result)
+		}
+	})
 }
 
 func TestInterpreterLessThan(t *testing.T) {
-	result, err := interpreter.Interpret(ast.NewLessThan(
-		ast.NewInteger(1),
-		ast.NewInteger(2),
-	))
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 1 {
-		t.Errorf("result = %d; want 1", result)
-	}
+	forEngines(t, func(t *testing.T, e engine) {
+		result, err := e.Interpret(ast.NewLessThan(
+			ast.NewInteger(1),
+			ast.NewInteger(2),
+		))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(true) {
+			t.Errorf("result = %v; want true", result)
+		}
 
-	result, err = interpreter.Interpret(ast.NewLessThan(
-		ast.NewInteger(2),
-		ast.NewInteger(2),
-	))
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 0 {
-		t.Errorf("result = %d; want 0", result)
-	}
+		result, err = e.Interpret(ast.NewLessThan(
+			ast.NewInteger(2),
+			ast.NewInteger(2),
+		))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(false) {
+			t.Errorf("result = %v; want false", result)
+		}
 
-	result, err = interpreter.Interpret(ast.NewLessThan(
-		ast.NewInteger(3),
-		ast.NewInteger(2),
-	))
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 0 {
-		t.Errorf("result = %d; want 0", result)
-	}
+		result, err = e.Interpret(ast.NewLessThan(
+			ast.NewInteger(3),
+			ast.NewInteger(2),
+		))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(false) {
+			t.Errorf("result = %v; want false", result)
+		}
+	})
 }
 
 func TestInterpreterLessOrEqual(t *testing.T) {
-	result, err := interpreter.Interpret(ast.NewLessOrEqual(
-		ast.NewInteger(1),
-		ast.NewInteger(2),
-	))
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 1 {
-		t.Errorf("result = %d; want 1", result)
-	}
+	forEngines(t, func(t *testing.T, e engine) {
+		result, err := e.Interpret(ast.NewLessOrEqual(
+			ast.NewInteger(1),
+			ast.NewInteger(2),
+		))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(true) {
+			t.Errorf("result = %v; want true", result)
+		}
 
-	result, err = interpreter.Interpret(ast.NewLessOrEqual(
-		ast.NewInteger(2),
-		ast.NewInteger(2),
-	))
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 1 {
-		t.Errorf("result = %d; want 1", result)
-	}
+		result, err = e.Interpret(ast.NewLessOrEqual(
+			ast.NewInteger(2),
+			ast.NewInteger(2),
+		))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(true) {
+			t.Errorf("result = %v; want true", result)
+		}
 
-	result, err = interpreter.Interpret(ast.NewLessOrEqual(
-		ast.NewInteger(3),
-		ast.NewInteger(2),
-	))
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 0 {
-		t.Errorf("result = %d; want 0", result)
-	}
+		result, err = e.Interpret(ast.NewLessOrEqual(
+			ast.NewInteger(3),
+			ast.NewInteger(2),
+		))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(false) {
+			t.Errorf("result = %v; want false", result)
+		}
+	})
 }
 
 func TestInterpreterGreaterThan(t *testing.T) {
-	result, err := interpreter.Interpret(ast.NewGreaterThan(
-		ast.NewInteger(1),
-		ast.NewInteger(2),
-	))
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 0 {
-		t.Errorf("result = %d; want 0", result)
-	}
+	forEngines(t, func(t *testing.T, e engine) {
+		result, err := e.Interpret(ast.NewGreaterThan(
+			ast.NewInteger(1),
+			ast.NewInteger(2),
+		))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(false) {
+			t.Errorf("result = %v; want false", result)
+		}
 
-	result, err = interpreter.Interpret(ast.NewGreaterThan(
-		ast.NewInteger(2),
-		ast.NewInteger(2),
-	))
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 0 {
-		t.Errorf("result = %d; want 0", result)
-	}
+		result, err = e.Interpret(ast.NewGreaterThan(
+			ast.NewInteger(2),
+			ast.NewInteger(2),
+		))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(false) {
+			t.Errorf("result = %v; want false", result)
+		}
 
-	result, err = interpreter.Interpret(ast.NewGreaterThan(
-		ast.NewInteger(3),
-		ast.NewInteger(2),
-	))
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 1 {
-		t.Errorf("result = %d; want 1", result)
-	}
+		result, err = e.Interpret(ast.NewGreaterThan(
+			ast.NewInteger(3),
+			ast.NewInteger(2),
+		))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(true) {
+			t.Errorf("result = %v; want true", result)
+		}
+	})
 }
 
 func TestInterpreterEqual(t *testing.T) {
-	result, err := interpreter.Interpret(ast.NewEqual(
-		ast.NewInteger(1),
-		ast.NewInteger(2),
-	))
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 0 {
-		t.Errorf("result = %d; want 0", result)
-	}
+	forEngines(t, func(t *testing.T, e engine) {
+		result, err := e.Interpret(ast.NewEqual(
+			ast.NewInteger(1),
+			ast.NewInteger(2),
+		))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(false) {
+			t.Errorf("result = %v; want false", result)
+		}
 
-	result, err = interpreter.Interpret(ast.NewEqual(
-		ast.NewInteger(2),
-		ast.NewInteger(2),
-	))
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 1 {
-		t.Errorf("result = %d; want 1", result)
-	}
+		result, err = e.Interpret(ast.NewEqual(
+			ast.NewInteger(2),
+			ast.NewInteger(2),
+		))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(true) {
+			t.Errorf("result = %v; want true", result)
+		}
 
-	result, err = interpreter.Interpret(ast.NewEqual(
-		ast.NewInteger(3),
-		ast.NewInteger(2),
-	))
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 0 {
-		t.Errorf("result = %d; want 0", result)
-	}
+		result, err = e.Interpret(ast.NewEqual(
+			ast.NewInteger(3),
+			ast.NewInteger(2),
+		))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(false) {
+			t.Errorf("result = %v; want false", result)
+		}
+	})
 }
 
 func TestInterpreterNotEqual(t *testing.T) {
-	result, err := interpreter.Interpret(ast.NewNotEqual(
-		ast.NewInteger(1),
-		ast.NewInteger(2),
-	))
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 1 {
-		t.Errorf("result = %d; want 1", result)
-	}
+	forEngines(t, func(t *testing.T, e engine) {
+		result, err := e.Interpret(ast.NewNotEqual(
+			ast.NewInteger(1),
+			ast.NewInteger(2),
+		))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(true) {
+			t.Errorf("result = %v; want true", result)
+		}
 
-	result, err = interpreter.Interpret(ast.NewNotEqual(
-		ast.NewInteger(2),
-		ast.NewInteger(2),
-	))
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 0 {
-		t.Errorf("result = %d; want 0", result)
-	}
+		result, err = e.Interpret(ast.NewNotEqual(
+			ast.NewInteger(2),
+			ast.NewInteger(2),
+		))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(false) {
+			t.Errorf("result = %v; want false", result)
+		}
 
-	result, err = interpreter.Interpret(ast.NewNotEqual(
-		ast.NewInteger(3),
-		ast.NewInteger(2),
-	))
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 1 {
-		t.Errorf("result = %d; want 1", result)
-	}
+		result, err = e.Interpret(ast.NewNotEqual(
+			ast.NewInteger(3),
+			ast.NewInteger(2),
+		))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(true) {
+			t.Errorf("result = %v; want true", result)
+		}
+	})
 }
 
 func TestInterpreterGreaterOrEqual(t *testing.T) {
-	result, err := interpreter.Interpret(ast.NewGreaterOrEqual(
-		ast.NewInteger(1),
-		ast.NewInteger(2),
-	))
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 0 {
-		t.Errorf("result = %d; want 0", result)
-	}
+	forEngines(t, func(t *testing.T, e engine) {
+		result, err := e.Interpret(ast.NewGreaterOrEqual(
+			ast.NewInteger(1),
+			ast.NewInteger(2),
+		))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(false) {
+			t.Errorf("result = %v; want false", result)
+		}
 
-	result, err = interpreter.Interpret(ast.NewGreaterOrEqual(
-		ast.NewInteger(2),
-		ast.NewInteger(2),
-	))
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 1 {
-		t.Errorf("result = %d; want 1", result)
-	}
+		result, err = e.Interpret(ast.NewGreaterOrEqual(
+			ast.NewInteger(2),
+			ast.NewInteger(2),
+		))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(true) {
+			t.Errorf("result = %v; want true", result)
+		}
 
-	result, err = interpreter.Interpret(ast.NewGreaterOrEqual(
-		ast.NewInteger(3),
-		ast.NewInteger(2),
-	))
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 1 {
-		t.Errorf("result = %d; want 1", result)
-	}
+		result, err = e.Interpret(ast.NewGreaterOrEqual(
+			ast.NewInteger(3),
+			ast.NewInteger(2),
+		))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(true) {
+			t.Errorf("result = %v; want true", result)
+		}
+	})
 }
 
 func TestInterpreterIdentifier(t *testing.T) {
-	interpreter.varEnv = &ast.Environment{Bindings: map[string]int{"key": 1}}
+	forEngines(t, func(t *testing.T, e engine) {
+		if _, err := e.Interpret(ast.NewAssignment("key", ast.NewInteger(1))); err != nil {
+			t.Fatalf("failed to Interpret: %v", err)
+		}
 
-	exp := ast.NewIdentifier("key")
-	result, err := interpreter.Interpret(exp)
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 1 {
-		t.Errorf("result = %d; want 1", result)
-	}
+		exp := ast.NewIdentifier("key")
+		result, err := e.Interpret(exp)
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Int(1) {
+			t.Errorf("result = %v; want 1", result)
+		}
+	})
 }
 
 func TestInterpretAssignment(t *testing.T) {
-	exp := ast.NewAssignment("key", ast.NewInteger(1))
-	result, err := interpreter.Interpret(exp)
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 1 {
-		t.Errorf("result = %d; want 1", result)
-	}
-	// varEnv should be set
-	cond := interpreter.varEnv.Bindings["key"]
-	if cond != 1 {
-		t.Errorf("varEnv.key = %d; want 1", cond)
-	}
+	forEngines(t, func(t *testing.T, e engine) {
+		exp := ast.NewAssignment("key", ast.NewInteger(1))
+		result, err := e.Interpret(exp)
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Int(1) {
+			t.Errorf("result = %v; want 1", result)
+		}
+		// the variable should be set
+		key, err := e.Interpret(ast.NewIdentifier("key"))
+		if err != nil || key != value.Int(1) {
+			t.Errorf("key = %v, %v; want 1", key, err)
+		}
+	})
 }
 
 func TestInterpreterIf(t *testing.T) {
-	exp := ast.NewIf(
-		ast.NewEqual(ast.NewInteger(1), ast.NewInteger(1)), // true
-		ast.NewBlock([]ast.Expression{ast.NewInteger(2)}),
-		ast.NewBlock([]ast.Expression{ast.NewInteger(3)}),
-	)
-	result, err := interpreter.Interpret(exp)
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 2 {
-		t.Errorf("result = %d; want 2", result)
-	}
+	forEngines(t, func(t *testing.T, e engine) {
+		exp := ast.NewIf(
+			ast.NewEqual(ast.NewInteger(1), ast.NewInteger(1)), // true
+			ast.NewBlock([]ast.Expression{ast.NewInteger(2)}),
+			ast.NewBlock([]ast.Expression{ast.NewInteger(3)}),
+		)
+		result, err := e.Interpret(exp)
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Int(2) {
+			t.Errorf("result = %v; want 2", result)
+		}
 
-	exp.Condition = ast.NewNotEqual(ast.NewInteger(1), ast.NewInteger(1)) //false
-	result, err = interpreter.Interpret(exp)
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 3 {
-		t.Errorf("result = %d; want 3", result)
-	}
+		exp.Condition = ast.NewNotEqual(ast.NewInteger(1), ast.NewInteger(1)) //false
+		result, err = e.Interpret(exp)
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Int(3) {
+			t.Errorf("result = %v; want 3", result)
+		}
 
-	exp.ElseClause.Expressions = nil
-	result, err = interpreter.Interpret(exp)
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	// should be evaluated 1 if ElseClause is nil
-	if result != 1 {
-		t.Errorf("result = %d; want 1", result)
-	}
+		exp.ElseClause.Expressions = nil
+		result, err = e.Interpret(exp)
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		// should be evaluated 1 if ElseClause is nil
+		if result != value.Int(1) {
+			t.Errorf("result = %v; want 1", result)
+		}
+	})
 }
 
 func TestInterpreterWhile(t *testing.T) {
-	interpreter.varEnv = &ast.Environment{Bindings: map[string]int{"condition": 10}}
+	forEngines(t, func(t *testing.T, e engine) {
+		if _, err := e.Interpret(ast.NewAssignment("condition", ast.NewInteger(10))); err != nil {
+			t.Fatalf("failed to Interpret: %v", err)
+		}
 
-	identifier := ast.NewIdentifier("condition")
+		identifier := ast.NewIdentifier("condition")
 
-	/*
-		while condition != 0 {
-			condition = condition - 1
+		/*
+			while condition != 0 {
+				condition = condition - 1
+			}
+		*/
+		exp := ast.NewWhile(
+			identifier,
+			ast.NewBlock([]ast.Expression{
+				ast.NewAssignment(
+					"condition",
+					ast.NewSubtract(identifier, ast.NewInteger(1)),
+				),
+			}),
+		)
+
+		result, err := e.Interpret(exp)
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
 		}
-	*/
-	exp := ast.NewWhile(
-		identifier,
-		ast.NewBlock([]ast.Expression{
-			ast.NewAssignment(
-				"condition",
-				ast.NewSubtract(identifier, ast.NewInteger(1)),
-			),
-		}),
-	)
-
-	result, err := interpreter.Interpret(exp)
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 1 {
-		t.Errorf("result = %d; want 1", result)
-	}
-	cond := interpreter.varEnv.Bindings["condition"]
-	if cond != 0 {
-		t.Errorf("varEnv.condition = %d; want 0", cond)
-	}
+		if result != value.Int(1) {
+			t.Errorf("result = %v; want 1", result)
+		}
+		cond, err := e.Interpret(identifier)
+		if err != nil || cond != value.Int(0) {
+			t.Errorf("condition = %v, %v; want 0", cond, err)
+		}
+	})
 }
 
 func TestInterpreterBlock(t *testing.T) {
-	identifier := ast.NewIdentifier("a")
+	forEngines(t, func(t *testing.T, e engine) {
+		identifier := ast.NewIdentifier("a")
 
-	/*
-		a = 0
-		a = a + 10
-		a * 2
-	*/
-	exp := ast.NewBlock(
-		[]ast.Expression{
-			ast.NewAssignment("a", ast.NewInteger(0)),
-			ast.NewAssignment("a", ast.NewAdd(identifier, ast.NewInteger(10))),
-			ast.NewMultiply(identifier, ast.NewInteger(2)),
-		},
-	)
+		/*
+			a = 0
+			a = a + 10
+			a * 2
+		*/
+		exp := ast.NewBlock(
+			[]ast.Expression{
+				ast.NewAssignment("a", ast.NewInteger(0)),
+				ast.NewAssignment("a", ast.NewAdd(identifier, ast.NewInteger(10))),
+				ast.NewMultiply(identifier, ast.NewInteger(2)),
+			},
+		)
 
-	result, err := interpreter.Interpret(exp)
-	if err != nil {
-		t.Errorf("failed to Interpret: %v", err)
-	}
-	if result != 20 {
-		t.Errorf("result = %d; want 20", result)
-	}
-	a := interpreter.varEnv.Bindings["a"]
-	if a != 10 {
-		t.Errorf("environemnt.a = %d; want 10", a)
-	}
+		result, err := e.Interpret(exp)
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Int(20) {
+			t.Errorf("result = %v; want 20", result)
+		}
+		a, err := e.Interpret(identifier)
+		if err != nil || a != value.Int(10) {
+			t.Errorf("a = %v, %v; want 10", a, err)
+		}
+
+		result, err = e.Interpret(ast.NewBlock(nil))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Int(0) {
+			t.Errorf("empty block = %v; want 0", result)
+		}
+	})
 }
 
 func TestInterpreterPrintln(t *testing.T) {
-	result, err := interpreter.Interpret(ast.NewPrintln(ast.NewInteger(2)))
-	if err != nil {
-		t.Errorf("failed to Interpret Println: %v", err)
-	}
-	if result != 2 {
-		t.Errorf("result = %d; want 2", result)
-	}
+	forEngines(t, func(t *testing.T, e engine) {
+		result, err := e.Interpret(ast.NewPrintln(ast.NewInteger(2)))
+		if err != nil {
+			t.Errorf("failed to Interpret Println: %v", err)
+		}
+		if result != value.Int(2) {
+			t.Errorf("result = %v; want 2", result)
+		}
+	})
 }
 
 func TestInterpreterDefineAndCallFunction(t *testing.T) {
-	n := ast.NewIdentifier("n")
-	topLevels := []ast.TopLevel{
-		/*
-			define main() {
-				n = 0
-				fact(5);
-			}
-		*/
-		ast.NewFuncDef("main", nil, ast.NewBlock(
-			[]ast.Expression{
-				ast.NewAssignment("n", ast.NewInteger(0)), // This will be overwritten by argument in fact().
-				ast.NewFuncCall("fact", []ast.Expression{ast.NewInteger(5)}),
-			},
-		)),
-		/*
-			define fact(n) {
-				if(n < 2)  {
-					1;
-				} else {
-					n  * fact(n - 1);
+	forEngines(t, func(t *testing.T, e engine) {
+		n := ast.NewIdentifier("n")
+		topLevels := []ast.TopLevel{
+			/*
+				define main() {
+					n = 0
+					fact(5);
 				}
-			}
-		*/
-		ast.NewFuncDef("fact", []string{"n"}, ast.NewBlock([]ast.Expression{
-			ast.NewIf(
-				ast.NewLessThan(n, ast.NewInteger(2)),
-				ast.NewBlock([]ast.Expression{ast.NewInteger(1)}),
-				ast.NewBlock([]ast.Expression{
-					ast.NewMultiply(n, ast.NewFuncCall("fact", []ast.Expression{
-						ast.NewSubtract(n, ast.NewInteger(1)),
-					})),
-				}),
-			),
+			*/
+			ast.NewFuncDef("main", nil, ast.NewBlock(
+				[]ast.Expression{
+					ast.NewAssignment("n", ast.NewInteger(0)), // This will be overwritten by argument in fact().
+					ast.NewFuncCall("fact", []ast.Expression{ast.NewInteger(5)}),
+				},
+			)),
+			/*
+				define fact(n) {
+					if(n < 2)  {
+						1;
+					} else {
+						n  * fact(n - 1);
+					}
+				}
+			*/
+			ast.NewFuncDef("fact", []string{"n"}, ast.NewBlock([]ast.Expression{
+				ast.NewIf(
+					ast.NewLessThan(n, ast.NewInteger(2)),
+					ast.NewBlock([]ast.Expression{ast.NewInteger(1)}),
+					ast.NewBlock([]ast.Expression{
+						ast.NewMultiply(n, ast.NewFuncCall("fact", []ast.Expression{
+							ast.NewSubtract(n, ast.NewInteger(1)),
+						})),
+					}),
+				),
+			})),
+		}
+
+		result, err := e.CallMain(ast.NewProgram(topLevels))
+		if err != nil {
+			t.Errorf("failed to CallMain: %v", err)
+		}
+		// 5! = 120
+		if result != value.Int(120) {
+			t.Errorf("result = %v; want 120", result)
+		}
+	})
+}
+
+func TestInterpreterGlobalVarDef(t *testing.T) {
+	forEngines(t, func(t *testing.T, e engine) {
+		topLevels := []ast.TopLevel{
+			/*
+				n = 1
+				m = 2
+
+				define main() {
+					n + m
+				}
+			*/
+			ast.NewGlobalVarDef("n", ast.NewInteger(1)),
+			ast.NewGlobalVarDef("m", ast.NewInteger(3)), // This will be overwritten in main().
+			ast.NewFuncDef("main", nil, ast.NewBlock([]ast.Expression{
+				ast.NewAssignment("m", ast.NewInteger(2)),
+				ast.NewAdd(ast.NewIdentifier("n"), ast.NewIdentifier("m")),
+			})),
+		}
+
+		result, err := e.CallMain(ast.NewProgram(topLevels))
+		if err != nil {
+			t.Errorf("failed to CallMain: %v", err)
+		}
+		if result != value.Int(3) {
+			t.Errorf("result = %v; want 3", result)
+		}
+	})
+}
+
+func TestInterpreterString(t *testing.T) {
+	forEngines(t, func(t *testing.T, e engine) {
+		result, err := e.Interpret(ast.NewString("toy"))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.String("toy") {
+			t.Errorf("result = %v; want toy", result)
+		}
+
+		result, err = e.Interpret(ast.NewAdd(ast.NewString("n="), ast.NewInteger(1)))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.String("n=1") {
+			t.Errorf("result = %v; want n=1", result)
+		}
+
+		result, err = e.Interpret(ast.NewEqual(ast.NewString("a"), ast.NewString("a")))
+		if err != nil {
+			t.Errorf("failed to Interpret: %v", err)
+		}
+		if result != value.Bool(true) {
+			t.Errorf("result = %v; want true", result)
+		}
+
+		_, err = e.Interpret(ast.NewMultiply(ast.NewString("a"), ast.NewInteger(2)))
+		if err == nil {
+			t.Errorf("Interpret succeeded; want error for string * int")
+		}
+	})
+}
+
+func TestInterpreterLexicalScope(t *testing.T) {
+	/*
+		define callee() {
+			x = 5
+		}
+
+		define main() {
+			x = 1
+			callee()
+			x
+		}
+	*/
+	program := ast.NewProgram([]ast.TopLevel{
+		ast.NewFuncDef("callee", nil, ast.NewBlock([]ast.Expression{
+			ast.NewAssignment("x", ast.NewInteger(5)),
 		})),
-	}
+		ast.NewFuncDef("main", nil, ast.NewBlock([]ast.Expression{
+			ast.NewAssignment("x", ast.NewInteger(1)),
+			ast.NewFuncCall("callee", nil),
+			ast.NewIdentifier("x"),
+		})),
+	})
 
-	result, err := interpreter.CallMain(ast.NewProgram(topLevels))
+	forEngines(t, func(t *testing.T, e engine) {
+		result, err := e.CallMain(program)
+		if err != nil {
+			t.Errorf("failed to CallMain: %v", err)
+		}
+		// callee() assigns its own x
+		if result != value.Int(1) {
+			t.Errorf("result = %v; want 1", result)
+		}
+	})
+
+	// only the interpreter has DynamicScope
+	i := interpreter.NewInterpreter()
+	i.DynamicScope = true
+	result, err := i.CallMain(program)
 	if err != nil {
 		t.Errorf("failed to CallMain: %v", err)
 	}
-	// 5! = 120
-	if result != 120 {
-		t.Errorf("result = %d; want 120", result)
+	// callee() overwrites x of main()
+	if result != value.Int(5) {
+		t.Errorf("result = %v; want 5 with DynamicScope", result)
 	}
 }
 
-func TestInterpreterGlobalVarDef(t *testing.T) {
-	topLevels := []ast.TopLevel{
+func TestInterpreterClosure(t *testing.T) {
+	forEngines(t, func(t *testing.T, e engine) {
 		/*
-			n = 1
-			m = 2
+			define adder(n) {
+				define(x) { x + n }
+			}
 
 			define main() {
-				n + m
+				add = adder(2)
+				add(3)
 			}
 		*/
-		ast.NewGlobalVarDef("n", ast.NewInteger(1)),
-		ast.NewGlobalVarDef("m", ast.NewInteger(3)), // This will be overwritten in main().
+		program := ast.NewProgram([]ast.TopLevel{
+			ast.NewFuncDef("adder", []string{"n"}, ast.NewBlock([]ast.Expression{
+				ast.NewFuncLiteral([]string{"x"}, ast.NewBlock([]ast.Expression{
+					ast.NewAdd(ast.NewIdentifier("x"), ast.NewIdentifier("n")),
+				})),
+			})),
+			ast.NewFuncDef("main", nil, ast.NewBlock([]ast.Expression{
+				ast.NewAssignment("add", ast.NewFuncCall("adder", []ast.Expression{ast.NewInteger(2)})),
+				ast.NewFuncCall("add", []ast.Expression{ast.NewInteger(3)}),
+			})),
+		})
+
+		result, err := e.CallMain(program)
+		if err != nil {
+			t.Fatalf("failed to CallMain: %v", err)
+		}
+		if result != value.Int(5) {
+			t.Errorf("result = %v; want 5", result)
+		}
+	})
+}
+
+func TestInterpreterClosureAssignment(t *testing.T) {
+	/*
+		define counter() {
+			n = 0
+			define() { n = n + 1 }
+		}
+
+		define main() {
+			next = counter()
+			next()
+			next()
+		}
+	*/
+	n := ast.NewIdentifier("n")
+	next := ast.NewIdentifier("next")
+	program := ast.NewProgram([]ast.TopLevel{
+		ast.NewFuncDef("counter", nil, ast.NewBlock([]ast.Expression{
+			ast.NewAssignment("n", ast.NewInteger(0)),
+			ast.NewFuncLiteral(nil, ast.NewBlock([]ast.Expression{
+				ast.NewAssignment("n", ast.NewAdd(n, ast.NewInteger(1))),
+			})),
+		})),
 		ast.NewFuncDef("main", nil, ast.NewBlock([]ast.Expression{
-			ast.NewAssignment("m", ast.NewInteger(2)),
-			ast.NewAdd(ast.NewIdentifier("n"), ast.NewIdentifier("m")),
+			ast.NewAssignment("next", ast.NewFuncCall("counter", nil)),
+			ast.NewCall(next, nil),
+			ast.NewCall(next, nil),
 		})),
+	})
+
+	forEngines(t, func(t *testing.T, e engine) {
+		result, err := e.CallMain(program)
+		if err != nil {
+			t.Fatalf("failed to CallMain: %v", err)
+		}
+		// the closure assigns the n of counter()
+		if result != value.Int(2) {
+			t.Errorf("result = %v; want 2", result)
+		}
+	})
+}
+
+func TestInterpreterCallMainErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  ast.Program
+		expected string
+	}{
+		{
+			"no main",
+			ast.NewProgram(nil),
+			"this program doesn't have main() function",
+		},
+		{
+			"unknown function",
+			ast.NewProgram([]ast.TopLevel{
+				ast.NewFuncDef("main", nil, ast.NewBlock([]ast.Expression{
+					ast.NewFuncCall("foo", nil),
+				})),
+			}),
+			"function foo is not found",
+		},
 	}
 
-	result, err := interpreter.CallMain(ast.NewProgram(topLevels))
-	if err != nil {
-		t.Errorf("failed to CallMain: %v", err)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			forEngines(t, func(t *testing.T, e engine) {
+				_, err := e.CallMain(test.program)
+				if err == nil {
+					t.Fatalf("CallMain succeeded; want error %q", test.expected)
+				}
+				if err.Error() != test.expected {
+					t.Errorf("err = %q; want %q", err, test.expected)
+				}
+			})
+		})
 	}
-	if result != 3 {
-		t.Errorf("result = %d; want 3", result)
+}
+
+func TestInterpreterUndefinedVariable(t *testing.T) {
+	forEngines(t, func(t *testing.T, e engine) {
+		_, err := e.Interpret(ast.NewIdentifier("typo"))
+
+		var uerr *interpreter.UndefinedVariableError
+		if !errors.As(err, &uerr) {
+			t.Fatalf("err = %v; want UndefinedVariableError", err)
+		}
+		if uerr.Name != "typo" {
+			t.Errorf("Name = %q; want typo", uerr.Name)
+		}
+	})
+}
+
+func TestCheck(t *testing.T) {
+	/*
+		define main() {
+			if 1 { x = 1 }
+			println(x)
+			y
+			greet(5)
+			len()
+		}
+	*/
+	program := ast.NewProgram([]ast.TopLevel{
+		ast.NewFuncDef("main", nil, ast.NewBlock([]ast.Expression{
+			ast.NewIfWithoutElse(ast.NewInteger(1), ast.NewBlock([]ast.Expression{
+				ast.NewAssignment("x", ast.NewInteger(1)),
+			})),
+			ast.NewPrintln(ast.NewIdentifier("x")),
+			ast.NewIdentifier("y"),
+			ast.NewFuncCall("greet", []ast.Expression{ast.NewInteger(5)}),
+			ast.NewFuncCall("len", nil),
+		})),
+	})
+
+	errs := interpreter.Check(program)
+
+	// x may be assigned, which is left to the runtime
+	expected := []string{
+		"variable y is not defined",
+		"function greet is not found",
+		"function len takes 1 arguments but 0 were given",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("errs = %v; want %d errors", errs, len(expected))
+	}
+	var uerr *interpreter.UndefinedVariableError
+	if !errors.As(errs[0], &uerr) {
+		t.Errorf("errs[0] = %v; want UndefinedVariableError", errs[0])
+	}
+	for j, err := range errs {
+		if err.Error() != expected[j] {
+			t.Errorf("errs[%d] = %q; want %q", j, err.Error(), expected[j])
+		}
+	}
+}
+
+func TestCheckLoop(t *testing.T) {
+	/*
+		define main() {
+			println(last)
+			i = 0
+			while i < 3 {
+				if i > 0 { println(prev) }
+				prev = i
+				i = i + 1
+			}
+			last = prev
+		}
+	*/
+	program := ast.NewProgram([]ast.TopLevel{
+		ast.NewFuncDef("main", nil, ast.NewBlock([]ast.Expression{
+			ast.NewPrintln(ast.NewIdentifier("last")),
+			ast.NewAssignment("i", ast.NewInteger(0)),
+			ast.NewWhile(ast.NewLessThan(ast.NewIdentifier("i"), ast.NewInteger(3)), ast.NewBlock([]ast.Expression{
+				ast.NewIfWithoutElse(ast.NewGreaterThan(ast.NewIdentifier("i"), ast.NewInteger(0)), ast.NewBlock([]ast.Expression{
+					ast.NewPrintln(ast.NewIdentifier("prev")),
+				})),
+				ast.NewAssignment("prev", ast.NewIdentifier("i")),
+				ast.NewAssignment("i", ast.NewAdd(ast.NewIdentifier("i"), ast.NewInteger(1))),
+			})),
+			ast.NewAssignment("last", ast.NewIdentifier("prev")),
+		})),
+	})
+
+	errs := interpreter.Check(program)
+
+	// prev is read on a later iteration than it is assigned on
+	if len(errs) != 1 || errs[0].Error() != "variable last is not defined" {
+		t.Errorf("errs = %v; want [variable last is not defined]", errs)
 	}
 }
+
+func TestInterpreterReturn(t *testing.T) {
+	forEngines(t, func(t *testing.T, e engine) {
+		/*
+			define main() {
+				while 1 {
+					return 2
+				}
+				3
+			}
+		*/
+		program := ast.NewProgram([]ast.TopLevel{
+			ast.NewFuncDef("main", nil, ast.NewBlock([]ast.Expression{
+				ast.NewWhile(ast.NewInteger(1), ast.NewBlock([]ast.Expression{
+					ast.NewReturn(ast.NewInteger(2)),
+				})),
+				ast.NewInteger(3),
+			})),
+		})
+
+		result, err := e.CallMain(program)
+		if err != nil {
+			t.Fatalf("failed to CallMain: %v", err)
+		}
+		if result != value.Int(2) {
+			t.Errorf("result = %v; want 2", result)
+		}
+	})
+}
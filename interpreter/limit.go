@@ -0,0 +1,78 @@
+package interpreter
+
+import (
+	"context"
+	"time"
+)
+
+// Limits bound what a program may do before it is stopped. A zero field is no
+// limit.
+type Limits struct {
+	// MaxSteps is the number of steps a program may take. A step is a call or
+	// an iteration of a loop.
+	MaxSteps int
+	// MaxCallDepth is the number of calls of toy functions, main included,
+	// which may be in progress at once.
+	MaxCallDepth int
+	// Deadline is when a program is stopped.
+	Deadline time.Time
+}
+
+// Meter counts the steps and calls of a run of a program against its Limits,
+// and stops it when the context of the run is done. A nil Meter has no limits.
+type Meter struct {
+	limits Limits
+	ctx    context.Context
+	steps  int
+	depth  int
+}
+
+// NewMeter returns a Meter for a run under ctx, which is cut short at
+// limits.Deadline. Its CancelFunc releases the resources of the deadline.
+func NewMeter(ctx context.Context, limits Limits) (*Meter, context.CancelFunc) {
+	cancel := func() {}
+	if !limits.Deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, limits.Deadline)
+	}
+	return &Meter{limits: limits, ctx: ctx}, cancel
+}
+
+// Step counts a step. It returns a LimitExceededError if the steps are more
+// than MaxSteps, or the error of the context if it is done.
+func (m *Meter) Step() error {
+	if m == nil {
+		return nil
+	}
+
+	m.steps++
+	if m.limits.MaxSteps > 0 && m.steps > m.limits.MaxSteps {
+		return &LimitExceededError{Limit: "step", Max: m.limits.MaxSteps}
+	}
+	select {
+	case <-m.ctx.Done():
+		return m.ctx.Err()
+	default:
+		return nil
+	}
+}
+
+// Enter counts a call of a toy function, which Leave counts as returned. It
+// returns a LimitExceededError if the calls in progress are more than
+// MaxCallDepth.
+func (m *Meter) Enter() error {
+	if m == nil {
+		return nil
+	}
+
+	if m.limits.MaxCallDepth > 0 && m.depth >= m.limits.MaxCallDepth {
+		return &LimitExceededError{Limit: "call depth", Max: m.limits.MaxCallDepth}
+	}
+	m.depth++
+	return nil
+}
+
+func (m *Meter) Leave() {
+	if m != nil {
+		m.depth--
+	}
+}
@@ -0,0 +1,320 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/value"
+)
+
+// BinaryOperation applies op to lhs and rhs, on the arithmetic a. vm.VM uses
+// it as well so that both engines share the semantics of every operator. And
+// and Or are applied to values already evaluated; the engines skip rhs
+// themselves.
+func (a Arithmetic) BinaryOperation(op ast.Operator, lhs, rhs value.Value) (value.Value, error) {
+	// NOTE: an int and a float are operated on as floats
+	if l, r, ok := promote(lhs, rhs); ok {
+		lhs, rhs = l, r
+	}
+
+	switch op {
+	case ast.And, ast.Or:
+		l, err := Truthy(lhs)
+		if err != nil {
+			return nil, err
+		}
+		if l == (op == ast.Or) {
+			return value.Bool(l), nil
+		}
+		r, err := Truthy(rhs)
+		if err != nil {
+			return nil, err
+		}
+		return value.Bool(r), nil
+	}
+
+	if l, ok := lhs.(value.Int); ok {
+		if r, ok := rhs.(value.Int); ok {
+			return a.operateInts(op, l, r)
+		}
+	}
+	if l, r, ok := bigOperands(lhs, rhs); ok {
+		return bigOperation(op, l, r)
+	}
+
+	switch op {
+	case ast.Equal:
+		return value.Bool(lhs == rhs), nil
+	case ast.NotEqual:
+		return value.Bool(lhs != rhs), nil
+	}
+
+	switch l := lhs.(type) {
+	case value.Float:
+		if r, ok := rhs.(value.Float); ok {
+			return floatOperation(op, l, r)
+		}
+
+	case value.String:
+		if op == ast.Add {
+			return l + value.String(rhs.String()), nil
+		}
+	}
+
+	if _, ok := rhs.(value.String); ok && op == ast.Add {
+		// concatenate a label and a number, in either order
+		return value.String(lhs.String()) + rhs.(value.String), nil
+	}
+
+	return nil, fmt.Errorf("unsupported operand types for %s: %s and %s", op.Name(), lhs.Type(), rhs.Type())
+}
+
+func intOperation(op ast.Operator, lhs, rhs value.Int) (value.Value, error) {
+	switch op {
+	case ast.Add:
+		return lhs + rhs, nil
+	case ast.Subtract:
+		return lhs - rhs, nil
+	case ast.Multiply:
+		return lhs * rhs, nil
+	case ast.Divide:
+		if rhs == 0 {
+			return nil, &DivisionByZeroError{}
+		}
+		return lhs / rhs, nil
+	case ast.Modulo:
+		if rhs == 0 {
+			return nil, &ModuloByZeroError{}
+		}
+		return lhs % rhs, nil
+	case ast.Power:
+		return power(lhs, rhs)
+	case ast.Equal:
+		return value.Bool(lhs == rhs), nil
+	case ast.NotEqual:
+		return value.Bool(lhs != rhs), nil
+	case ast.LessThan:
+		return value.Bool(lhs < rhs), nil
+	case ast.LessOrEqual:
+		return value.Bool(lhs <= rhs), nil
+	case ast.GreaterThan:
+		return value.Bool(lhs > rhs), nil
+	case ast.GreaterOrEqual:
+		return value.Bool(lhs >= rhs), nil
+	default:
+		return nil, fmt.Errorf("invalid operator: %v", op)
+	}
+}
+
+func floatOperation(op ast.Operator, lhs, rhs value.Float) (value.Value, error) {
+	switch op {
+	case ast.Add:
+		return lhs + rhs, nil
+	case ast.Subtract:
+		return lhs - rhs, nil
+	case ast.Multiply:
+		return lhs * rhs, nil
+	case ast.Divide:
+		if rhs == 0 {
+			return nil, &DivisionByZeroError{}
+		}
+		return lhs / rhs, nil
+	case ast.Modulo:
+		if rhs == 0 {
+			return nil, &ModuloByZeroError{}
+		}
+		return value.Float(math.Mod(float64(lhs), float64(rhs))), nil
+	case ast.Power:
+		return value.Float(math.Pow(float64(lhs), float64(rhs))), nil
+	case ast.LessThan:
+		return value.Bool(lhs < rhs), nil
+	case ast.LessOrEqual:
+		return value.Bool(lhs <= rhs), nil
+	case ast.GreaterThan:
+		return value.Bool(lhs > rhs), nil
+	case ast.GreaterOrEqual:
+		return value.Bool(lhs >= rhs), nil
+	default:
+		return nil, fmt.Errorf("invalid operator: %v", op)
+	}
+}
+
+// promote converts lhs and rhs to floats if one is a float and the other is an
+// int.
+func promote(lhs, rhs value.Value) (value.Value, value.Value, bool) {
+	l, lok := toFloat(lhs)
+	r, rok := toFloat(rhs)
+	_, lf := lhs.(value.Float)
+	_, rf := rhs.(value.Float)
+	if lok && rok && (lf || rf) {
+		return l, r, true
+	}
+	return lhs, rhs, false
+}
+
+// toFloat converts v to a float if it is a number.
+func toFloat(v value.Value) (value.Float, bool) {
+	switch v := v.(type) {
+	case value.Int:
+		return value.Float(v), true
+	case value.BigInt:
+		f, _ := new(big.Float).SetInt(v.N).Float64()
+		return value.Float(f), true
+	case value.Float:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// power raises base to exponent by repeated squaring.
+func power(base, exponent value.Int) (value.Value, error) {
+	if exponent < 0 {
+		return nil, fmt.Errorf("negative exponent: %d", exponent)
+	}
+
+	result := value.Int(1)
+	for ; exponent > 0; exponent >>= 1 {
+		if exponent&1 == 1 {
+			result *= base
+		}
+		base *= base
+	}
+	return result, nil
+}
+
+// UnaryOperation applies op to operand, on the arithmetic a.
+func (a Arithmetic) UnaryOperation(op ast.Operator, operand value.Value) (value.Value, error) {
+	switch op {
+	case ast.Not:
+		b, err := Truthy(operand)
+		if err != nil {
+			return nil, err
+		}
+		return !value.Bool(b), nil
+	case ast.Negate:
+		switch v := operand.(type) {
+		case value.Int:
+			if v == math.MinInt && a == CheckedArithmetic {
+				return nil, &OverflowError{Operator: op}
+			}
+			if v == math.MinInt && a == BigArithmetic {
+				return normalize(new(big.Int).Neg(big.NewInt(int64(v)))), nil
+			}
+			return -v, nil
+		case value.BigInt:
+			return normalize(new(big.Int).Neg(v.N)), nil
+		case value.Float:
+			return -v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported operand type for %s: %s", op.Name(), operand.Type())
+}
+
+// Index returns the element of the list collection at index, or the value of
+// the map collection for the key index.
+func Index(collection, index value.Value) (value.Value, error) {
+	if m, ok := collection.(*value.Map); ok {
+		if err := value.CheckKey(index); err != nil {
+			return nil, err
+		}
+		v, ok := m.Get(index)
+		if !ok {
+			return nil, &KeyNotFoundError{Key: index}
+		}
+		return v, nil
+	}
+
+	list, j, err := element(collection, index)
+	if err != nil {
+		return nil, err
+	}
+	return list.Elements[j], nil
+}
+
+// SetIndex replaces the element of the list collection at index with v, or
+// sets the value of the map collection for the key index to v.
+func SetIndex(collection, index, v value.Value) error {
+	if m, ok := collection.(*value.Map); ok {
+		if err := value.CheckKey(index); err != nil {
+			return err
+		}
+		m.Set(index, v)
+		return nil
+	}
+
+	list, j, err := element(collection, index)
+	if err != nil {
+		return err
+	}
+	list.Elements[j] = v
+	return nil
+}
+
+func element(collection, index value.Value) (*value.List, int, error) {
+	list, ok := collection.(*value.List)
+	if !ok {
+		return nil, 0, fmt.Errorf("%s is not indexable", collection.Type())
+	}
+	if _, ok := index.(value.BigInt); ok {
+		return nil, 0, fmt.Errorf("integer too large for list index")
+	}
+	j, ok := index.(value.Int)
+	if !ok {
+		return nil, 0, fmt.Errorf("list index must be int, not %s", index.Type())
+	}
+	if j < 0 || int(j) >= len(list.Elements) {
+		return nil, 0, &IndexOutOfRangeError{Index: int(j), Length: len(list.Elements)}
+	}
+	return list, int(j), nil
+}
+
+// Field returns the field named name of the struct object.
+func Field(object value.Value, name string) (value.Value, error) {
+	s, j, err := field(object, name)
+	if err != nil {
+		return nil, err
+	}
+	return s.Values[j], nil
+}
+
+// SetField replaces the field named name of the struct object with v.
+func SetField(object value.Value, name string, v value.Value) error {
+	s, j, err := field(object, name)
+	if err != nil {
+		return err
+	}
+	s.Values[j] = v
+	return nil
+}
+
+func field(object value.Value, name string) (*value.Struct, int, error) {
+	s, ok := object.(*value.Struct)
+	if !ok {
+		return nil, 0, &UnknownFieldError{Type: object.Type(), Field: name}
+	}
+	j, ok := s.Def.Field(name)
+	if !ok {
+		return nil, 0, &UnknownFieldError{Type: object.Type(), Field: name}
+	}
+	return s, j, nil
+}
+
+// Truthy reports whether v satisfies the condition of if and while, and is
+// true as an operand of &&, || and !.
+func Truthy(v value.Value) (bool, error) {
+	switch v := v.(type) {
+	case value.Bool:
+		return bool(v), nil
+	case value.Int:
+		// NOTE: ints are still accepted as conditions, false if and only if 0
+		return v != 0, nil
+	case value.BigInt:
+		return v.N.Sign() != 0, nil
+	default:
+		return false, fmt.Errorf("condition must be bool or int, not %s", v.Type())
+	}
+}
@@ -0,0 +1,303 @@
+package loader
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+)
+
+// linker rewrites the definitions of a module into definitions of the linked
+// program. The top-level names of the module are qualified unless an argument
+// hides them, and name.member where name is imported refers to the member of
+// the module unless an argument or local variable hides name.
+type linker struct {
+	module  *module
+	imports map[string]*module
+}
+
+// scope is the set of arguments and local variables which hide top-level names
+// and import names.
+type scope map[string]bool
+
+func (k *linker) topLevel(topLevel ast.TopLevel) (ast.TopLevel, error) {
+	switch def := topLevel.(type) {
+	case ast.FunctionDefinition:
+		def.Name = k.module.qualify(def.Name)
+		return k.function(def, scope{})
+
+	case ast.StructDefinition:
+		def.Name = k.module.qualify(def.Name)
+		return def, nil
+
+	case ast.GlobalVariableDefinition:
+		def.Name = k.module.qualify(def.Name)
+		typeName, err := k.qualified(def.Span, def.Type)
+		if err != nil {
+			return nil, err
+		}
+		def.Type = typeName
+		def.Expression, err = k.expression(def.Expression, scope{})
+		return def, err
+
+	default:
+		return nil, fmt.Errorf("unexpected topLevel: %v", def)
+	}
+}
+
+func (k *linker) function(def ast.FunctionDefinition, outer scope) (ast.FunctionDefinition, error) {
+	inner := scope{}
+	for name := range outer {
+		inner[name] = true
+	}
+
+	var err error
+	if def.ReturnType, err = k.qualified(def.Span, def.ReturnType); err != nil {
+		return def, err
+	}
+
+	defaults := make([]ast.Expression, len(def.Defaults))
+	argTypes := make([]string, len(def.ArgTypes))
+	for j, arg := range def.Args {
+		if defaultValue := def.Default(j); defaultValue != nil {
+			if defaults[j], err = k.expression(defaultValue, inner); err != nil {
+				return def, err
+			}
+		}
+		if typeName := def.ArgType(j); typeName != "" {
+			if argTypes[j], err = k.qualified(def.Span, typeName); err != nil {
+				return def, err
+			}
+		}
+		inner[arg] = true
+	}
+	if def.Defaults != nil {
+		def.Defaults = defaults
+	}
+	if def.ArgTypes != nil {
+		def.ArgTypes = argTypes
+	}
+
+	def.Body, err = k.block(def.Body, inner)
+	return def, err
+}
+
+// name returns what name refers to in the linked program.
+func (k *linker) name(name string, s scope) string {
+	if k.module.names[name] && !s[name] {
+		return k.module.qualify(name)
+	}
+	return name
+}
+
+// member returns what object.member refers to if object is an import name.
+func (k *linker) member(span ast.Span, object ast.Expression, member string, s scope) (string, bool, error) {
+	identifier, ok := object.(ast.Identifier)
+	if !ok || s[identifier.Name] {
+		return "", false, nil
+	}
+	dep, ok := k.imports[identifier.Name]
+	if !ok {
+		return "", false, nil
+	}
+
+	if !dep.names[member] {
+		return "", true, fmt.Errorf("%s: module %s has no member %s", span.Start, identifier.Name, member)
+	}
+	return dep.qualify(member), true, nil
+}
+
+// qualified returns what the name of a struct or a type, which may be
+// module.name, refers to in the linked program.
+func (k *linker) qualified(span ast.Span, name string) (string, error) {
+	j := strings.Index(name, ".")
+	if j < 0 {
+		if k.module.structs[name] {
+			return k.module.qualify(name), nil
+		}
+		return name, nil
+	}
+
+	dep, ok := k.imports[name[:j]]
+	if !ok {
+		return "", fmt.Errorf("%s: %s is not imported", span.Start, name[:j])
+	}
+	if !dep.names[name[j+1:]] {
+		return "", fmt.Errorf("%s: module %s has no member %s", span.Start, name[:j], name[j+1:])
+	}
+	return dep.qualify(name[j+1:]), nil
+}
+
+func (k *linker) block(block ast.BlockExpression, s scope) (ast.BlockExpression, error) {
+	if block.Expressions == nil {
+		return block, nil
+	}
+
+	expressions, err := k.expressions(block.Expressions, s)
+	block.Expressions = expressions
+	return block, err
+}
+
+func (k *linker) expressions(exps []ast.Expression, s scope) ([]ast.Expression, error) {
+	result := make([]ast.Expression, len(exps))
+	for j, exp := range exps {
+		var err error
+		if result[j], err = k.expression(exp, s); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (k *linker) expression(intf ast.Expression, s scope) (ast.Expression, error) {
+	var err error
+	switch exp := intf.(type) {
+	case ast.IntegerLiteral, ast.FloatLiteral, ast.BooleanLiteral, ast.StringLiteral, ast.BreakExpression, ast.ContinueExpression:
+		return exp, nil
+
+	case ast.BinaryExpression:
+		if exp.Lhs, err = k.expression(exp.Lhs, s); err != nil {
+			return nil, err
+		}
+		exp.Rhs, err = k.expression(exp.Rhs, s)
+		return exp, err
+
+	case ast.UnaryExpression:
+		exp.Operand, err = k.expression(exp.Operand, s)
+		return exp, err
+
+	case ast.Identifier:
+		exp.Name = k.name(exp.Name, s)
+		return exp, nil
+
+	case ast.Assignment:
+		exp.Expression, err = k.expression(exp.Expression, s)
+		// NOTE: a local variable hides the import of its name from then on
+		if !k.module.names[exp.Name] {
+			s[exp.Name] = true
+		}
+		exp.Name = k.name(exp.Name, s)
+		return exp, err
+
+	case ast.IfExpression:
+		if exp.Condition, err = k.expression(exp.Condition, s); err != nil {
+			return nil, err
+		}
+		if exp.ThenClause, err = k.block(exp.ThenClause, s); err != nil {
+			return nil, err
+		}
+		exp.ElseClause, err = k.block(exp.ElseClause, s)
+		return exp, err
+
+	case ast.WhileExpression:
+		if exp.Condition, err = k.expression(exp.Condition, s); err != nil {
+			return nil, err
+		}
+		exp.Body, err = k.block(exp.Body, s)
+		return exp, err
+
+	case ast.BlockExpression:
+		return k.block(exp, s)
+
+	case ast.Println:
+		exp.Arg, err = k.expression(exp.Arg, s)
+		return exp, err
+
+	case ast.ReturnExpression:
+		if exp.Value != nil {
+			exp.Value, err = k.expression(exp.Value, s)
+		}
+		return exp, err
+
+	case ast.FunctionLiteral:
+		def, err := k.function(exp.Definition(), s)
+		if err != nil {
+			return nil, err
+		}
+		exp.Defaults, exp.ArgTypes, exp.ReturnType, exp.Body = def.Defaults, def.ArgTypes, def.ReturnType, def.Body
+		return exp, nil
+
+	case ast.ListLiteral:
+		exp.Elements, err = k.expressions(exp.Elements, s)
+		return exp, err
+
+	case ast.MapLiteral:
+		if exp.Keys, err = k.expressions(exp.Keys, s); err != nil {
+			return nil, err
+		}
+		exp.Values, err = k.expressions(exp.Values, s)
+		return exp, err
+
+	case ast.StructLiteral:
+		if exp.Name, err = k.qualified(exp.Span, exp.Name); err != nil {
+			return nil, err
+		}
+		exp.Values, err = k.expressions(exp.Values, s)
+		return exp, err
+
+	case ast.FieldAccess:
+		if name, ok, err := k.member(exp.Span, exp.Object, exp.Field, s); ok {
+			identifier := ast.NewIdentifier(name)
+			identifier.Span = exp.Span
+			return identifier, err
+		}
+		exp.Object, err = k.expression(exp.Object, s)
+		return exp, err
+
+	case ast.FieldAssignment:
+		if name, ok, err := k.member(exp.Span, exp.Object, exp.Field, s); ok {
+			if err != nil {
+				return nil, err
+			}
+			v, err := k.expression(exp.Value, s)
+			assignment := ast.NewAssignment(name, v)
+			assignment.Span = exp.Span
+			return assignment, err
+		}
+		if exp.Object, err = k.expression(exp.Object, s); err != nil {
+			return nil, err
+		}
+		exp.Value, err = k.expression(exp.Value, s)
+		return exp, err
+
+	case ast.IndexExpression:
+		if exp.Collection, err = k.expression(exp.Collection, s); err != nil {
+			return nil, err
+		}
+		exp.Index, err = k.expression(exp.Index, s)
+		return exp, err
+
+	case ast.IndexAssignment:
+		if exp.Collection, err = k.expression(exp.Collection, s); err != nil {
+			return nil, err
+		}
+		if exp.Index, err = k.expression(exp.Index, s); err != nil {
+			return nil, err
+		}
+		exp.Value, err = k.expression(exp.Value, s)
+		return exp, err
+
+	case ast.FunctionCall:
+		if exp.Callee == nil {
+			exp.Name = k.name(exp.Name, s)
+		} else if field, ok := exp.Callee.(ast.FieldAccess); ok {
+			// NOTE: lib.f(x) calls the function f of lib by name, like f(x) does
+			if name, ok, err := k.member(field.Span, field.Object, field.Field, s); ok {
+				if err != nil {
+					return nil, err
+				}
+				exp.Name, exp.Callee = name, nil
+			}
+		}
+		if exp.Callee != nil {
+			if exp.Callee, err = k.expression(exp.Callee, s); err != nil {
+				return nil, err
+			}
+		}
+		exp.Args, err = k.expressions(exp.Args, s)
+		return exp, err
+
+	default:
+		return nil, fmt.Errorf("unexpected expression: %v", exp)
+	}
+}
@@ -0,0 +1,217 @@
+// Package loader reads a toy program and the modules it imports, and links
+// them into a single ast.Program which interpreter.Interpreter and vm.VM run
+// as they run a program of one file.
+//
+// The top-level names of an imported module are qualified with the name of
+// the module, so the function f of lib.toy is lib.f in the linked program,
+// and lib.f of the importing file refers to it. Each module is linked once
+// however many files import it, before the first of them, so its globals are
+// initialized once and before anything which uses them.
+package loader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/parser"
+)
+
+type Loader struct {
+	// SearchPath is the directories searched in order for an import which is
+	// not found relative to the importing file.
+	SearchPath []string
+
+	sources     map[string]string
+	modules     map[string]*module
+	loading     []*module
+	prefixes    map[string]bool
+	definitions []ast.TopLevel
+}
+
+func NewLoader(searchPath []string) *Loader {
+	return &Loader{
+		SearchPath: searchPath,
+		sources:    map[string]string{},
+	}
+}
+
+// module is a file loaded by a Loader.
+type module struct {
+	path string
+	abs  string
+	// prefix qualifies the top-level names of the module. It is "" for the
+	// file Load is called with, whose names are left as they are.
+	prefix string
+	// names are the top-level names of the module, and structs those of them
+	// which are structs.
+	names   map[string]bool
+	structs map[string]bool
+}
+
+// qualify returns the name the top-level name of m has in the linked program.
+func (m *module) qualify(name string) string {
+	if m.prefix == "" {
+		return name
+	}
+	return m.prefix + "." + name
+}
+
+// Load reads the file at path and every module it imports, directly or not,
+// and returns the linked program.
+func (l *Loader) Load(path string) (ast.Program, error) {
+	l.modules = map[string]*module{}
+	l.prefixes = map[string]bool{}
+	l.definitions = nil
+
+	if _, err := l.load(path, ""); err != nil {
+		return ast.Program{}, err
+	}
+	return ast.NewProgram(l.definitions), nil
+}
+
+// Source returns the source of file, which is the File of a position in a
+// program loaded by l, or "" if l has not loaded it.
+func (l *Loader) Source(file string) string {
+	return l.sources[file]
+}
+
+func (l *Loader) load(path, prefix string) (*module, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	toy := &parser.Toy{Buffer: string(input), Filename: path}
+	l.sources[path] = toy.Buffer
+	if err := toy.Init(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := toy.Parse(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := toy.ConvertAst(); err != nil {
+		return nil, err
+	}
+
+	m := &module{path: path, abs: abs, prefix: prefix, names: map[string]bool{}, structs: map[string]bool{}}
+	l.loading = append(l.loading, m)
+	defer func() { l.loading = l.loading[:len(l.loading)-1] }()
+
+	imports := map[string]*module{}
+	for _, topLevel := range toy.Program.Definitions {
+		importDecl, ok := topLevel.(ast.ImportDeclaration)
+		if !ok {
+			continue
+		}
+		if _, ok := imports[importDecl.Name]; ok {
+			return nil, fmt.Errorf("%s: %s is imported twice", importDecl.Span.Start, importDecl.Name)
+		}
+
+		dep, err := l.loadImport(m, importDecl)
+		if err != nil {
+			return nil, err
+		}
+		imports[importDecl.Name] = dep
+	}
+
+	for _, topLevel := range toy.Program.Definitions {
+		var name string
+		switch def := topLevel.(type) {
+		case ast.FunctionDefinition:
+			name = def.Name
+		case ast.StructDefinition:
+			name = def.Name
+			m.structs[name] = true
+		case ast.GlobalVariableDefinition:
+			name = def.Name
+		default:
+			continue
+		}
+		if _, ok := imports[name]; ok {
+			return nil, fmt.Errorf("%s: %s is both imported and defined", topLevel.Pos(), name)
+		}
+		m.names[name] = true
+	}
+
+	k := &linker{module: m, imports: imports}
+	for _, topLevel := range toy.Program.Definitions {
+		if _, ok := topLevel.(ast.ImportDeclaration); ok {
+			continue
+		}
+		def, err := k.topLevel(topLevel)
+		if err != nil {
+			return nil, err
+		}
+		l.definitions = append(l.definitions, def)
+	}
+
+	l.modules[abs] = m
+	return m, nil
+}
+
+// loadImport loads the module importDecl of the module m imports.
+func (l *Loader) loadImport(m *module, importDecl ast.ImportDeclaration) (*module, error) {
+	path, ok := l.resolve(m.path, importDecl.Path)
+	if !ok {
+		return nil, fmt.Errorf("%s: module %q is not found", importDecl.Span.Start, importDecl.Path)
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for j, loading := range l.loading {
+		if loading.abs == abs {
+			var cycle []string
+			for _, n := range l.loading[j:] {
+				cycle = append(cycle, n.path)
+			}
+			cycle = append(cycle, path)
+			return nil, fmt.Errorf("%s: import cycle: %s", importDecl.Span.Start, strings.Join(cycle, " imports "))
+		}
+	}
+
+	if dep, ok := l.modules[abs]; ok {
+		return dep, nil
+	}
+	return l.load(path, l.prefix(path))
+}
+
+// resolve returns the path of the file which the file at from imports as path.
+func (l *Loader) resolve(from, path string) (string, bool) {
+	if filepath.IsAbs(path) {
+		return path, isFile(path)
+	}
+
+	for _, dir := range append([]string{filepath.Dir(from)}, l.SearchPath...) {
+		if p := filepath.Join(dir, path); isFile(p) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// prefix returns a prefix for the module at path which no other module has.
+func (l *Loader) prefix(path string) string {
+	base := strings.TrimSuffix(filepath.Base(path), ".toy")
+
+	prefix := base
+	for n := 2; l.prefixes[prefix]; n++ {
+		prefix = base + strconv.Itoa(n)
+	}
+	l.prefixes[prefix] = true
+	return prefix
+}
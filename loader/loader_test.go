@@ -0,0 +1,288 @@
+package loader_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/interpreter"
+	"github.com/TOMOFUMI-KONDO/toy/loader"
+	"github.com/TOMOFUMI-KONDO/toy/value"
+	"github.com/TOMOFUMI-KONDO/toy/vm"
+)
+
+// writeFiles writes files, which map paths relative to a new directory to
+// their sources, and returns the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, source := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+type engine interface {
+	CallMain(program ast.Program) (value.Value, error)
+}
+
+// run runs program on both engines and checks that they agree.
+func run(t *testing.T, program ast.Program) (string, string) {
+	t.Helper()
+
+	var results, printed []string
+	for _, newEngine := range []func(*bytes.Buffer) engine{
+		func(buf *bytes.Buffer) engine {
+			i := interpreter.NewInterpreterWithWriter(buf)
+			return &i
+		},
+		func(buf *bytes.Buffer) engine {
+			m := vm.NewVMWithWriter(buf)
+			return &m
+		},
+	} {
+		var buf bytes.Buffer
+		result, err := newEngine(&buf).CallMain(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, result.String())
+		printed = append(printed, buf.String())
+	}
+
+	if results[0] != results[1] || printed[0] != printed[1] {
+		t.Errorf("interpreter and vm disagree: results %q, printed %q", results, printed)
+	}
+	return results[0], printed[0]
+}
+
+func TestLoad(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.toy": `import "geometry/point.toy"
+import "text.toy" as txt
+define main() {
+	p=point.Point{x: 3, y: 4}
+	point.moved=point.moved+10
+	txt.join([point.describe(p), point.moved, point.origin.x],",")
+}`,
+		"geometry/point.toy": `import "../text.toy"
+struct Point { x, y }
+global origin: Point = Point{}
+global moved=0
+define describe(p: Point): string {
+	moved=moved+1
+	text.join([p.x, p.y],":")
+}`,
+		"text.toy": `define join(xs,sep) {
+	s=""
+	i=0
+	while i<len(xs) {
+		if i>0 {
+			s=s+sep
+		}
+		s=s+xs[i]
+		i=i+1
+	}
+	s
+}`,
+	})
+
+	program, err := loader.NewLoader(nil).Load(filepath.Join(dir, "main.toy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := interpreter.Check(program); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	if result, _ := run(t, program); result != "3:4,11,0" {
+		t.Errorf("result = %s; want 3:4,11,0", result)
+	}
+}
+
+func TestLoadOnce(t *testing.T) {
+	// NOTE: log.toy is not next to any file, so each import of it is found in
+	// the search path
+	dir := writeFiles(t, map[string]string{
+		"main.toy": `import "a.toy"
+import "b.toy"
+import "log.toy"
+define main() {
+	a.count+b.count+log.calls
+}`,
+		"a.toy": `import "log.toy"
+global count=log.write("a")`,
+		"b.toy": `import "log.toy"
+global count=log.write("b")`,
+		"lib/log.toy": `global calls=0
+define write(s) {
+	println(s)
+	calls=calls+1
+}`,
+	})
+	l := loader.NewLoader([]string{filepath.Join(dir, "lib")})
+	program, err := l.Load(filepath.Join(dir, "main.toy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, printed := run(t, program)
+	if result != "5" || printed != "ab" {
+		t.Errorf("result = %s, printed = %q; want 5, \"ab\"", result, printed)
+	}
+	if l.Source(filepath.Join(dir, "lib", "log.toy")) == "" {
+		t.Errorf("source of log.toy is not kept")
+	}
+}
+
+func TestLoadNames(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.toy": `import "a/util.toy"
+import "b/util.toy" as other
+define len(x) {
+	-1
+}
+define main() {
+	[util.twice(2), other.twice(2), util.shadow(5), len([1])]
+}`,
+		"a/util.toy": `global n=2
+define twice(x) {
+	x*n
+}
+define shadow(n) {
+	f=define(twice) { twice+n }
+	f(len([1, 2]))
+}
+define len(xs) {
+	100
+}`,
+		"b/util.toy": `define twice(x) {
+	x+x+1
+}`,
+	})
+
+	program, err := loader.NewLoader(nil).Load(filepath.Join(dir, "main.toy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result, _ := run(t, program); result != "[4, 5, 105, -1]" {
+		t.Errorf("result = %s; want [4, 5, 105, -1]", result)
+	}
+}
+
+func TestLoadLocalHidesImport(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.toy": `import "far.toy"
+struct Q { x }
+define main() {
+	y=far.x
+	far=Q{x: 9}
+	[y, far.x]
+}`,
+		"far.toy": `global x=1`,
+	})
+
+	program, err := loader.NewLoader(nil).Load(filepath.Join(dir, "main.toy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result, _ := run(t, program); result != "[1, 9]" {
+		t.Errorf("result = %s; want [1, 9]", result)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		files    map[string]string
+		expected string
+	}{
+		{
+			map[string]string{"main.toy": `import "missing.toy"`},
+			`main.toy:1:1: module "missing.toy" is not found`,
+		},
+		{
+			map[string]string{
+				"main.toy": `import "a.toy"`,
+				"a.toy":    "import \"b.toy\"\ndefine f() { 1 }",
+				"b.toy":    `import "a.toy"`,
+			},
+			"b.toy:1:1: import cycle: DIR/a.toy imports DIR/b.toy imports DIR/a.toy",
+		},
+		{
+			map[string]string{"main.toy": `import "main.toy"`},
+			"main.toy:1:1: import cycle: DIR/main.toy imports DIR/main.toy",
+		},
+		{
+			map[string]string{
+				"main.toy": "import \"a.toy\"\ndefine main() {\n\ta.g()\n}",
+				"a.toy":    `define f() { 1 }`,
+			},
+			"main.toy:3:2: module a has no member g",
+		},
+		{
+			map[string]string{
+				"main.toy": "import \"a.toy\"\ndefine main() {\n\tb.Point{}\n}",
+				"a.toy":    `struct Point { x }`,
+			},
+			"main.toy:3:2: b is not imported",
+		},
+		{
+			map[string]string{
+				"main.toy": "import \"a.toy\"\nimport \"x/a.toy\"",
+				"a.toy":    ``,
+				"x/a.toy":  ``,
+			},
+			"main.toy:2:1: a is imported twice",
+		},
+		{
+			map[string]string{
+				"main.toy": "import \"a.toy\"\nglobal a=1",
+				"a.toy":    ``,
+			},
+			"main.toy:2:1: a is both imported and defined",
+		},
+		{
+			map[string]string{"main.toy": `import "my-lib.toy"`},
+			`main.toy:1:1: cannot name module "my-lib.toy"; import it with as`,
+		},
+	}
+
+	for _, test := range tests {
+		dir := writeFiles(t, test.files)
+		_, err := loader.NewLoader(nil).Load(filepath.Join(dir, "main.toy"))
+		if err == nil {
+			t.Errorf("Load succeeded; want %q", test.expected)
+			continue
+		}
+
+		got := strings.ReplaceAll(err.Error(), dir, "DIR")
+		if want := "DIR/" + test.expected; got != want {
+			t.Errorf("err = %q; want %q", got, want)
+		}
+	}
+}
+
+func TestUnresolvedImport(t *testing.T) {
+	program := ast.NewProgram([]ast.TopLevel{ast.NewImport("lib.toy", "lib")})
+
+	i := interpreter.NewInterpreter()
+	m := vm.NewVM()
+	for _, e := range []engine{&i, &m} {
+		_, err := e.CallMain(program)
+		if want := `import "lib.toy" is not resolved; load the program with package loader`; err == nil || !strings.HasSuffix(err.Error(), want) {
+			t.Errorf("err = %v; want %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+)
+
+// collectComments records the comments under node and its siblings in source
+// order, before any node is converted, so that spans leave out the comments
+// which rules consume after them.
+func (p *Toy) collectComments(node *node32) {
+	if p.commentStarts == nil {
+		p.commentStarts = map[int]int{}
+	}
+
+	for ; node != nil; node = node.next {
+		if node.pegRule != rulecomment {
+			p.collectComments(node.up)
+			continue
+		}
+
+		comment := ast.Comment{
+			Span: p.span(node),
+			Text: strings.TrimRight(p.tokenStr(node), " \t"),
+		}
+		p.comments = append(p.comments, comment)
+		p.commentStarts[int(node.end)] = int(node.begin)
+	}
+}
+
+// commentScope is a block, or the program if span is zero, with the nodes in
+// it which comments are attached to.
+type commentScope struct {
+	span  ast.Span
+	nodes []ast.Node
+}
+
+// attachComments sets the Comments of p.Program. A comment belongs to the
+// innermost block it is in, or to the program. There it is attached to the
+// node it is inside of, else as trailing to the node which ends on the line
+// it starts on, else as leading to the node after it, else as trailing to the
+// node before it, or else to the empty block or program itself.
+func (p *Toy) attachComments() {
+	if len(p.comments) == 0 {
+		return
+	}
+
+	program := commentScope{}
+	var blocks []commentScope
+	for _, def := range p.Program.Definitions {
+		program.nodes = append(program.nodes, def)
+		switch def := def.(type) {
+		case ast.FunctionDefinition:
+			blocks = appendBlocks(blocks, def.Defaults...)
+			blocks = appendBlocks(blocks, def.Body)
+		case ast.GlobalVariableDefinition:
+			blocks = appendBlocks(blocks, def.Expression)
+		}
+	}
+
+	p.Program.Comments = ast.CommentMap{}
+	for _, comment := range p.comments {
+		scope := program
+		for _, block := range blocks {
+			// NOTE: the blocks a comment is in are nested, so the innermost one
+			// starts last
+			if contains(block.span, comment.Span) && (scope.span == ast.Span{} || block.span.Start.Offset > scope.span.Start.Offset) {
+				scope = block
+			}
+		}
+		attachComment(p.Program.Comments, scope, comment)
+	}
+}
+
+func attachComment(comments ast.CommentMap, scope commentScope, comment ast.Comment) {
+	var before, after ast.Node
+	for _, node := range scope.nodes {
+		switch span := node.Range(); {
+		case contains(span, comment.Span):
+			comments.Group(span).Trailing = append(comments.Group(span).Trailing, comment)
+			return
+		case span.End.Offset <= comment.Start.Offset:
+			before = node
+		case after == nil:
+			after = node
+		}
+	}
+
+	switch {
+	case before != nil && before.Range().End.Line == comment.Start.Line:
+		g := comments.Group(before.Range())
+		g.Trailing = append(g.Trailing, comment)
+	case after != nil:
+		g := comments.Group(after.Range())
+		g.Leading = append(g.Leading, comment)
+	case before != nil:
+		g := comments.Group(before.Range())
+		g.Trailing = append(g.Trailing, comment)
+	default:
+		g := comments.Group(scope.span)
+		g.Inner = append(g.Inner, comment)
+	}
+}
+
+func contains(outer, inner ast.Span) bool {
+	return outer.Start.Offset <= inner.Start.Offset && inner.End.Offset <= outer.End.Offset
+}
+
+// appendBlocks appends the blocks in exps, and those of exps which are
+// blocks, to blocks.
+func appendBlocks(blocks []commentScope, exps ...ast.Expression) []commentScope {
+	for _, exp := range exps {
+		switch exp := exp.(type) {
+		case ast.BlockExpression:
+			// NOTE: a missing else clause is an empty block without a span
+			if exp.Span != (ast.Span{}) {
+				block := commentScope{span: exp.Span}
+				for _, e := range exp.Expressions {
+					block.nodes = append(block.nodes, e)
+				}
+				blocks = append(blocks, block)
+			}
+			blocks = appendBlocks(blocks, exp.Expressions...)
+		case ast.IfExpression:
+			blocks = appendBlocks(blocks, exp.Condition, exp.ThenClause, exp.ElseClause)
+		case ast.WhileExpression:
+			blocks = appendBlocks(blocks, exp.Condition, exp.Body)
+		case ast.FunctionLiteral:
+			blocks = appendBlocks(blocks, exp.Defaults...)
+			blocks = appendBlocks(blocks, exp.Body)
+		case ast.UnaryExpression:
+			blocks = appendBlocks(blocks, exp.Operand)
+		case ast.BinaryExpression:
+			blocks = appendBlocks(blocks, exp.Lhs, exp.Rhs)
+		case ast.Assignment:
+			blocks = appendBlocks(blocks, exp.Expression)
+		case ast.ReturnExpression:
+			blocks = appendBlocks(blocks, exp.Value)
+		case ast.Println:
+			blocks = appendBlocks(blocks, exp.Arg)
+		case ast.FunctionCall:
+			blocks = appendBlocks(blocks, exp.Callee)
+			blocks = appendBlocks(blocks, exp.Args...)
+		case ast.ListLiteral:
+			blocks = appendBlocks(blocks, exp.Elements...)
+		case ast.IndexExpression:
+			blocks = appendBlocks(blocks, exp.Collection, exp.Index)
+		case ast.IndexAssignment:
+			blocks = appendBlocks(blocks, exp.Collection, exp.Index, exp.Value)
+		case ast.MapLiteral:
+			blocks = appendBlocks(blocks, exp.Keys...)
+			blocks = appendBlocks(blocks, exp.Values...)
+		case ast.StructLiteral:
+			blocks = appendBlocks(blocks, exp.Values...)
+		case ast.FieldAccess:
+			blocks = appendBlocks(blocks, exp.Object)
+		case ast.FieldAssignment:
+			blocks = appendBlocks(blocks, exp.Object, exp.Value)
+		}
+	}
+	return blocks
+}
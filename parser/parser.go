@@ -1,10 +1,16 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/TOMOFUMI-KONDO/toy/ast"
 )
@@ -32,7 +38,27 @@ func init() {
 }
 
 func (p *Toy) ConvertAst() error {
-	return p.program(p.AST())
+	root := p.AST()
+	p.collectComments(root)
+	if err := p.program(root); err != nil {
+		return err
+	}
+	p.attachComments()
+	return nil
+}
+
+// ParseReplInput parses Buffer as a sequence of top-level definitions and
+// bare expressions, as typed into the REPL.
+func (p *Toy) ParseReplInput() error {
+	return p.Parse(int(rulereplInput))
+}
+
+// ConvertReplInput returns the ast.TopLevel and ast.Expression nodes of
+// input parsed by ParseReplInput, in source order.
+func (p *Toy) ConvertReplInput() ([]ast.Node, error) {
+	root := p.AST()
+	p.collectComments(root)
+	return p.replInput(root)
 }
 
 func (p *Toy) program(node *node32) error {
@@ -55,12 +81,48 @@ func (p *Toy) program(node *node32) error {
 	return nil
 }
 
+func (p *Toy) replInput(node *node32) ([]ast.Node, error) {
+	infoLog.info("replInput\n%s\n", p.tokenStr(node))
+
+	var nodes []ast.Node
+
+	node = node.up
+	for node != nil {
+		switch node.pegRule {
+		case ruletopLevel:
+			topLevel, err := p.topLevel(node)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, topLevel)
+
+		case ruleexpression:
+			exp, err := p.expression(node)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, exp)
+		}
+
+		node = node.next
+	}
+
+	return nodes, nil
+}
+
 func (p *Toy) topLevel(node *node32) (ast.TopLevel, error) {
 	infoLog.info("topLevel\n%s\n", p.tokenStr(node))
 
 	node = node.up
 	for node != nil {
 		switch node.pegRule {
+		case ruleimportDeclaration:
+			importDecl, err := p.importDeclaration(node)
+			if err != nil {
+				return nil, err
+			}
+			return *importDecl, nil
+
 		case rulefunctionDefinition:
 			funcDef, err := p.functionDefinition(node)
 			if err != nil {
@@ -68,6 +130,13 @@ func (p *Toy) topLevel(node *node32) (ast.TopLevel, error) {
 			}
 			return *funcDef, nil
 
+		case rulestructDefinition:
+			structDef, err := p.structDefinition(node)
+			if err != nil {
+				return nil, err
+			}
+			return *structDef, nil
+
 		case ruleglobalVariableDefinition:
 			globalVarDef, err := p.globalVariableDefinition(node)
 			if err != nil {
@@ -82,51 +151,188 @@ func (p *Toy) topLevel(node *node32) (ast.TopLevel, error) {
 	return nil, fmt.Errorf("not reach here")
 }
 
+func (p *Toy) importDeclaration(node *node32) (*ast.ImportDeclaration, error) {
+	infoLog.info("importDeclaration\n%s\n", p.tokenStr(node))
+
+	span := p.span(node)
+
+	path, err := p.stringLiteral(p.find(node.up, rulestringLiteral))
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path.Value), ".toy")
+	if alias := p.find(node.up, ruleidentifier); alias != nil {
+		name = p.tokenStr(alias)
+	} else if !isIdentifier(name) {
+		return nil, fmt.Errorf("%s: cannot name module %q; import it with as", span.Start, path.Value)
+	}
+
+	importDecl := ast.NewImport(path.Value, name)
+	importDecl.Span = span
+	return &importDecl, nil
+}
+
+// isIdentifier reports whether s is a valid identifier.
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
 func (p *Toy) functionDefinition(node *node32) (*ast.FunctionDefinition, error) {
 	infoLog.info("functionDefinition\n%s\n", p.tokenStr(node))
 
-	var name string
-	var args []string
-	var body *ast.BlockExpression
+	funcDef := ast.FunctionDefinition{Span: p.span(node)}
 
 	node = node.up
 	for node != nil {
 		switch node.pegRule {
 		case ruleidentifier:
-			arg := p.tokenStr(node)
-			if name == "" {
-				name = arg
-			} else {
-				args = append(args, arg)
+			funcDef.Name = p.tokenStr(node)
+
+		case ruleparameters:
+			if err := p.parameters(node, &funcDef); err != nil {
+				return nil, err
 			}
 
+		case rulereturnType:
+			funcDef.ReturnType = p.typeName(node)
+
 		case ruleblockExpression:
-			var err error
-			body, err = p.block(node)
+			body, err := p.block(node)
 			if err != nil {
 				return nil, err
 			}
+			funcDef.Body = *body
 		}
 
 		node = node.next
 	}
 
-	funcDef := ast.NewFuncDef(name, args, *body)
 	return &funcDef, nil
 }
 
+// parameters sets the arguments of funcDef. Arguments with a default value
+// must follow the others, and a variadic argument must be the last one.
+func (p *Toy) parameters(node *node32, funcDef *ast.FunctionDefinition) error {
+	infoLog.info("parameters\n%s\n", p.tokenStr(node))
+
+	node = node.up
+	for node != nil {
+		if node.pegRule == ruleparameter {
+			param := node.up
+			if funcDef.Variadic {
+				return fmt.Errorf("%s: variadic argument %s must be the last one", p.span(param).Start, funcDef.Args[len(funcDef.Args)-1])
+			}
+
+			var name, typeName string
+			var defaultValue ast.Expression
+			switch param.pegRule {
+			case ruleidentifier:
+				name = p.tokenStr(param)
+				if funcDef.Defaults != nil {
+					return fmt.Errorf("%s: argument %s without default value follows one with default value", p.span(param).Start, name)
+				}
+				if param.next != nil {
+					typeName = p.typeName(param.next)
+				}
+
+			case rulevariadicParameter:
+				name = p.tokenStr(param.up)
+				funcDef.Variadic = true
+
+			case ruledefaultParameter:
+				name = p.tokenStr(param.up)
+				if param.up.next.pegRule == ruletypeAnnotation {
+					typeName = p.typeName(param.up.next)
+				}
+				var err error
+				defaultValue, err = p.disjunctive(p.find(param.up, ruledisjunctive))
+				if err != nil {
+					return err
+				}
+				if funcDef.Defaults == nil {
+					funcDef.Defaults = make([]ast.Expression, len(funcDef.Args))
+				}
+			}
+
+			if typeName != "" && funcDef.ArgTypes == nil {
+				funcDef.ArgTypes = make([]string, len(funcDef.Args))
+			}
+			funcDef.Args = append(funcDef.Args, name)
+			if funcDef.Defaults != nil {
+				funcDef.Defaults = append(funcDef.Defaults, defaultValue)
+			}
+			if funcDef.ArgTypes != nil {
+				funcDef.ArgTypes = append(funcDef.ArgTypes, typeName)
+			}
+		}
+
+		node = node.next
+	}
+
+	return nil
+}
+
+// typeName returns the name of the type of a typeAnnotation or returnType node.
+func (p *Toy) typeName(node *node32) string {
+	return p.tokenStr(p.find(node.up, ruletypeName))
+}
+
+func (p *Toy) structDefinition(node *node32) (*ast.StructDefinition, error) {
+	infoLog.info("structDefinition\n%s\n", p.tokenStr(node))
+
+	span := p.span(node)
+
+	var name string
+	var fields []string
+	seen := map[string]bool{}
+	for node = node.up; node != nil; node = node.next {
+		if node.pegRule != ruleidentifier {
+			continue
+		}
+
+		if name == "" {
+			name = p.tokenStr(node)
+			continue
+		}
+		field := p.tokenStr(node)
+		if seen[field] {
+			return nil, fmt.Errorf("%s: field %s is defined twice", p.span(node).Start, field)
+		}
+		seen[field] = true
+		fields = append(fields, field)
+	}
+
+	structDef := ast.NewStructDef(name, fields)
+	structDef.Span = span
+	return &structDef, nil
+}
+
 func (p *Toy) globalVariableDefinition(node *node32) (*ast.GlobalVariableDefinition, error) {
 	infoLog.info("topLevel\n%s\n", p.tokenStr(node))
 
-	var name string
+	var name, typeName string
 	var exp ast.Expression
 
+	span := p.span(node)
+
 	node = node.up
 	for node != nil {
 		switch node.pegRule {
 		case ruleidentifier:
 			name = p.tokenStr(node)
 
+		case ruletypeAnnotation:
+			typeName = p.typeName(node)
+
 		case ruleexpression:
 			var err error
 			exp, err = p.expression(node)
@@ -139,6 +345,8 @@ func (p *Toy) globalVariableDefinition(node *node32) (*ast.GlobalVariableDefinit
 	}
 
 	globalVarDef := ast.NewGlobalVarDef(name, exp)
+	globalVarDef.Type = typeName
+	globalVarDef.Span = span
 	return &globalVarDef, nil
 }
 
@@ -150,22 +358,54 @@ func (p *Toy) expression(node *node32) (ast.Expression, error) {
 		switch node.pegRule {
 		case ruleifExpression:
 			exp, err := p.ifExp(node)
-			return *exp, err
+			if err != nil {
+				return nil, err
+			}
+			return *exp, nil
 
 		case rulewhileExpression:
 			exp, err := p.while(node)
-			return *exp, err
+			if err != nil {
+				return nil, err
+			}
+			return *exp, nil
 
 		case ruleblockExpression:
 			exp, err := p.block(node)
-			return *exp, err
+			if err != nil {
+				return nil, err
+			}
+			return *exp, nil
+
+		case rulereturnExpression:
+			exp, err := p.returnExp(node)
+			if err != nil {
+				return nil, err
+			}
+			return *exp, nil
+
+		case rulebreakExpression:
+			exp := ast.NewBreak()
+			exp.Span = p.span(node)
+			return exp, nil
+
+		case rulecontinueExpression:
+			exp := ast.NewContinue()
+			exp.Span = p.span(node)
+			return exp, nil
 
 		case ruleassignment:
 			exp, err := p.assignment(node)
-			return *exp, err
+			if err != nil {
+				return nil, err
+			}
+			return *exp, nil
 
-		case rulecomparative:
-			return p.comparative(node)
+		case rulepostfixAssignment:
+			return p.postfixAssignment(node)
+
+		case ruledisjunctive:
+			return p.disjunctive(node)
 		}
 
 		node = node.next
@@ -174,6 +414,23 @@ func (p *Toy) expression(node *node32) (ast.Expression, error) {
 	return nil, fmt.Errorf("not reach here")
 }
 
+func (p *Toy) returnExp(node *node32) (*ast.ReturnExpression, error) {
+	infoLog.info("returnExp\n%s\n", p.tokenStr(node))
+
+	returnExp := ast.NewReturn(nil)
+	returnExp.Span = p.span(node)
+
+	if node.up != nil && node.up.pegRule == ruleexpression {
+		exp, err := p.expression(node.up)
+		if err != nil {
+			return nil, err
+		}
+		returnExp.Value = exp
+	}
+
+	return &returnExp, nil
+}
+
 func (p *Toy) ifExp(node *node32) (*ast.IfExpression, error) {
 	infoLog.info("ifExp\n%s\n", p.tokenStr(node))
 
@@ -181,13 +438,15 @@ func (p *Toy) ifExp(node *node32) (*ast.IfExpression, error) {
 	var thenClause *ast.BlockExpression
 	var elseClause *ast.BlockExpression
 
+	span := p.span(node)
+
 	node = node.up
 	for node != nil {
 		var err error
 
 		switch node.pegRule {
-		case rulecomparative:
-			cond, err = p.comparative(node)
+		case ruledisjunctive:
+			cond, err = p.disjunctive(node)
 			if err != nil {
 				return nil, err
 			}
@@ -212,6 +471,7 @@ func (p *Toy) ifExp(node *node32) (*ast.IfExpression, error) {
 	} else {
 		ifExp = ast.NewIf(cond, *thenClause, *elseClause)
 	}
+	ifExp.Span = span
 	return &ifExp, nil
 }
 
@@ -221,25 +481,31 @@ func (p *Toy) while(node *node32) (*ast.WhileExpression, error) {
 	var cond ast.Expression
 	var body *ast.BlockExpression
 
+	span := p.span(node)
+
 	node = node.up
 	for node != nil {
 		var err error
 
 		switch node.pegRule {
-		case rulecomparative:
-			cond, err = p.comparative(node)
+		case ruledisjunctive:
+			cond, err = p.disjunctive(node)
 			if err != nil {
 				return nil, err
 			}
 
 		case ruleblockExpression:
 			body, err = p.block(node)
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		node = node.next
 	}
 
 	while := ast.NewWhile(cond, *body)
+	while.Span = span
 	return &while, nil
 }
 
@@ -248,6 +514,8 @@ func (p *Toy) block(node *node32) (*ast.BlockExpression, error) {
 
 	var expressions []ast.Expression
 
+	span := p.span(node)
+
 	node = node.up
 	for node != nil {
 		switch node.pegRule {
@@ -263,6 +531,7 @@ func (p *Toy) block(node *node32) (*ast.BlockExpression, error) {
 	}
 
 	block := ast.NewBlock(expressions)
+	block.Span = span
 	return &block, nil
 }
 
@@ -272,6 +541,8 @@ func (p *Toy) assignment(node *node32) (*ast.Assignment, error) {
 	var name string
 	var exp ast.Expression
 
+	span := p.span(node)
+
 	node = node.up
 	for node != nil {
 		switch node.pegRule {
@@ -290,12 +561,15 @@ func (p *Toy) assignment(node *node32) (*ast.Assignment, error) {
 	}
 
 	assignment := ast.NewAssignment(name, exp)
+	assignment.Span = span
 	return &assignment, nil
 }
 
 func (p *Toy) println(node *node32) (*ast.Println, error) {
 	infoLog.info("println\n%s\n", p.tokenStr(node))
 
+	span := p.span(node)
+
 	node = node.up
 	for node != nil {
 		switch node.pegRule {
@@ -305,6 +579,7 @@ func (p *Toy) println(node *node32) (*ast.Println, error) {
 				return nil, err
 			}
 			printlnExp := ast.NewPrintln(exp)
+			printlnExp.Span = span
 			return &printlnExp, nil
 		}
 
@@ -314,18 +589,219 @@ func (p *Toy) println(node *node32) (*ast.Println, error) {
 	return nil, fmt.Errorf("not reach here")
 }
 
-func (p *Toy) funcCall(node *node32) (*ast.FunctionCall, error) {
-	infoLog.info("funcCall\n%s\n", p.tokenStr(node))
+func (p *Toy) funcLiteral(node *node32) (*ast.FunctionLiteral, error) {
+	infoLog.info("funcLiteral\n%s\n", p.tokenStr(node))
 
-	var name string
-	var args []ast.Expression
+	var funcDef ast.FunctionDefinition
+	var body *ast.BlockExpression
+
+	span := p.span(node)
 
 	node = node.up
 	for node != nil {
 		switch node.pegRule {
-		case ruleidentifier:
-			name = p.identifier(node).Name
+		case ruleparameters:
+			if err := p.parameters(node, &funcDef); err != nil {
+				return nil, err
+			}
+
+		case rulereturnType:
+			funcDef.ReturnType = p.typeName(node)
+
+		case ruleblockExpression:
+			var err error
+			body, err = p.block(node)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		node = node.next
+	}
+
+	funcLiteral := ast.NewFuncLiteral(funcDef.Args, *body)
+	funcLiteral.Defaults = funcDef.Defaults
+	funcLiteral.Variadic = funcDef.Variadic
+	funcLiteral.ArgTypes = funcDef.ArgTypes
+	funcLiteral.ReturnType = funcDef.ReturnType
+	funcLiteral.Span = span
+	return &funcLiteral, nil
+}
+
+// postfix folds the argument lists following an operand into nested calls,
+// so that f(1)(2) calls the result of f(1).
+func (p *Toy) postfix(node *node32) (ast.Expression, error) {
+	infoLog.info("postfix\n%s\n", p.tokenStr(node))
+
+	start := p.span(node).Start
+
+	var exp ast.Expression
+	node = node.up
+	for node != nil {
+		switch node.pegRule {
+		case ruleoperand:
+			var err error
+			exp, err = p.operand(node)
+			if err != nil {
+				return nil, err
+			}
+
+		case rulearguments:
+			args, err := p.arguments(node)
+			if err != nil {
+				return nil, err
+			}
+
+			var funcCall ast.FunctionCall
+			if identifier, ok := exp.(ast.Identifier); ok {
+				funcCall = ast.NewFuncCall(identifier.Name, args)
+			} else {
+				funcCall = ast.NewCall(exp, args)
+			}
+			funcCall.Span = ast.Span{Start: start, End: p.span(node).End}
+			exp = funcCall
+
+		case ruleindex:
+			index, err := p.expression(node.up)
+			if err != nil {
+				return nil, err
+			}
+
+			indexExp := ast.NewIndex(exp, index)
+			indexExp.Span = ast.Span{Start: start, End: p.span(node).End}
+			exp = indexExp
+
+		case rulefield:
+			fieldAccess := ast.NewFieldAccess(exp, p.tokenStr(node.up))
+			fieldAccess.Span = ast.Span{Start: start, End: p.span(node).End}
+			exp = fieldAccess
+		}
+
+		node = node.next
+	}
+
+	return exp, nil
+}
+
+func (p *Toy) listLiteral(node *node32) (*ast.ListLiteral, error) {
+	infoLog.info("listLiteral\n%s\n", p.tokenStr(node))
+
+	span := p.span(node)
+
+	elements, err := p.arguments(node)
+	if err != nil {
+		return nil, err
+	}
+
+	list := ast.NewListLiteral(elements)
+	list.Span = span
+	return &list, nil
+}
+
+func (p *Toy) mapLiteral(node *node32) (*ast.MapLiteral, error) {
+	infoLog.info("mapLiteral\n%s\n", p.tokenStr(node))
+
+	span := p.span(node)
+
+	var keys, values []ast.Expression
+	for entry := node.up; entry != nil; entry = entry.next {
+		if entry.pegRule != rulemapEntry {
+			continue
+		}
+
+		key, err := p.disjunctive(entry.up)
+		if err != nil {
+			return nil, err
+		}
+		v, err := p.expression(p.find(entry.up.next, ruleexpression))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		values = append(values, v)
+	}
+
+	mapLiteral := ast.NewMapLiteral(keys, values)
+	mapLiteral.Span = span
+	return &mapLiteral, nil
+}
+
+// postfixAssignment accepts any postfix on its left-hand side, and reports one
+// which does not end with an index or a field.
+func (p *Toy) postfixAssignment(node *node32) (ast.Expression, error) {
+	infoLog.info("postfixAssignment\n%s\n", p.tokenStr(node))
 
+	span := p.span(node)
+
+	node = node.up
+	target, err := p.postfix(node)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := p.expression(p.find(node.next, ruleexpression))
+	if err != nil {
+		return nil, err
+	}
+
+	switch target := target.(type) {
+	case ast.IndexExpression:
+		exp := ast.NewIndexAssignment(target.Collection, target.Index, v)
+		exp.Span = span
+		return exp, nil
+
+	case ast.FieldAccess:
+		exp := ast.NewFieldAssignment(target.Object, target.Field, v)
+		exp.Span = span
+		return exp, nil
+	}
+
+	return nil, fmt.Errorf("%s: cannot assign to %s", p.span(node).Start, p.tokenStr(node))
+}
+
+func (p *Toy) structLiteral(node *node32) (*ast.StructLiteral, error) {
+	infoLog.info("structLiteral\n%s\n", p.tokenStr(node))
+
+	span := p.span(node)
+
+	node = node.up
+	name := p.tokenStr(node)
+
+	var fields []string
+	var values []ast.Expression
+	seen := map[string]bool{}
+	for node = node.next; node != nil; node = node.next {
+		if node.pegRule != rulefieldValue {
+			continue
+		}
+
+		field := p.tokenStr(node.up)
+		if seen[field] {
+			return nil, fmt.Errorf("%s: field %s is given twice", p.span(node).Start, field)
+		}
+		seen[field] = true
+
+		v, err := p.expression(p.find(node.up.next, ruleexpression))
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+		values = append(values, v)
+	}
+
+	structLiteral := ast.NewStructLiteral(name, fields, values)
+	structLiteral.Span = span
+	return &structLiteral, nil
+}
+
+func (p *Toy) arguments(node *node32) ([]ast.Expression, error) {
+	infoLog.info("arguments\n%s\n", p.tokenStr(node))
+
+	var args []ast.Expression
+
+	node = node.up
+	for node != nil {
+		switch node.pegRule {
 		case ruleexpression:
 			exp, err := p.expression(node)
 			if err != nil {
@@ -337,15 +813,69 @@ func (p *Toy) funcCall(node *node32) (*ast.FunctionCall, error) {
 		node = node.next
 	}
 
-	funcCall := ast.NewFuncCall(name, args)
-	return &funcCall, nil
+	return args, nil
+}
+
+func (p *Toy) disjunctive(node *node32) (ast.Expression, error) {
+	infoLog.info("disjunctive\n%s\n", p.tokenStr(node))
+
+	// fold operand ( operator operand )* into a left-associative tree
+	var exp ast.Expression
+
+	node = node.up
+	for node != nil {
+		switch node.pegRule {
+		case ruleconjunctive:
+			conjunctive, err := p.conjunctive(node)
+			if err != nil {
+				return nil, err
+			}
+
+			if exp == nil {
+				exp = conjunctive
+			} else {
+				exp = newBinary(ast.Or, exp, conjunctive)
+			}
+		}
+
+		node = node.next
+	}
+
+	return exp, nil
+}
+
+func (p *Toy) conjunctive(node *node32) (ast.Expression, error) {
+	infoLog.info("conjunctive\n%s\n", p.tokenStr(node))
+
+	var exp ast.Expression
+
+	node = node.up
+	for node != nil {
+		switch node.pegRule {
+		case rulecomparative:
+			comparative, err := p.comparative(node)
+			if err != nil {
+				return nil, err
+			}
+
+			if exp == nil {
+				exp = comparative
+			} else {
+				exp = newBinary(ast.And, exp, comparative)
+			}
+		}
+
+		node = node.next
+	}
+
+	return exp, nil
 }
 
 func (p *Toy) comparative(node *node32) (ast.Expression, error) {
 	infoLog.info("comparative\n%s\n", p.tokenStr(node))
 
-	var lhs, rhs ast.Expression
-	var operator ast.Operator = -1
+	var exp ast.Expression
+	var operator ast.Operator
 
 	node = node.up
 	for node != nil {
@@ -356,10 +886,10 @@ func (p *Toy) comparative(node *node32) (ast.Expression, error) {
 				return nil, err
 			}
 
-			if lhs == nil {
-				lhs = additive
+			if exp == nil {
+				exp = additive
 			} else {
-				rhs = additive
+				exp = newBinary(operator, exp, additive)
 			}
 
 		case rulecomparativeOperator:
@@ -373,18 +903,14 @@ func (p *Toy) comparative(node *node32) (ast.Expression, error) {
 		node = node.next
 	}
 
-	if operator == -1 {
-		return lhs, nil
-	} else {
-		return ast.NewBinary(operator, lhs, rhs), nil
-	}
+	return exp, nil
 }
 
 func (p *Toy) additive(node *node32) (ast.Expression, error) {
 	infoLog.info("additive\n%s\n", p.tokenStr(node))
 
-	var lhs, rhs ast.Expression
-	var operator ast.Operator = -1
+	var exp ast.Expression
+	var operator ast.Operator
 
 	node = node.up
 	for node != nil {
@@ -395,10 +921,10 @@ func (p *Toy) additive(node *node32) (ast.Expression, error) {
 				return nil, err
 			}
 
-			if lhs == nil {
-				lhs = multitive
+			if exp == nil {
+				exp = multitive
 			} else {
-				rhs = multitive
+				exp = newBinary(operator, exp, multitive)
 			}
 
 		case ruleadditiveOperator:
@@ -412,32 +938,28 @@ func (p *Toy) additive(node *node32) (ast.Expression, error) {
 		node = node.next
 	}
 
-	if operator == -1 {
-		return lhs, nil
-	} else {
-		return ast.NewBinary(operator, lhs, rhs), nil
-	}
+	return exp, nil
 }
 
 func (p *Toy) multitive(node *node32) (ast.Expression, error) {
 	infoLog.info("multitive\n%s\n", p.tokenStr(node))
 
-	var lhs, rhs ast.Expression
-	var operator ast.Operator = -1
+	var exp ast.Expression
+	var operator ast.Operator
 
 	node = node.up
 	for node != nil {
 		switch node.pegRule {
-		case ruleprimary:
-			primary, err := p.primary(node)
+		case ruleunary:
+			unary, err := p.unary(node)
 			if err != nil {
 				return nil, err
 			}
 
-			if lhs == nil {
-				lhs = primary
+			if exp == nil {
+				exp = unary
 			} else {
-				rhs = primary
+				exp = newBinary(operator, exp, unary)
 			}
 
 		case rulemultitiveOperator:
@@ -451,11 +973,80 @@ func (p *Toy) multitive(node *node32) (ast.Expression, error) {
 		node = node.next
 	}
 
-	if operator == -1 {
-		return lhs, nil
-	} else {
-		return ast.NewBinary(operator, lhs, rhs), nil
+	return exp, nil
+}
+
+func newBinary(op ast.Operator, lhs, rhs ast.Expression) ast.BinaryExpression {
+	binary := ast.NewBinary(op, lhs, rhs)
+	binary.Span = ast.Span{Start: lhs.Pos(), End: rhs.Range().End}
+	return binary
+}
+
+func (p *Toy) unary(node *node32) (ast.Expression, error) {
+	infoLog.info("unary\n%s\n", p.tokenStr(node))
+
+	span := p.span(node)
+
+	node = node.up
+	switch node.pegRule {
+	case ruleunaryOperator:
+		op, err := p.unaryOperator(node)
+		if err != nil {
+			return nil, err
+		}
+		operand, err := p.unary(node.next)
+		if err != nil {
+			return nil, err
+		}
+
+		unary := ast.NewUnary(op, operand)
+		unary.Span = span
+		return unary, nil
+
+	case rulenegativeNumber:
+		// NOTE: the sign is part of the literal, so that the smallest int fits
+		if node.up.pegRule == rulefloat {
+			exp, err := p.float(node)
+			if err != nil {
+				return nil, err
+			}
+			return *exp, nil
+		}
+		exp, err := p.integer(node)
+		if err != nil {
+			return nil, err
+		}
+		return *exp, nil
+
+	case rulepower:
+		return p.power(node)
 	}
+
+	return nil, fmt.Errorf("not reach here")
+}
+
+// power folds to the right, since its exponent is a unary which may be a power
+// itself.
+func (p *Toy) power(node *node32) (ast.Expression, error) {
+	infoLog.info("power\n%s\n", p.tokenStr(node))
+
+	node = node.up
+	base, err := p.primary(node)
+	if err != nil {
+		return nil, err
+	}
+
+	for node = node.next; node != nil; node = node.next {
+		if node.pegRule == ruleunary {
+			exponent, err := p.unary(node)
+			if err != nil {
+				return nil, err
+			}
+			return newBinary(ast.Power, base, exponent), nil
+		}
+	}
+
+	return base, nil
 }
 
 func (p *Toy) primary(node *node32) (ast.Expression, error) {
@@ -464,22 +1055,87 @@ func (p *Toy) primary(node *node32) (ast.Expression, error) {
 	node = node.up
 	for node != nil {
 		switch node.pegRule {
-		case rulecomparative:
-			return p.comparative(node)
 		case ruleprintln:
 			exp, err := p.println(node)
-			return *exp, err
-		case rulefunctionCall:
-			exp, err := p.funcCall(node)
-			return *exp, err
+			if err != nil {
+				return nil, err
+			}
+			return *exp, nil
+		case rulepostfix:
+			return p.postfix(node)
+		}
+
+		node = node.next
+	}
+
+	return nil, fmt.Errorf("not reach here")
+}
+
+func (p *Toy) operand(node *node32) (ast.Expression, error) {
+	infoLog.info("operand\n%s\n", p.tokenStr(node))
+
+	node = node.up
+	for node != nil {
+		switch node.pegRule {
+		case ruledisjunctive:
+			return p.disjunctive(node)
+		case rulefunctionLiteral:
+			exp, err := p.funcLiteral(node)
+			if err != nil {
+				return nil, err
+			}
+			return *exp, nil
+
+		case rulelistLiteral:
+			exp, err := p.listLiteral(node)
+			if err != nil {
+				return nil, err
+			}
+			return *exp, nil
+
+		case rulemapLiteral:
+			exp, err := p.mapLiteral(node)
+			if err != nil {
+				return nil, err
+			}
+			return *exp, nil
+
+		case rulestructLiteral:
+			exp, err := p.structLiteral(node)
+			if err != nil {
+				return nil, err
+			}
+			return *exp, nil
+
+		case ruleboolean:
+			exp := ast.NewBool(p.tokenStr(node) == "true")
+			exp.Span = p.span(node)
+			return exp, nil
 
 		case ruleidentifier:
 			exp := p.identifier(node)
 			return *exp, nil
 
+		case rulefloat:
+			exp, err := p.float(node)
+			if err != nil {
+				return nil, err
+			}
+			return *exp, nil
+
 		case ruleinteger:
 			exp, err := p.integer(node)
-			return *exp, err
+			if err != nil {
+				return nil, err
+			}
+			return *exp, nil
+
+		case rulestringLiteral:
+			exp, err := p.stringLiteral(node)
+			if err != nil {
+				return nil, err
+			}
+			return *exp, nil
 		}
 
 		node = node.next
@@ -530,6 +1186,21 @@ func (p *Toy) multitiveOperator(node *node32) (ast.Operator, error) {
 		return ast.Multiply, nil
 	case "/":
 		return ast.Divide, nil
+	case "%":
+		return ast.Modulo, nil
+	}
+
+	return -1, fmt.Errorf("not reach here")
+}
+
+func (p *Toy) unaryOperator(node *node32) (ast.Operator, error) {
+	infoLog.info("unaryOperator\n%s\n", p.tokenStr(node))
+
+	switch p.tokenStr(node) {
+	case "!":
+		return ast.Not, nil
+	case "-":
+		return ast.Negate, nil
 	}
 
 	return -1, fmt.Errorf("not reach here")
@@ -540,21 +1211,109 @@ func (p *Toy) identifier(node *node32) *ast.Identifier {
 
 	s := p.tokenStr(node)
 	identifier := ast.NewIdentifier(s)
+	identifier.Span = p.span(node)
 	return &identifier
 }
 
 func (p *Toy) integer(node *node32) (*ast.IntegerLiteral, error) {
 	infoLog.info("integer\n%s\n", p.tokenStr(node))
 
+	var integer ast.IntegerLiteral
 	n, err := p.tokenInt(node)
-	if err != nil {
+	switch {
+	case errors.Is(err, strconv.ErrRange):
+		// NOTE: whether a literal out of range is an error depends on the
+		// arithmetic, so it is left to the evaluation
+		value, _ := new(big.Int).SetString(p.tokenStr(node), 10)
+		integer = ast.NewBigInteger(value)
+	case err != nil:
 		return nil, err
+	default:
+		integer = ast.NewInteger(n)
 	}
 
-	integer := ast.NewInteger(n)
+	integer.Span = p.span(node)
 	return &integer, nil
 }
 
+func (p *Toy) float(node *node32) (*ast.FloatLiteral, error) {
+	infoLog.info("float\n%s\n", p.tokenStr(node))
+
+	f, err := strconv.ParseFloat(p.tokenStr(node), 64)
+	if err != nil {
+		return nil, err
+	}
+
+	float := ast.NewFloat(f)
+	float.Span = p.span(node)
+	return &float, nil
+}
+
+func (p *Toy) span(node *node32) ast.Span {
+	// some rules consume trailing spaces and comments, which are not part of
+	// the node
+	end := int(node.end)
+	for {
+		for end > int(node.begin) && unicode.IsSpace(p.buffer[end-1]) {
+			end--
+		}
+		start, ok := p.commentStarts[end]
+		if !ok || start < int(node.begin) {
+			break
+		}
+		end = start
+	}
+
+	return ast.Span{
+		Start: p.position(int(node.begin)),
+		End:   p.position(end),
+	}
+}
+
+func (p *Toy) position(offset int) ast.Position {
+	if p.lineStarts == nil {
+		p.lineStarts = []int{0}
+		for i, r := range p.buffer {
+			if r == '\n' {
+				p.lineStarts = append(p.lineStarts, i+1)
+			}
+		}
+	}
+
+	// find the last line which starts at or before offset
+	line := sort.Search(len(p.lineStarts), func(i int) bool { return p.lineStarts[i] > offset })
+
+	return ast.Position{
+		File:   p.Filename,
+		Offset: offset,
+		Line:   line,
+		Column: offset - p.lineStarts[line-1] + 1,
+	}
+}
+
+func (p *Toy) stringLiteral(node *node32) (*ast.StringLiteral, error) {
+	infoLog.info("stringLiteral\n%s\n", p.tokenStr(node))
+
+	// the escapes allowed by the grammar are a subset of Go's
+	s, err := strconv.Unquote(p.tokenStr(node))
+	if err != nil {
+		return nil, err
+	}
+
+	str := ast.NewString(s)
+	str.Span = p.span(node)
+	return &str, nil
+}
+
+// find returns node or the first of its siblings after it which is rule, or
+// nil if there is none.
+func (p *Toy) find(node *node32, rule pegRule) *node32 {
+	for node != nil && node.pegRule != rule {
+		node = node.next
+	}
+	return node
+}
+
 func (p *Toy) token(node *node32) []rune {
 	return p.buffer[node.begin:node.end]
 }
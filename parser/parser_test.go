@@ -2,20 +2,57 @@ package parser
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/builtins"
 	"github.com/TOMOFUMI-KONDO/toy/interpreter"
+	"github.com/TOMOFUMI-KONDO/toy/printer"
+	"github.com/TOMOFUMI-KONDO/toy/typecheck"
+	"github.com/TOMOFUMI-KONDO/toy/value"
+	"github.com/TOMOFUMI-KONDO/toy/vm"
 )
 
+type engine interface {
+	CallMain(program ast.Program) (value.Value, error)
+}
+
+var engines = []struct {
+	name string
+	new  func(w io.Writer) engine
+}{
+	{
+		"interpreter",
+		func(w io.Writer) engine {
+			i := interpreter.NewInterpreterWithWriter(w)
+			return &i
+		},
+	},
+	{
+		"vm",
+		func(w io.Writer) engine {
+			m := vm.NewVMWithWriter(w)
+			return &m
+		},
+	},
+}
+
 type testCase struct {
 	expression string
-	expected   int
+	expected   value.Value
 	printed    string
 }
 
 func (t *testCase) String() string {
-	return fmt.Sprintf("expression: %s\nexpectred: %d\nprinted: %s\n", t.expression, t.expected, t.printed)
+	return fmt.Sprintf("expression: %s\nexpectred: %v\nprinted: %s\n", t.expression, t.expected, t.printed)
 }
 
 var tests = []testCase{
@@ -24,7 +61,7 @@ var tests = []testCase{
 		`define main() {
 			1
 		}`,
-		1,
+		value.Int(1),
 		"",
 	},
 	// test multiply
@@ -32,7 +69,7 @@ var tests = []testCase{
 		`define main() {
 			2*3
 		}`,
-		6,
+		value.Int(6),
 		"",
 	},
 	// test divide
@@ -40,7 +77,7 @@ var tests = []testCase{
 		`define main() {
 			10/2
 		}`,
-		5,
+		value.Int(5),
 		"",
 	},
 	// test add
@@ -48,7 +85,7 @@ var tests = []testCase{
 		`define main() {
 			1+2
 		}`,
-		3,
+		value.Int(3),
 		"",
 	},
 	// test subtract
@@ -56,7 +93,7 @@ var tests = []testCase{
 		`define main() {
 			3-1
 		}`,
-		2,
+		value.Int(2),
 		"",
 	},
 	// test lessThan
@@ -64,21 +101,21 @@ var tests = []testCase{
 		`define main() {
 			1<2
 		}`,
-		1,
+		value.Bool(true),
 		"",
 	},
 	{
 		`define main() {
 			2<2
 		}`,
-		0,
+		value.Bool(false),
 		"",
 	},
 	{
 		`define main() {
 			3<2
 		}`,
-		0,
+		value.Bool(false),
 		"",
 	},
 	// test lessOrEqual
@@ -86,21 +123,21 @@ var tests = []testCase{
 		`define main() {
 			1<=2
 		}`,
-		1,
+		value.Bool(true),
 		"",
 	},
 	{
 		`define main() {
 			2<=2
 		}`,
-		1,
+		value.Bool(true),
 		"",
 	},
 	{
 		`define main() {
 			3<=2
 		}`,
-		0,
+		value.Bool(false),
 		"",
 	},
 	// test greaterThan
@@ -108,21 +145,21 @@ var tests = []testCase{
 		`define main() {
 			3>2
 		}`,
-		1,
+		value.Bool(true),
 		"",
 	},
 	{
 		`define main() {
 			2>2
 		}`,
-		0,
+		value.Bool(false),
 		"",
 	},
 	{
 		`define main() {
 			1>2
 		}`,
-		0,
+		value.Bool(false),
 		"",
 	},
 	// test greaterOrEqual
@@ -130,21 +167,21 @@ var tests = []testCase{
 		`define main() {
 			3>=2
 		}`,
-		1,
+		value.Bool(true),
 		"",
 	},
 	{
 		`define main() {
 			2>=2
 		}`,
-		1,
+		value.Bool(true),
 		"",
 	},
 	{
 		`define main() {
 			1>=2
 		}`,
-		0,
+		value.Bool(false),
 		"",
 	},
 	// test Equal
@@ -152,21 +189,21 @@ var tests = []testCase{
 		`define main() {
 			1==2
 		}`,
-		0,
+		value.Bool(false),
 		"",
 	},
 	{
 		`define main() {
 			2==2
 		}`,
-		1,
+		value.Bool(true),
 		"",
 	},
 	{
 		`define main() {
 			3==2
 		}`,
-		0,
+		value.Bool(false),
 		"",
 	},
 	// test NotEqual
@@ -174,21 +211,21 @@ var tests = []testCase{
 		`define main() {
 			1!=2
 		}`,
-		1,
+		value.Bool(true),
 		"",
 	},
 	{
 		`define main() {
 			2!=2
 		}`,
-		0,
+		value.Bool(false),
 		"",
 	},
 	{
 		`define main() {
 			3!=2
 		}`,
-		1,
+		value.Bool(true),
 		"",
 	},
 	// test println
@@ -196,7 +233,7 @@ var tests = []testCase{
 		`define main() {
 			println(2)
 		}`,
-		2,
+		value.Int(2),
 		"2",
 	},
 	// test functionCall
@@ -207,7 +244,7 @@ var tests = []testCase{
 		define main() {
 			two()
 		}`,
-		2,
+		value.Int(2),
 		"",
 	},
 	{
@@ -217,7 +254,7 @@ var tests = []testCase{
 		define main() {
 			oneArg(2)
 		}`,
-		4,
+		value.Int(4),
 		"",
 	},
 	{
@@ -227,7 +264,7 @@ var tests = []testCase{
 		define main() {
 			twoArgs(2,3)
 		}`,
-		5,
+		value.Int(5),
 		"",
 	},
 	// test assignment
@@ -235,7 +272,7 @@ var tests = []testCase{
 		`define main() {
 			n=2
 		}`,
-		2,
+		value.Int(2),
 		"",
 	},
 	{
@@ -247,7 +284,7 @@ var tests = []testCase{
 			n=2
 			shadow(n)
 		}`,
-		4,
+		value.Int(4),
 		"",
 	},
 	// test block
@@ -259,7 +296,7 @@ var tests = []testCase{
 				4
 			}
 		}`,
-		4,
+		value.Int(4),
 		"",
 	},
 	// test while
@@ -271,7 +308,7 @@ var tests = []testCase{
 			}
 			n
 		}`,
-		0,
+		value.Int(0),
 		"",
 	},
 	// test if
@@ -281,7 +318,7 @@ var tests = []testCase{
 				2
 			}
 		}`,
-		2,
+		value.Int(2),
 		"",
 	},
 	{
@@ -292,7 +329,7 @@ var tests = []testCase{
 				3
 			}
 		}`,
-		3,
+		value.Int(3),
 		"",
 	},
 	// test globalVariableDefinition
@@ -301,7 +338,36 @@ var tests = []testCase{
 		define main() {
 			n
 		}`,
-		2,
+		value.Int(2),
+		"",
+	},
+	// test comments around operators, = and ,
+	{
+		`global n = /* two */ 2
+		define add(a, // first
+			b = /* default */ n) {
+			a /* plus */ + b // sum
+		}
+		define main() {
+			x /* one */ = 1
+			if x /* cond */ == /* one */ 1 {
+				add(x, /* arg */ 2) * /* times */ 2 ** /* power */ 2 + [1, // one
+					2][1] + println( /* printed */ x)
+			}
+		}`,
+		value.Int(15),
+		"1",
+	},
+	// test uneven spacing around = and ,
+	{
+		`global n =2
+		define add(a ,b= n) {
+			a+b
+		}
+		define main() {
+			add(1)
+		}`,
+		value.Int(3),
 		"",
 	},
 	// test complex program
@@ -317,43 +383,1782 @@ var tests = []testCase{
 			result=factorial(5)
 			println(result)
 		}`,
-		120,
+		value.Int(120),
 		"120",
 	},
-}
-
-func TestParser(t *testing.T) {
-	for _, test := range tests {
-		toy := &Toy{Buffer: test.expression}
-		if err := setUp(toy); err != nil {
-			t.Fatalf("%v\ntestCase = \n%s", err, test.String())
+	// test lexical scope
+	{
+		`define callee() {
+			x=5
+			x
 		}
-
-		var buf bytes.Buffer
-		i := interpreter.NewInterpreterWithWriter(&buf)
-
-		result, err := i.CallMain(toy.Program)
-		if err != nil {
-			t.Fatalf("%v\ntestCase = \n%s", err, test.String())
+		define main() {
+			x=1
+			callee()
+			x
+		}`,
+		value.Int(1),
+		"",
+	},
+	{
+		`global g=1
+		define callee() {
+			g=g+1
 		}
-
-		if result != test.expected {
-			t.Errorf("result = %d\ntestCase = \n%s", result, test.String())
+		define main() {
+			callee()
+			callee()
+			g
+		}`,
+		value.Int(3),
+		"",
+	},
+	// test string
+	{
+		`define main() {
+			"hello"
+		}`,
+		value.String("hello"),
+		"",
+	},
+	{
+		`define main() {
+			println("tab\tquote\"backslash\\")
+		}`,
+		value.String("tab\tquote\"backslash\\"),
+		"tab\tquote\"backslash\\",
+	},
+	{
+		`define label(name,n) {
+			name+": "+n
 		}
-
-		printed := string(buf.Bytes())
-		if printed != test.printed {
-			t.Errorf("printed = %s\ntestCase = \n%s", printed, test.String())
+		define main() {
+			println(label("answer",42))
+		}`,
+		value.String("answer: 42"),
+		"answer: 42",
+	},
+	{
+		`define main() {
+			1+"st"
+		}`,
+		value.String("1st"),
+		"",
+	},
+	{
+		`define main() {
+			"a"=="a"
+		}`,
+		value.Bool(true),
+		"",
+	},
+	{
+		`define main() {
+			"a"!="b"
+		}`,
+		value.Bool(true),
+		"",
+	},
+	{
+		`define main() {
+			"1"==1
+		}`,
+		value.Bool(false),
+		"",
+	},
+	// test first-class functions
+	{
+		`define makeCounter() {
+			n=0
+			define() {
+				n=n+1
+			}
 		}
-	}
+		define main() {
+			counter=makeCounter()
+			other=makeCounter()
+			counter()
+			counter()
+			other()
+			counter()
+		}`,
+		value.Int(3),
+		"",
+	},
+	{
+		`define twice(f,x) {
+			f(f(x))
+		}
+		define main() {
+			k=3
+			twice(define(x) { x*k },2)
+		}`,
+		value.Int(18),
+		"",
+	},
+	{
+		`define add(x) {
+			define(y) { x+y }
+		}
+		define main() {
+			add(1)(2)
+		}`,
+		value.Int(3),
+		"",
+	},
+	{
+		`define main() {
+			define(x) { println(x) }("iife")
+		}`,
+		value.String("iife"),
+		"iife",
+	},
+	{
+		`define square(x) {
+			x*x
+		}
+		define apply(f,x) {
+			f(x)
+		}
+		define main() {
+			f=square
+			apply(f,3)+apply(square,4)+(f)(5)
+		}`,
+		value.Int(50),
+		"",
+	},
+	{
+		`define main() {
+			a=define() { 1 }
+			b=a
+			a==b&&a!=define() { 1 }
+		}`,
+		value.Bool(true),
+		"",
+	},
+	{
+		`define main() {
+			x=1
+			inc=define() {
+				x=x+1
+				y=10
+			}
+			inc()
+			inc()
+			y=x
+			y
+		}`,
+		value.Int(3),
+		"",
+	},
+	// test default and variadic parameters
+	{
+		`define greet(name,greeting="hello",mark="!") {
+			greeting+", "+name+mark
+		}
+		define main() {
+			println(greet("toy"))
+			greet("toy","bye")
+		}`,
+		value.String("bye, toy!"),
+		"hello, toy!",
+	},
+	{
+		`define scale(n,by=n*2) {
+			by
+		}
+		define main() {
+			scale(3)+scale(3,1)
+		}`,
+		value.Int(7),
+		"",
+	},
+	{
+		`define all(first,rest...) {
+			println(rest)
+			first
+		}
+		define main() {
+			all(1)
+			all(1,2,"three")
+		}`,
+		value.Int(1),
+		"[][2, \"three\"]",
+	},
+	{
+		`define main() {
+			f=define(a,b=10,rest...) {
+				println(rest)
+				a+b
+			}
+			f(1)+f(1,2,3)
+		}`,
+		value.Int(14),
+		"[][3]",
+	},
+	// test return, break and continue
+	{
+		`define find(n) {
+			i=0
+			while 1 {
+				if i*i>=n {
+					return i
+				}
+				i=i+1
+			}
+			0-1
+		}
+		define main() {
+			find(50)
+		}`,
+		value.Int(8),
+		"",
+	},
+	{
+		`define main() {
+			i=0
+			sum=0
+			while i<10 {
+				i=i+1
+				if i==3 {
+					continue
+				}
+				if i==6 {
+					break
+				}
+				sum=sum+println(if i==5 { break } else { i })
+			}
+			sum
+		}`,
+		value.Int(7),
+		"124",
+	},
+	{
+		`define main() {
+			i=0
+			while i<3 {
+				i=i+1
+				j=0
+				while 1 {
+					j=j+1
+					if j==2 {
+						break
+					}
+				}
+				println(j)
+			}
+		}`,
+		value.Int(1),
+		"222",
+	},
+	{
+		`define main() {
+			f=define(x) {
+				if x {
+					return "early"
+				}
+				"late"
+			}
+			f(1)+f(0)
+		}`,
+		value.String("earlylate"),
+		"",
+	},
+	{
+		`define returned() {
+			breaks=1
+			return
+		}
+		define main() {
+			returned()
+		}`,
+		value.Int(0),
+		"",
+	},
+	// test booleans
+	{
+		`define main() {
+			println(true)
+			false
+		}`,
+		value.Bool(false),
+		"true",
+	},
+	{
+		`define main() {
+			t=1<2
+			println(!t)
+			t==true
+		}`,
+		value.Bool(true),
+		"false",
+	},
+	// test short-circuit
+	{
+		`define never() {
+			println("called")
+			true
+		}
+		define main() {
+			println(false&&never())
+			true||never()
+		}`,
+		value.Bool(true),
+		"false",
+	},
+	{
+		`define main() {
+			n=0
+			while n<10&&!(n==3) {
+				n=n+1
+			}
+			n
+		}`,
+		value.Int(3),
+		"",
+	},
+	// test lists
+	{
+		`define main() {
+			xs=[1, "a", [true]]
+			println(xs)
+			xs[2][0]
+		}`,
+		value.Bool(true),
+		`[1, "a", [true]]`,
+	},
+	{
+		`define main() {
+			xs=[]
+			push(xs,1,2,3)
+			xs[1]=xs[0]+xs[2]
+			println(xs)
+			println(pop(xs))
+			len(xs)
+		}`,
+		value.Int(2),
+		"[1, 4, 3]3",
+	},
+	{
+		`define main() {
+			xs=[0,1,2,3,4]
+			println(slice(xs,1,3))
+			println(slice(xs,3))
+			len(slice(xs,5))
+		}`,
+		value.Int(0),
+		"[1, 2][3, 4]",
+	},
+	// test a list, a map and a struct which contain themselves print
+	{
+		`struct Node { next }
+		define main() {
+			xs=[1]
+			push(xs,xs)
+			println(xs)
+			ys=[xs,xs]
+			ys[1]=ys
+			println(ys)
+			m=#{"self": 0}
+			m["self"]=m
+			println(m)
+			n=Node{}
+			n.next=[n]
+			println(n)
+			len(xs)
+		}`,
+		value.Int(2),
+		`[1, [...]][[1, [...]], [...]]#{"self": #{...}}Node{next: [Node{...}]}`,
+	},
+	// test lists are passed by reference
+	{
+		`define fill(xs,n) {
+			i=0
+			while i<n {
+				push(xs,i*i)
+				i=i+1
+			}
+			xs
+		}
+		define main() {
+			ys=[]
+			zs=fill(ys,3)
+			println(ys)
+			zs[2]
+		}`,
+		value.Int(4),
+		"[0, 1, 4]",
+	},
+	// test builtins are values unless hidden
+	{
+		`define apply(f,x) {
+			f(x)
+		}
+		define hidden() {
+			len=define(x) { 0 }
+			len([1])
+		}
+		define main() {
+			println(apply(len,[1,2]))
+			hidden()
+		}`,
+		value.Int(0),
+		"2",
+	},
+	// test maps
+	{
+		`define main() {
+			m=#{"one": 1, 2: "two"}
+			m["three"]=m["one"]+2
+			m[2]="deux"
+			println(m)
+			m[2]
+		}`,
+		value.String("deux"),
+		`#{"one": 1, 2: "deux", "three": 3}`,
+	},
+	{
+		`define main() {
+			m=#{
+				"a": 1,
+				"b": 2,
+				"c": 3
+			}
+			println(delete(m,"a"))
+			println(delete(m,"a"))
+			m["a"]=4
+			println(keys(m))
+			println(has(m,"b")&&!has(m,"z"))
+			len(m)
+		}`,
+		value.Int(3),
+		`truefalse["b", "c", "a"]true`,
+	},
+	{
+		`define count(words) {
+			counts=#{}
+			i=0
+			while i<len(words) {
+				w=words[i]
+				if has(counts,w) {
+					counts[w]=counts[w]+1
+				} else {
+					counts[w]=1
+				}
+				i=i+1
+			}
+			counts
+		}
+		define main() {
+			println(count(["a","b","a"]))
+			len(#{})
+		}`,
+		value.Int(0),
+		`#{"a": 2, "b": 1}`,
+	},
+	// test structs
+	{
+		`struct Point { x, y }
+		define main() {
+			p=Point{x: 1, y: 2}
+			p.y=p.x+p.y
+			println(p)
+			p.y
+		}`,
+		value.Int(3),
+		"Point{x: 1, y: 3}",
+	},
+	{
+		`struct Point { x, y }
+		struct Line {
+			from,
+			to
+		}
+		define shift(p,dx) {
+			p.x=p.x+dx
+		}
+		define main() {
+			l=Line{to: Point{x: 3}}
+			shift(l.to,1)
+			println(l)
+			l.to.x
+		}`,
+		value.Int(4),
+		"Line{from: 0, to: Point{x: 4, y: 0}}",
+	},
+	// test type annotations, which do not change what a program does
+	{
+		`struct Point { x, y }
+		global origin: Point = Point{}
+		global scale: float=2
+		define norm(p: Point,n: int=1): float {
+			(p.x+p.y)*scale*n
+		}
+		define main(): any {
+			f=define(x: int,rest...): int { x+len(rest) }
+			norm(Point{x: 1, y: origin.y+f(1,2,3)})
+		}`,
+		value.Int(8),
+		"",
+	},
 }
 
-func setUp(t *Toy) error {
-	if err := t.Init(); err != nil {
-		return err
-	}
-	if err := t.Parse(); err != nil {
-		return err
-	}
-	return t.ConvertAst()
+var errorTests = []struct {
+	expression string
+	expected   string
+}{
+	{
+		`define main() {
+			"a"-1
+		}`,
+		"2:4: unsupported operand types for Subtract: string and int",
+	},
+	{
+		`define main() {
+			if "a" {
+				1
+			}
+		}`,
+		"2:7: condition must be bool or int, not string",
+	},
+	{
+		`define main() {
+			true&&"a"
+		}`,
+		"2:10: condition must be bool or int, not string",
+	},
+	{
+		`define main() {
+			!"a"
+		}`,
+		"2:4: condition must be bool or int, not string",
+	},
+	{
+		`define main() {
+			f=1
+			f(2)
+		}`,
+		"3:4: int is not a function",
+	},
+	{
+		`define main() {
+			1/0
+		}`,
+		"2:4: division by zero",
+	},
+	{
+		`define main() {
+			n=0
+			5%n
+		}`,
+		"3:4: modulo by zero",
+	},
+	{
+		`define main() {
+			2**-1
+		}`,
+		"2:4: negative exponent: -1",
+	},
+	{
+		`define main() {
+			-"a"
+		}`,
+		"2:4: unsupported operand type for Negate: string",
+	},
+	{
+		`define main() {
+			xs=[1,2,3]
+			xs[3]
+		}`,
+		"3:4: index 3 out of range for list of length 3",
+	},
+	{
+		`define main() {
+			xs=[1]
+			xs[-1]=0
+		}`,
+		"3:4: index -1 out of range for list of length 1",
+	},
+	{
+		`define main() {
+			[1]["a"]
+		}`,
+		"2:4: list index must be int, not string",
+	},
+	{
+		`define main() {
+			1[0]
+		}`,
+		"2:4: int is not indexable",
+	},
+	{
+		`define main() {
+			pop([])
+		}`,
+		"2:4: pop from empty list",
+	},
+	{
+		`define main() {
+			slice([1,2],1,3)
+		}`,
+		"2:4: slice bounds 1:3 out of range for list of length 2",
+	},
+	{
+		`define main() {
+			len(1)
+		}`,
+		"2:4: len: argument must be list, map or string, not int",
+	},
+	{
+		`define main() {
+			len([1],[2])
+		}`,
+		"2:4: function len takes 1 arguments but 2 were given",
+	},
+	{
+		`define main() {
+			m=#{1: "a"}
+			m["1"]
+		}`,
+		`3:4: key "1" not found`,
+	},
+	{
+		`define main() {
+			#{"a": 1, [1]: 2}
+		}`,
+		"2:14: map key must be int or string, not list",
+	},
+	{
+		`define main() {
+			m=#{}
+			m[true]=1
+		}`,
+		"3:4: map key must be int or string, not bool",
+	},
+	{
+		`define main() {
+			keys([1])
+		}`,
+		"2:4: keys: argument must be map, not list",
+	},
+	{
+		`define main() {
+			1.5/0
+		}`,
+		"2:4: division by zero",
+	},
+	{
+		`define main() {
+			1%0.0
+		}`,
+		"2:4: modulo by zero",
+	},
+	{
+		`define main() {
+			int(1e300)
+		}`,
+		"2:4: int: 1e+300 is out of range",
+	},
+	{
+		`define main() {
+			float("x")
+		}`,
+		`2:4: float: cannot parse "x"`,
+	},
+	{
+		`define main() {
+			[1][0.0]
+		}`,
+		"2:4: list index must be int, not float",
+	},
+	{
+		`struct Point { x, y }
+		define main() {
+			p=Point{x: 1}
+			p.z
+		}`,
+		"4:4: Point has no field z",
+	},
+	{
+		`struct Point { x, y }
+		define main() {
+			p=Point{x: 1}
+			p.z=1
+		}`,
+		"4:4: Point has no field z",
+	},
+	{
+		`define main() {
+			n=1
+			n.x
+		}`,
+		"3:4: int has no field x",
+	},
+	{
+		`struct Point { x, y }
+		define main() {
+			Point{x: 1, z: 2}
+		}`,
+		"3:4: Point has no field z",
+	},
+	{
+		`define main() {
+			Point{x: 1}
+		}`,
+		"2:4: struct Point is not defined",
+	},
+	{
+		`define add(a,b) {
+			a+b
+		}
+		define main() {
+			add(1)
+		}`,
+		"5:4: function add takes 2 arguments but 1 were given",
+	},
+	{
+		`define main() {
+			define(x) { x }(1,2)
+		}`,
+		"2:4: function takes 1 arguments but 2 were given",
+	},
+	{
+		`define add(a,b=1) {
+			a+b
+		}
+		define main() {
+			add(1,2,3)
+		}`,
+		"5:4: function add takes 1 to 2 arguments but 3 were given",
+	},
+	{
+		`define main() {
+			f=define(a,b,rest...) { a }
+			f(1)
+		}`,
+		"3:4: function takes at least 2 arguments but 1 were given",
+	},
+	{
+		`define main() {
+			break
+		}`,
+		"2:4: break outside loop",
+	},
+	{
+		`define main() {
+			while 1 {
+				f=define() { continue }
+			}
+		}`,
+		"3:18: continue outside loop",
+	},
+	// test lexical scope
+	{
+		`define callee() {
+			y
+		}
+		define main() {
+			y=3
+			callee()
+		}`,
+		"2:4: variable y is not defined",
+	},
+	// test undefined variables
+	{
+		`define main() {
+			x=1
+			x+z
+		}`,
+		"3:6: variable z is not defined",
+	},
+	{
+		`global a=b
+		global b=1
+		define main() {
+			a
+		}`,
+		"1:10: variable b is not defined",
+	},
+	{
+		`define main() {
+			f=define() { x+1 }
+			f()
+		}`,
+		"2:17: variable x is not defined",
+	},
+}
+
+func TestParser(t *testing.T) {
+	for _, e := range engines {
+		t.Run(e.name, func(t *testing.T) {
+			for _, test := range tests {
+				toy := &Toy{Buffer: test.expression}
+				if err := setUp(toy); err != nil {
+					t.Fatalf("%v\ntestCase = \n%s", err, test.String())
+				}
+
+				var buf bytes.Buffer
+				i := e.new(&buf)
+
+				result, err := i.CallMain(toy.Program)
+				if err != nil {
+					t.Fatalf("%v\ntestCase = \n%s", err, test.String())
+				}
+
+				if result != test.expected {
+					t.Errorf("result = %v\ntestCase = \n%s", result, test.String())
+				}
+
+				printed := string(buf.Bytes())
+				if printed != test.printed {
+					t.Errorf("printed = %s\ntestCase = \n%s", printed, test.String())
+				}
+			}
+		})
+	}
+}
+
+// operator chains must be left-associative, so each expectation is the same
+// expression evaluated by Go.
+var chainTests = []struct {
+	expression string
+	expected   value.Value
+}{
+	{"1+2+3", value.Int(1 + 2 + 3)},
+	{"10-2-3", value.Int(10 - 2 - 3)},
+	{"100-10-20-30-40", value.Int(100 - 10 - 20 - 30 - 40)},
+	{"2*3*4", value.Int(2 * 3 * 4)},
+	{"100/5/2", value.Int(100 / 5 / 2)},
+	{"1000/10/5/2", value.Int(1000 / 10 / 5 / 2)},
+	{"7-3+2-1+10", value.Int(7 - 3 + 2 - 1 + 10)},
+	{"20/2*3", value.Int(20 / 2 * 3)},
+	{"2*9/4*3/2", value.Int(2 * 9 / 4 * 3 / 2)},
+	{"1+2*3-4/2+5*6-7", value.Int(1 + 2*3 - 4/2 + 5*6 - 7)},
+	{"10-2*3-4*5/2+8/4/2", value.Int(10 - 2*3 - 4*5/2 + 8/4/2)},
+	{"(10-2)-(3-1)-1", value.Int((10 - 2) - (3 - 1) - 1)},
+	{"10-(2-(3-1))", value.Int(10 - (2 - (3 - 1)))},
+	{"1-2-3-4-5-6-7-8-9-10", value.Int(1 - 2 - 3 - 4 - 5 - 6 - 7 - 8 - 9 - 10)},
+	{"2*3+4*5-6*7+8*9-10", value.Int(2*3 + 4*5 - 6*7 + 8*9 - 10)},
+	{"-5+3", value.Int(-5 + 3)},
+	{"2*-3", value.Int(2 * -3)},
+	{"-(2+3)*2", value.Int(-(2 + 3) * 2)},
+	{"--4", value.Int(4)},
+	{"1-2--3", value.Int(1 - 2 - -3)},
+	{"17%5", value.Int(17 % 5)},
+	{"-17%5", value.Int(-17 % 5)},
+	{"100%7%3", value.Int(100 % 7 % 3)},
+	{"2+10%4*3", value.Int(2 + 10%4*3)},
+	{"2**10", value.Int(1024)},
+	{"2**3**2", value.Int(512)},
+	{"-2**2", value.Int(-4)},
+	{"(-2)**2", value.Int(4)},
+	{"3*2**2", value.Int(12)},
+	{"-9223372036854775808", value.Int(-9223372036854775808)},
+	{"1.5+2.25", value.Float(1.5 + 2.25)},
+	{"1+0.5", value.Float(1.5)},
+	{"0.5*4", value.Float(2)},
+	{"7/2", value.Int(3)},
+	{"7/2.0", value.Float(3.5)},
+	{"-1.5*2", value.Float(-3)},
+	{"-(0.5)", value.Float(-0.5)},
+	{"7.5%2", value.Float(1.5)},
+	{"4**0.5", value.Float(2)},
+	{"2.0**-1", value.Float(0.5)},
+	{"1.5e2+1", value.Float(151)},
+	{"2e-1", value.Float(0.2)},
+	{"1==1.0", value.Bool(true)},
+	{"1<1.5", value.Bool(true)},
+	{"0.1+0.2!=0.3", value.Bool(true)},
+	{"int(-2.7)", value.Int(-2)},
+	{"int(\"12\")+int(true)", value.Int(13)},
+	{"float(3)/2", value.Float(1.5)},
+	{"float(\"2.5\")", value.Float(2.5)},
+	{"1<2==true", value.Bool((1 < 2) == true)},
+	{"3>2!=false", value.Bool((3 > 2) != false)},
+	{"1==1==true", value.Bool((1 == 1) == true)},
+	{"2==2!=false", value.Bool((2 == 2) != false)},
+	{"1+2<2+3==true", value.Bool((1+2 < 2+3) == true)},
+	{"5-1-1>=3!=false", value.Bool((5-1-1 >= 3) != false)},
+	{"true||false&&false", value.Bool(true)},
+	{"false&&true||true", value.Bool(true)},
+	{"1<2&&2<3||3<1", value.Bool(1 < 2 && 2 < 3 || 3 < 1)},
+	{"!false&&!(1>2)", value.Bool(true)},
+	{"!!true==true", value.Bool(true)},
+	{"1 + 2 * 3", value.Int(7)},
+	{"2 * 3 - -1", value.Int(7)},
+	{"-2 ** 2", value.Int(-4)},
+	{"2 ** 3 ** 2", value.Int(512)},
+	{"1 < 2 && !false || 1 / 0 == 0", value.Bool(true)},
+	{"max(1, 2) % 2", value.Int(0)},
+	{"7/2 // halved", value.Int(3)},
+	{"8/*not divided*/", value.Int(8)},
+	{"x =3\n\tx- 1", value.Int(2)},
+	{"x= 3\n\tx+ -1", value.Int(2)},
+	{"x=3\n\tx -1", value.Int(2)},
+	{"2 **3 >8 ||false", value.Bool(false)},
+	{"max(1 , 2)", value.Int(2)},
+	{"xs=[1]\n\txs[0] =2\n\txs[0]", value.Int(2)},
+	{"(define(a ,b =2) { a+b })(1)", value.Int(3)},
+}
+
+func TestOperatorChains(t *testing.T) {
+	for _, e := range engines {
+		t.Run(e.name, func(t *testing.T) {
+			for _, test := range chainTests {
+				toy := &Toy{Buffer: fmt.Sprintf("define main() {\n\t%s\n}", test.expression)}
+				if err := setUp(toy); err != nil {
+					t.Fatalf("%v\nexpression = %s", err, test.expression)
+				}
+
+				result, err := e.new(io.Discard).CallMain(toy.Program)
+				if err != nil {
+					t.Fatalf("%v\nexpression = %s", err, test.expression)
+				}
+				if result != test.expected {
+					t.Errorf("%s = %v; want %v", test.expression, result, test.expected)
+				}
+			}
+		})
+	}
+}
+
+// TestFloatRoundTrip tests that a printed float reads back as the same float.
+func TestFloatRoundTrip(t *testing.T) {
+	floats := []string{"0.1", "2.0", "-3.25", "1e+21", "1.5e-07", "123456789.125", "1/3.0", "2**0.5", "-0.0"}
+
+	for _, e := range engines {
+		t.Run(e.name, func(t *testing.T) {
+			for _, f := range floats {
+				var printed bytes.Buffer
+				toy := &Toy{Buffer: fmt.Sprintf("define main() {\n\tprintln(%s)\n}", f)}
+				if err := setUp(toy); err != nil {
+					t.Fatalf("%v\nexpression = %s", err, f)
+				}
+				want, err := e.new(&printed).CallMain(toy.Program)
+				if err != nil {
+					t.Fatalf("%v\nexpression = %s", err, f)
+				}
+
+				toy = &Toy{Buffer: fmt.Sprintf("define main() {\n\t%s\n}", printed.String())}
+				if err := setUp(toy); err != nil {
+					t.Fatalf("%v\nprinted = %s", err, printed.String())
+				}
+				got, err := e.new(io.Discard).CallMain(toy.Program)
+				if err != nil {
+					t.Fatalf("%v\nprinted = %s", err, printed.String())
+				}
+				if got != want {
+					t.Errorf("%s printed %s, which reads back as %v", f, printed.String(), got)
+				}
+			}
+		})
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	tests := []struct {
+		expression string
+		// expected is the result or the error on each of wrapping, checked and
+		// big arithmetic.
+		expected [3]string
+	}{
+		{"9223372036854775807+1", [3]string{"-9223372036854775808", "2:2: integer overflow in Add", "9223372036854775808"}},
+		{"-9223372036854775808-1", [3]string{"9223372036854775807", "2:2: integer overflow in Subtract", "-9223372036854775809"}},
+		{"-(-9223372036854775808)", [3]string{"-9223372036854775808", "2:2: integer overflow in Negate", "9223372036854775808"}},
+		{"-9223372036854775808/-1", [3]string{"-9223372036854775808", "2:2: integer overflow in Divide", "9223372036854775808"}},
+		{"2**64", [3]string{"0", "2:2: integer overflow in Power", "18446744073709551616"}},
+		{"(-2)**63", [3]string{"-9223372036854775808", "-9223372036854775808", "-9223372036854775808"}},
+		{"fact(30)", [3]string{"-8764578968847253504", "7:10: integer overflow in Multiply", "265252859812191058636308480000000"}},
+		{"fact(25)/fact(23)", [3]string{"0", "7:10: integer overflow in Multiply", "600"}},
+		{"fact(25)-fact(25)+1", [3]string{"1", "7:10: integer overflow in Multiply", "1"}},
+		{"fact(21)>fact(20)", [3]string{"false", "7:10: integer overflow in Multiply", "true"}},
+		{"fact(21)==fact(21)*1", [3]string{"true", "7:10: integer overflow in Multiply", "true"}},
+		{"2**70%1000", [3]string{"0", "2:2: integer overflow in Power", "424"}},
+		{"2**70+0.5", [3]string{"0.5", "2:2: integer overflow in Power", "1.1805916207174113e+21"}},
+		{"\"n=\"+2**64", [3]string{"n=0", "2:7: integer overflow in Power", "n=18446744073709551616"}},
+		{"99999999999999999999+1", [3]string{"2:2: integer literal out of range", "2:2: integer literal out of range", "100000000000000000000"}},
+		{"-9223372036854775809", [3]string{"2:2: integer literal out of range", "2:2: integer literal out of range", "-9223372036854775809"}},
+		{"-9223372036854775808", [3]string{"-9223372036854775808", "-9223372036854775808", "-9223372036854775808"}},
+		{"#{2**70: 1}", [3]string{"#{0: 1}", "2:4: integer overflow in Power", "2:4: integer too large for map key"}},
+		{"[1][2**70]", [3]string{"1", "2:6: integer overflow in Power", "2:2: integer too large for list index"}},
+		{"abs(-9223372036854775808)", [3]string{"-9223372036854775808", "2:2: abs: -9223372036854775808 is out of range", "9223372036854775808"}},
+		{"int(2**70)", [3]string{"0", "2:6: integer overflow in Power", "1180591620717411303424"}},
+		{"float(99999999999999999999)", [3]string{"2:8: integer literal out of range", "2:8: integer literal out of range", "1e+20"}},
+		{"substring(\"abc\", 2**70)", [3]string{"abc", "2:19: integer overflow in Power", "2:2: substring: integer too large for argument"}},
+	}
+	arithmetics := []interpreter.Arithmetic{interpreter.WrappingArithmetic, interpreter.CheckedArithmetic, interpreter.BigArithmetic}
+
+	for _, test := range tests {
+		toy := &Toy{Buffer: fmt.Sprintf(`define main() {
+	%s
+}
+define fact(n) {
+	result=1
+	while n>1 {
+		result=result*n
+		n=n-1
+	}
+	result
+}`, test.expression)}
+		if err := setUp(toy); err != nil {
+			t.Fatalf("%v\nexpression = %s", err, test.expression)
+		}
+
+		for j, a := range arithmetics {
+			i := interpreter.NewInterpreterWithWriter(io.Discard)
+			i.Arithmetic = a
+			m := vm.NewVMWithWriter(io.Discard)
+			m.Arithmetic = a
+
+			for _, e := range []engine{&i, &m} {
+				var got string
+				if result, err := e.CallMain(toy.Program); err != nil {
+					got = err.Error()
+				} else {
+					got = result.String()
+				}
+				if got != test.expected[j] {
+					t.Errorf("%s on %s arithmetic with %T = %s; want %s", test.expression, a.Name(), e, got, test.expected[j])
+				}
+			}
+		}
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	for _, e := range engines {
+		t.Run(e.name, func(t *testing.T) {
+			for _, test := range errorTests {
+				toy := &Toy{Buffer: test.expression}
+				if err := setUp(toy); err != nil {
+					t.Fatalf("%v\nexpression = \n%s", err, test.expression)
+				}
+
+				_, err := e.new(io.Discard).CallMain(toy.Program)
+
+				var rerr *interpreter.RuntimeError
+				if !errors.As(err, &rerr) {
+					t.Errorf("err = %v; want RuntimeError\nexpression = \n%s", err, test.expression)
+					continue
+				}
+				if rerr.Error() != test.expected {
+					t.Errorf("err = %q; want %q", rerr.Error(), test.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestPositions(t *testing.T) {
+	toy := &Toy{
+		Buffer: `global g=1
+define main() {
+	n=g+2
+	foo(n)
+}`,
+		Filename: "prog.toy",
+	}
+	if err := setUp(toy); err != nil {
+		t.Fatal(err)
+	}
+
+	globalVarDef := toy.Program.Definitions[0].(ast.GlobalVariableDefinition)
+	mainFunc := toy.Program.Definitions[1].(ast.FunctionDefinition)
+	assignment := mainFunc.Body.Expressions[0].(ast.Assignment)
+	binary := assignment.Expression.(ast.BinaryExpression)
+	funcCall := mainFunc.Body.Expressions[1].(ast.FunctionCall)
+
+	tests := []struct {
+		name      string
+		node      ast.Node
+		start     string
+		endColumn int
+	}{
+		{"globalVariableDefinition", globalVarDef, "prog.toy:1:1", 11},
+		{"functionDefinition", mainFunc, "prog.toy:2:1", 2},
+		{"assignment", assignment, "prog.toy:3:2", 7},
+		{"binary", binary, "prog.toy:3:4", 7},
+		{"rhs", binary.Rhs, "prog.toy:3:6", 7},
+		{"funcCall", funcCall, "prog.toy:4:2", 8},
+		{"arg", funcCall.Args[0], "prog.toy:4:6", 7},
+	}
+
+	for _, test := range tests {
+		if start := test.node.Pos().String(); start != test.start {
+			t.Errorf("%s: start = %s; want %s", test.name, start, test.start)
+		}
+		if end := test.node.Range().End.Column; end != test.endColumn {
+			t.Errorf("%s: end column = %d; want %d", test.name, end, test.endColumn)
+		}
+	}
+}
+
+func TestRuntimeErrorPosition(t *testing.T) {
+	toy := &Toy{
+		Buffer: `define main() {
+	n=1
+	n+foo(n)
+}`,
+		Filename: "prog.toy",
+	}
+	if err := setUp(toy); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range engines {
+		t.Run(e.name, func(t *testing.T) {
+			_, err := e.new(io.Discard).CallMain(toy.Program)
+
+			var rerr *interpreter.RuntimeError
+			if !errors.As(err, &rerr) {
+				t.Fatalf("err = %v; want RuntimeError", err)
+			}
+			if msg, want := rerr.Error(), "prog.toy:3:4: function foo is not found"; msg != want {
+				t.Errorf("err = %q; want %q", msg, want)
+			}
+		})
+	}
+}
+
+func TestRuntimeErrorTrace(t *testing.T) {
+	toy := &Toy{
+		Buffer: `define div(a,b) {
+	a/b
+}
+define half(n) {
+	div(n,n-n)
+}
+define main() {
+	half(4)
+}`,
+		Filename: "prog.toy",
+	}
+	if err := setUp(toy); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"div called at prog.toy:5:2",
+		"half called at prog.toy:8:2",
+		"main",
+	}
+
+	for _, e := range engines {
+		t.Run(e.name, func(t *testing.T) {
+			_, err := e.new(io.Discard).CallMain(toy.Program)
+
+			var zerr *interpreter.DivisionByZeroError
+			if !errors.As(err, &zerr) {
+				t.Fatalf("err = %v; want DivisionByZeroError", err)
+			}
+
+			var rerr *interpreter.RuntimeError
+			if !errors.As(err, &rerr) {
+				t.Fatalf("err = %v; want RuntimeError", err)
+			}
+			if msg, want := rerr.Error(), "prog.toy:2:2: division by zero"; msg != want {
+				t.Errorf("err = %q; want %q", msg, want)
+			}
+
+			var trace []string
+			for _, frame := range rerr.Trace {
+				trace = append(trace, frame.String())
+			}
+			if fmt.Sprint(trace) != fmt.Sprint(expected) {
+				t.Errorf("trace = %q; want %q", trace, expected)
+			}
+		})
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	type host interface {
+		engine
+		RegisterFunc(name string, minArity, maxArity int, fn func(args []value.Value) (value.Value, error))
+	}
+	register := func(h host) {
+		h.RegisterFunc("sum", 0, -1, func(args []value.Value) (value.Value, error) {
+			var sum value.Int
+			for _, arg := range args {
+				n, ok := arg.(value.Int)
+				if !ok {
+					return nil, fmt.Errorf("cannot sum %s", arg.Type())
+				}
+				sum += n
+			}
+			return sum, nil
+		})
+		h.RegisterFunc("scale", 1, 1, func(args []value.Value) (value.Value, error) {
+			return nil, errors.New("hidden by scale of the program")
+		})
+	}
+
+	tests := []struct {
+		expression string
+		expected   string
+		trace      []string
+	}{
+		{
+			`define scale(x) {
+	x*10
+}
+define main() {
+	f=sum
+	[sum(), sum(1,2,3), f(4,5), scale(2)]
+}`,
+			"[0, 6, 9, 20]",
+			nil,
+		},
+		{
+			`define total(xs) {
+	sum(1,xs)
+}
+define main() {
+	total("a")
+}`,
+			"prog.toy:2:2: cannot sum string",
+			[]string{"sum called at prog.toy:2:2", "total called at prog.toy:5:2", "main"},
+		},
+		{
+			`define main() {
+	scale(1,2)
+}`,
+			"prog.toy:2:2: function scale takes 1 arguments but 2 were given",
+			nil,
+		},
+	}
+
+	for _, e := range engines {
+		t.Run(e.name, func(t *testing.T) {
+			for _, test := range tests {
+				toy := &Toy{Buffer: test.expression, Filename: "prog.toy"}
+				if err := setUp(toy); err != nil {
+					t.Fatal(err)
+				}
+
+				h := e.new(io.Discard).(host)
+				register(h)
+				result, err := h.CallMain(toy.Program)
+
+				var got string
+				var trace []string
+				if err != nil {
+					got = err.Error()
+					var rerr *interpreter.RuntimeError
+					if errors.As(err, &rerr) {
+						for _, frame := range rerr.Trace {
+							trace = append(trace, frame.String())
+						}
+					}
+				} else {
+					got = result.String()
+				}
+				if got != test.expected || fmt.Sprint(trace) != fmt.Sprint(test.trace) {
+					t.Errorf("got %s with trace %q; want %s with trace %q\nexpression = \n%s", got, trace, test.expected, test.trace, test.expression)
+				}
+			}
+		})
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		expression string
+		limits     interpreter.Limits
+		expected   string
+	}{
+		{
+			// NOTE: 3 iterations and 4 calls, one of them of a builtin
+			`define inc(n) {
+	n+1
+}
+define main() {
+	n=0
+	while n<3 {
+		n=inc(n)
+	}
+	len([n])
+}`,
+			interpreter.Limits{MaxSteps: 7},
+			"1",
+		},
+		{
+			`define inc(n) {
+	n+1
+}
+define main() {
+	n=0
+	while n<3 {
+		n=inc(n)
+	}
+	len([n])
+}`,
+			interpreter.Limits{MaxSteps: 6},
+			"prog.toy:9:2: step limit of 6 exceeded",
+		},
+		{
+			`define main() {
+	n=0
+	while 1 {
+		n=n+1
+		continue
+	}
+}`,
+			interpreter.Limits{MaxSteps: 1000},
+			"prog.toy:3:2: step limit of 1000 exceeded",
+		},
+		{
+			`define down(n) {
+	if n>0 {
+		down(n-1)
+	}
+}
+define main() {
+	down(3)
+}`,
+			interpreter.Limits{MaxCallDepth: 5},
+			"1",
+		},
+		{
+			`define down(n) {
+	if n>0 {
+		down(n-1)
+	}
+}
+define main() {
+	down(4)
+}`,
+			interpreter.Limits{MaxCallDepth: 5},
+			"prog.toy:3:3: call depth limit of 5 exceeded",
+		},
+		{
+			`define f() {
+	1
+}
+define main() {
+	f()
+}`,
+			interpreter.Limits{MaxCallDepth: 1},
+			"prog.toy:5:2: call depth limit of 1 exceeded",
+		},
+		{
+			`define main() {
+	while 1 { 1 }
+}`,
+			interpreter.Limits{Deadline: time.Now()},
+			"prog.toy:2:2: context deadline exceeded",
+		},
+	}
+
+	for _, test := range tests {
+		toy := &Toy{Buffer: test.expression, Filename: "prog.toy"}
+		if err := setUp(toy); err != nil {
+			t.Fatal(err)
+		}
+
+		i := interpreter.NewInterpreterWithWriter(io.Discard)
+		i.Limits = test.limits
+		m := vm.NewVMWithWriter(io.Discard)
+		m.Limits = test.limits
+
+		for _, e := range []engine{&i, &m} {
+			var got string
+			if result, err := e.CallMain(toy.Program); err != nil {
+				got = err.Error()
+			} else {
+				got = result.String()
+			}
+			if got != test.expected {
+				t.Errorf("%T with %+v = %s; want %s\nexpression = \n%s", e, test.limits, got, test.expected, test.expression)
+			}
+		}
+	}
+}
+
+func TestCallMainContext(t *testing.T) {
+	toy := &Toy{Buffer: `define spin() {
+	while 1 { 1 }
+}
+define main() {
+	spin()
+}`}
+	if err := setUp(toy); err != nil {
+		t.Fatal(err)
+	}
+
+	i := interpreter.NewInterpreterWithWriter(io.Discard)
+	m := vm.NewVMWithWriter(io.Discard)
+	for _, e := range []interface {
+		CallMainContext(ctx context.Context, program ast.Program) (value.Value, error)
+	}{&i, &m} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := e.CallMainContext(ctx, toy.Program)
+		cancel()
+		var rerr *interpreter.RuntimeError
+		if !errors.Is(err, context.DeadlineExceeded) || !errors.As(err, &rerr) {
+			t.Fatalf("%T: err = %v; want RuntimeError of context.DeadlineExceeded", e, err)
+		}
+		if trace := fmt.Sprint(rerr.Trace); trace != "[spin called at 5:2 main]" {
+			t.Errorf("%T: trace = %s; want [spin called at 5:2 main]", e, trace)
+		}
+
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		if _, err := e.CallMainContext(ctx, toy.Program); !errors.Is(err, context.Canceled) {
+			t.Errorf("%T: err = %v; want context.Canceled", e, err)
+		}
+	}
+}
+
+func TestStandardLibrary(t *testing.T) {
+	toy := &Toy{Buffer: `define main() {
+	total=0
+	line=readln()
+	while line!=false {
+		if line=="quit" {
+			exit(abs(total))
+		}
+		total=total+int(split(line,"=")[1])
+		line=readln()
+	}
+	format("total {}",total)
+}`, Filename: "prog.toy"}
+	if err := setUp(toy); err != nil {
+		t.Fatal(err)
+	}
+
+	i := interpreter.NewInterpreterWithWriter(io.Discard)
+	m := vm.NewVMWithWriter(io.Discard)
+	for _, e := range []interface {
+		engine
+		SetReader(r io.Reader)
+	}{&i, &m} {
+		e.SetReader(strings.NewReader("a=1\nb=2\n"))
+		if result, err := e.CallMain(toy.Program); err != nil || result.String() != "total 3" {
+			t.Errorf("%T: result = %v, err = %v; want total 3", e, result, err)
+		}
+
+		e.SetReader(strings.NewReader("a=-4\nquit\nb=2\n"))
+		_, err := e.CallMain(toy.Program)
+		var exitErr *builtins.ExitError
+		if !errors.As(err, &exitErr) || exitErr.Code != 4 || err.Error() != "prog.toy:6:4: exit status 4" {
+			t.Errorf("%T: err = %v; want prog.toy:6:4: exit status 4", e, err)
+		}
+	}
+}
+
+func TestParameterErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{
+			"define f(a=1,b) {\n\tb\n}",
+			"1:14: argument b without default value follows one with default value",
+		},
+		{
+			"define f(rest...,a) {\n\ta\n}",
+			"1:18: variadic argument rest must be the last one",
+		},
+		{
+			"define main() {\n\tf = define(a..., b) { a }\n}",
+			"2:19: variadic argument a must be the last one",
+		},
+		{
+			"define main() {\n\tx = 1 + (define(a = 1, b) { b })(2)\n}",
+			"2:25: argument b without default value follows one with default value",
+		},
+		{
+			"define main() {\n\twhile 1 {\n\t\tprintln(define(a..., b) { a })\n\t}\n}",
+			"3:24: variadic argument a must be the last one",
+		},
+		{
+			"define main() {\n\tif 1 {\n\t\treturn [define(a = 1, b) { b }]\n\t}\n}",
+			"3:25: argument b without default value follows one with default value",
+		},
+		{
+			"define main() {\n\tf(1)=2\n}",
+			"2:2: cannot assign to f(1)",
+		},
+		{
+			"struct Point { x, y, x }",
+			"1:22: field x is defined twice",
+		},
+		{
+			"define main() {\n\tPoint{x: 1, x: 2}\n}",
+			"2:14: field x is given twice",
+		},
+	}
+
+	for _, test := range tests {
+		toy := &Toy{Buffer: test.expression}
+		err := setUp(toy)
+		if err == nil {
+			t.Errorf("setUp succeeded; want error %q\nexpression = \n%s", test.expected, test.expression)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("err = %q; want %q", err, test.expected)
+		}
+	}
+}
+
+func TestReplInput(t *testing.T) {
+	toy := &Toy{Buffer: `global g=2
+define sq(x) {
+	x*x
+}
+n=sq(g)
+n+1
+`}
+	if err := toy.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := toy.ParseReplInput(); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := toy.ConvertReplInput()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nodes) != 4 {
+		t.Fatalf("len(nodes) = %d; want 4", len(nodes))
+	}
+	if _, ok := nodes[0].(ast.GlobalVariableDefinition); !ok {
+		t.Errorf("nodes[0] = %T; want ast.GlobalVariableDefinition", nodes[0])
+	}
+	if _, ok := nodes[1].(ast.FunctionDefinition); !ok {
+		t.Errorf("nodes[1] = %T; want ast.FunctionDefinition", nodes[1])
+	}
+	if _, ok := nodes[2].(ast.Assignment); !ok {
+		t.Errorf("nodes[2] = %T; want ast.Assignment", nodes[2])
+	}
+	if _, ok := nodes[3].(ast.BinaryExpression); !ok {
+		t.Errorf("nodes[3] = %T; want ast.BinaryExpression", nodes[3])
+	}
+}
+
+var benchmarks = []struct {
+	name       string
+	expression string
+}{
+	{
+		"fib",
+		`define fib(n) {
+			if n<2 {
+				n
+			} else {
+				fib(n-1)+fib(n-2)
+			}
+		}
+		define main() {
+			fib(20)
+		}`,
+	},
+	{
+		"loop",
+		`define main() {
+			i=0
+			sum=0
+			while i<100000 {
+				sum=sum+i
+				i=i+1
+			}
+			sum
+		}`,
+	},
+}
+
+func BenchmarkEngines(b *testing.B) {
+	for _, bench := range benchmarks {
+		toy := &Toy{Buffer: bench.expression}
+		if err := setUp(toy); err != nil {
+			b.Fatal(err)
+		}
+
+		for _, e := range engines {
+			b.Run(bench.name+"/"+e.name, func(b *testing.B) {
+				for n := 0; n < b.N; n++ {
+					if _, err := e.new(io.Discard).CallMain(toy.Program); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func setUp(t *Toy) error {
+	if err := t.Init(); err != nil {
+		return err
+	}
+	if err := t.Parse(); err != nil {
+		return err
+	}
+	return t.ConvertAst()
+}
+
+// every program which runs must have no type errors.
+func TestTypecheck(t *testing.T) {
+	for _, test := range tests {
+		toy := &Toy{Buffer: test.expression}
+		if err := setUp(toy); err != nil {
+			t.Fatalf("%v\ntestCase = \n%s", err, test.String())
+		}
+
+		for _, err := range typecheck.Check(toy.Program, interpreter.NewBuiltins(nil)) {
+			t.Errorf("%v\ntestCase = \n%s", err, test.String())
+		}
+	}
+}
+
+// TestFormatRoundTrip tests that every program in this file reads back from
+// its formatted source as the same program, and formats the same again.
+func TestFormatRoundTrip(t *testing.T) {
+	var programs []string
+	for _, test := range tests {
+		programs = append(programs, test.expression)
+	}
+	for _, test := range errorTests {
+		programs = append(programs, test.expression)
+	}
+	for _, test := range chainTests {
+		programs = append(programs, fmt.Sprintf("define main() {\n\t%s\n}", test.expression))
+	}
+	for _, bench := range benchmarks {
+		programs = append(programs, bench.expression)
+	}
+
+	for _, program := range programs {
+		toy := &Toy{Buffer: program}
+		if err := setUp(toy); err != nil {
+			// NOTE: some errorTests are syntax errors
+			continue
+		}
+		formatted := printer.Format(toy.Program)
+
+		again := &Toy{Buffer: string(formatted)}
+		if err := setUp(again); err != nil {
+			t.Errorf("%v\nformatted = \n%s\nprogram = \n%s", err, formatted, program)
+			continue
+		}
+		if !sameAST(reflect.ValueOf(toy.Program.Definitions), reflect.ValueOf(again.Program.Definitions)) {
+			t.Errorf("formatted program reads back differently\nformatted = \n%s\nprogram = \n%s", formatted, program)
+		}
+		if twice := printer.Format(again.Program); !bytes.Equal(twice, formatted) {
+			t.Errorf("formatted twice = \n%s\nformatted = \n%s", twice, formatted)
+		}
+	}
+}
+
+var (
+	spanType   = reflect.TypeOf(ast.Span{})
+	bigIntType = reflect.TypeOf(&big.Int{})
+)
+
+// sameAST reports whether a and b are deeply equal but for their spans.
+func sameAST(a, b reflect.Value) bool {
+	if a.IsValid() != b.IsValid() {
+		return false
+	}
+	if !a.IsValid() {
+		return true
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		if a.Type() == bigIntType {
+			return a.Interface().(*big.Int).Cmp(b.Interface().(*big.Int)) == 0
+		}
+		return sameAST(a.Elem(), b.Elem())
+
+	case reflect.Struct:
+		for j := 0; j < a.NumField(); j++ {
+			if a.Type().Field(j).Type == spanType {
+				continue
+			}
+			if !sameAST(a.Field(j), b.Field(j)) {
+				return false
+			}
+		}
+		return true
+
+	case reflect.Slice:
+		if a.IsNil() != b.IsNil() || a.Len() != b.Len() {
+			return false
+		}
+		for j := 0; j < a.Len(); j++ {
+			if !sameAST(a.Index(j), b.Index(j)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+func TestComments(t *testing.T) {
+	source := `// main runs the program.
+define main() { // in the body
+	// leading of x
+	x = [1, /* inside */ 2] // trailing of x
+	if x {
+		// inner of then
+	}
+	x
+	// after x
+}
+/* the end */`
+
+	toy := &Toy{Buffer: source}
+	if err := setUp(toy); err != nil {
+		t.Fatal(err)
+	}
+
+	main := toy.Program.Definitions[0].(ast.FunctionDefinition)
+	if got := main.Span.End; got.Line != 10 || got.Column != 2 {
+		t.Errorf("main ends at %s; want 10:2 before the comment after it", got)
+	}
+	body := main.Body.Expressions
+	ifExp := body[1].(ast.IfExpression)
+
+	texts := func(comments []ast.Comment) string {
+		var s []string
+		for _, c := range comments {
+			s = append(s, c.Text)
+		}
+		return strings.Join(s, "|")
+	}
+	group := func(span ast.Span) ast.CommentGroup {
+		if g := toy.Program.Comments[span]; g != nil {
+			return *g
+		}
+		return ast.CommentGroup{}
+	}
+
+	tests := []struct {
+		name     string
+		got      []ast.Comment
+		expected string
+	}{
+		{"main leading", group(main.Span).Leading, "// main runs the program."},
+		{"main trailing", group(main.Span).Trailing, "/* the end */"},
+		{"x leading", group(body[0].Range()).Leading, "// in the body|// leading of x"},
+		{"x trailing", group(body[0].Range()).Trailing, "/* inside */|// trailing of x"},
+		{"then inner", group(ifExp.ThenClause.Span).Inner, "// inner of then"},
+		{"last trailing", group(body[2].Range()).Trailing, "// after x"},
+	}
+	for _, test := range tests {
+		if got := texts(test.got); got != test.expected {
+			t.Errorf("%s = %q; want %q", test.name, got, test.expected)
+		}
+	}
 }
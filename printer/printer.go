@@ -0,0 +1,594 @@
+// Package printer renders an ast.Program as canonical toy source: one
+// expression per line, at most one blank line between them where the source
+// had any, blocks indented with a tab, a space on both sides of
+// binary operators and =, and only the parentheses the precedence of the
+// operators requires. Comments are kept with the nodes they are attached to,
+// and those inside an expression before the part of it they were before.
+// The parser reads the result back into the same program but for the
+// positions of its nodes.
+package printer
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+)
+
+// Fprint writes program to w as canonical toy source.
+func Fprint(w io.Writer, program ast.Program) error {
+	_, err := w.Write(Format(program))
+	return err
+}
+
+// Format returns program as canonical toy source.
+func Format(program ast.Program) []byte {
+	p := printer{comments: program.Comments}
+	p.program(program)
+	return p.buf.Bytes()
+}
+
+// Expression returns exp as canonical toy source, as it is printed at the
+// start of a line.
+func Expression(exp ast.Expression) string {
+	var p printer
+	p.expression(exp, precStatement)
+	return p.buf.String()
+}
+
+// The precedence of expressions which are not operators. An expression is
+// parenthesized where one of higher precedence is expected.
+const (
+	// if, while, blocks, return, break, continue and assignments, which
+	// cannot be parenthesized and are only read where any expression is.
+	precStatement = 0
+	// what conditions, default values, map keys and parentheses read
+	precDisjunctive = 1
+	precUnary       = 6
+	// println, the base of a power
+	precPrimary = 8
+	// literals, names and postfix expressions, which a postfix is applied to
+	precPostfix = 9
+)
+
+type printer struct {
+	buf      bytes.Buffer
+	indent   int
+	comments ast.CommentMap
+	// pending are the comments attached to the node being written which are
+	// not written yet, those inside of it first.
+	pending []ast.Comment
+}
+
+func (p *printer) write(s string) {
+	p.buf.WriteString(s)
+}
+
+func (p *printer) newline() {
+	p.buf.WriteByte('\n')
+	for j := 0; j < p.indent; j++ {
+		p.buf.WriteByte('\t')
+	}
+}
+
+func (p *printer) program(program ast.Program) {
+	for j, def := range program.Definitions {
+		if j > 0 {
+			p.write("\n")
+			if !sameKind(program.Definitions[j-1], def) || p.separated(program.Definitions[j-1].Range(), def.Range()) {
+				p.write("\n")
+			}
+		}
+		p.leading(def.Range())
+		p.pending = p.trailingComments(def.Range())
+		p.topLevel(def)
+		p.trailing(def.Range())
+	}
+	if len(program.Definitions) == 0 {
+		for j, comment := range p.inner(ast.Span{}) {
+			if j > 0 {
+				p.write("\n")
+			}
+			p.write(comment.Text)
+		}
+	}
+	if p.buf.Len() > 0 {
+		p.write("\n")
+	}
+}
+
+// leading writes the comments before the node with the given span, each on a
+// line of its own but for a /* */ comment on the line the node starts on. A
+// blank line after one of them is kept.
+func (p *printer) leading(span ast.Span) {
+	g := p.comments[span]
+	if g == nil {
+		return
+	}
+	for j, comment := range g.Leading {
+		p.write(comment.Text)
+		if strings.HasPrefix(comment.Text, "/*") && comment.End.Line == span.Start.Line {
+			p.write(" ")
+			continue
+		}
+
+		next := span.Start.Line
+		if j+1 < len(g.Leading) {
+			next = g.Leading[j+1].Start.Line
+		}
+		if next > comment.End.Line+1 {
+			p.write("\n")
+		}
+		p.newline()
+	}
+}
+
+// trailingComments returns the comments inside and after the node with the
+// given span.
+func (p *printer) trailingComments(span ast.Span) []ast.Comment {
+	if g := p.comments[span]; g != nil {
+		return g.Trailing
+	}
+	return nil
+}
+
+// inside writes the pending comments which end before start, where the node
+// they were before in the source starts. A // comment ends the line, and the
+// node goes on the next one, so it and those after it are left pending unless
+// lines may break before the node.
+func (p *printer) inside(start ast.Position, lines bool) {
+	for len(p.pending) > 0 && start.IsValid() && p.pending[0].End.Offset <= start.Offset {
+		if strings.HasPrefix(p.pending[0].Text, "//") && !lines {
+			return
+		}
+		p.write(p.pending[0].Text)
+		if strings.HasPrefix(p.pending[0].Text, "//") {
+			p.indent++
+			p.newline()
+			p.indent--
+		} else {
+			p.write(" ")
+		}
+		p.pending = p.pending[1:]
+	}
+}
+
+// trailing writes the pending comments of the node with the given span, on the
+// line it ends on if they start on a line of the node, and otherwise each on
+// a line of its own after a blank line if there was one.
+func (p *printer) trailing(span ast.Span) {
+	ended := false
+	last := span.End.Line
+	for _, comment := range p.pending {
+		// NOTE: a // comment runs to the end of the line, so nothing may follow
+		if comment.Start.Line > span.End.Line || ended {
+			if comment.Start.Line > last+1 {
+				p.write("\n")
+			}
+			p.newline()
+			p.write(comment.Text)
+		} else {
+			p.write(" " + comment.Text)
+		}
+		ended = strings.HasPrefix(comment.Text, "//")
+		last = comment.End.Line
+	}
+	p.pending = nil
+}
+
+// separated reports whether there was a blank line between the nodes with
+// the spans a and b and their comments in the source they were parsed from.
+func (p *printer) separated(a, b ast.Span) bool {
+	last := a.End.Line
+	if g := p.comments[a]; g != nil && len(g.Trailing) > 0 && g.Trailing[len(g.Trailing)-1].End.Line > last {
+		last = g.Trailing[len(g.Trailing)-1].End.Line
+	}
+	first := b.Start.Line
+	if g := p.comments[b]; g != nil && len(g.Leading) > 0 {
+		first = g.Leading[0].Start.Line
+	}
+	return a.End.IsValid() && first > last+1
+}
+
+// inner returns the comments of the empty block or program with the given
+// span.
+func (p *printer) inner(span ast.Span) []ast.Comment {
+	if g := p.comments[span]; g != nil {
+		return g.Inner
+	}
+	return nil
+}
+
+// sameKind reports whether a and b are imports, globals or structs of the same
+// kind, which are not separated by a blank line.
+func sameKind(a, b ast.TopLevel) bool {
+	switch a.(type) {
+	case ast.ImportDeclaration:
+		_, ok := b.(ast.ImportDeclaration)
+		return ok
+	case ast.GlobalVariableDefinition:
+		_, ok := b.(ast.GlobalVariableDefinition)
+		return ok
+	case ast.StructDefinition:
+		_, ok := b.(ast.StructDefinition)
+		return ok
+	}
+	return false
+}
+
+func (p *printer) topLevel(def ast.TopLevel) {
+	switch def := def.(type) {
+	case ast.ImportDeclaration:
+		p.write("import " + quote(def.Path))
+		if def.Name != strings.TrimSuffix(filepath.Base(def.Path), ".toy") {
+			p.write(" as " + def.Name)
+		}
+
+	case ast.FunctionDefinition:
+		p.write("define " + def.Name)
+		p.function(def)
+
+	case ast.StructDefinition:
+		p.write("struct " + def.Name + " {")
+		if len(def.Fields) > 0 {
+			p.write(" " + strings.Join(def.Fields, ", ") + " ")
+		}
+		p.write("}")
+
+	case ast.GlobalVariableDefinition:
+		p.write("global " + def.Name)
+		if def.Type != "" {
+			p.write(": " + def.Type)
+		}
+		p.write(" = ")
+		p.expression(def.Expression, precStatement)
+	}
+}
+
+// function writes the parameters, return type and body of f.
+func (p *printer) function(f ast.FunctionDefinition) {
+	p.write("(")
+	// NOTE: parameters have no spans, so the comments among them up to the last
+	// // comment before the first default value go before them all
+	first := f.Body.Span.Start
+	for j := range f.Args {
+		if d := f.Default(j); d != nil {
+			first = d.Range().Start
+			break
+		}
+	}
+	for j := len(p.pending) - 1; j >= 0; j-- {
+		if c := p.pending[j]; strings.HasPrefix(c.Text, "//") && c.End.Offset <= first.Offset {
+			p.inside(c.End, true)
+			break
+		}
+	}
+	for j, arg := range f.Args {
+		if j > 0 {
+			p.write(", ")
+		}
+		p.write(arg)
+		if f.Variadic && j == len(f.Args)-1 {
+			p.write("...")
+			continue
+		}
+		if t := f.ArgType(j); t != "" {
+			p.write(": " + t)
+		}
+		if d := f.Default(j); d != nil {
+			p.write(" = ")
+			p.expression(d, precDisjunctive)
+		}
+	}
+	p.write(")")
+	if f.ReturnType != "" {
+		p.write(": " + f.ReturnType)
+	}
+	p.write(" ")
+	p.block(f.Body)
+}
+
+func (p *printer) block(block ast.BlockExpression) {
+	p.inside(block.Span.Start, true)
+	if len(block.Expressions) == 0 {
+		p.emptyBlock(block)
+		return
+	}
+
+	outer := p.pending
+	p.write("{")
+	p.indent++
+	for j, exp := range block.Expressions {
+		if j > 0 && p.separated(block.Expressions[j-1].Range(), exp.Range()) {
+			p.write("\n")
+		}
+		p.newline()
+		p.leading(exp.Range())
+		p.pending = p.trailingComments(exp.Range())
+		p.expression(exp, precStatement)
+		p.trailing(exp.Range())
+	}
+	p.pending = outer
+	p.indent--
+	p.newline()
+	p.write("}")
+}
+
+func (p *printer) emptyBlock(block ast.BlockExpression) {
+	comments := p.inner(block.Span)
+	if len(comments) == 0 {
+		p.write("{}")
+		return
+	}
+
+	p.write("{")
+	p.indent++
+	for _, comment := range comments {
+		p.newline()
+		p.write(comment.Text)
+	}
+	p.indent--
+	p.newline()
+	p.write("}")
+}
+
+// precedence returns how tightly exp binds, which is that of its operator for
+// an operator expression.
+func precedence(exp ast.Expression) int {
+	switch exp := exp.(type) {
+	case ast.IfExpression, ast.WhileExpression, ast.BlockExpression,
+		ast.ReturnExpression, ast.BreakExpression, ast.ContinueExpression,
+		ast.Assignment, ast.IndexAssignment, ast.FieldAssignment:
+		return precStatement
+	case ast.BinaryExpression:
+		return exp.Operator.Precedence()
+	case ast.UnaryExpression:
+		return precUnary
+	case ast.IntegerLiteral:
+		// NOTE: the sign of a negative number is read as part of the literal
+		if exp.Value < 0 || exp.Big != nil && exp.Big.Sign() < 0 {
+			return precUnary
+		}
+	case ast.FloatLiteral:
+		if math.Signbit(exp.Value) {
+			return precUnary
+		}
+	case ast.Println:
+		return precPrimary
+	}
+	return precPostfix
+}
+
+// expression writes exp, in parentheses if it binds less tightly than prec.
+func (p *printer) expression(exp ast.Expression, prec int) {
+	p.inside(exp.Range().Start, false)
+	if precedence(exp) < prec && precedence(exp) > precStatement {
+		p.write("(")
+		p.expression(exp, precDisjunctive)
+		p.write(")")
+		return
+	}
+
+	switch exp := exp.(type) {
+	case ast.IntegerLiteral:
+		if exp.Big != nil {
+			p.write(exp.Big.String())
+			break
+		}
+		p.write(strconv.Itoa(exp.Value))
+
+	case ast.FloatLiteral:
+		p.write(formatFloat(exp.Value))
+
+	case ast.StringLiteral:
+		p.write(quote(exp.Value))
+
+	case ast.BooleanLiteral:
+		p.write(strconv.FormatBool(exp.Value))
+
+	case ast.Identifier:
+		p.write(exp.Name)
+
+	case ast.UnaryExpression:
+		p.write(exp.Operator.Symbol())
+		p.unaryOperand(exp)
+
+	case ast.BinaryExpression:
+		lhs, rhs := exp.Operator.Precedence(), exp.Operator.Precedence()+1
+		if exp.Operator == ast.Power {
+			lhs, rhs = precPrimary, precUnary
+		}
+		p.expression(exp.Lhs, lhs)
+		p.write(" " + exp.Operator.Symbol() + " ")
+		p.expression(exp.Rhs, rhs)
+
+	case ast.Assignment:
+		p.write(exp.Name + " = ")
+		p.expression(exp.Expression, precStatement)
+
+	case ast.IndexAssignment:
+		p.expression(exp.Collection, precPostfix)
+		p.write("[")
+		p.expression(exp.Index, precStatement)
+		p.write("] = ")
+		p.expression(exp.Value, precStatement)
+
+	case ast.FieldAssignment:
+		p.expression(exp.Object, precPostfix)
+		p.write("." + exp.Field + " = ")
+		p.expression(exp.Value, precStatement)
+
+	case ast.BlockExpression:
+		p.block(exp)
+
+	case ast.IfExpression:
+		p.write("if ")
+		p.expression(exp.Condition, precDisjunctive)
+		p.write(" ")
+		p.block(exp.ThenClause)
+		// NOTE: the parser reads an empty else clause as none, so one is only
+		// written for its comments
+		if len(exp.ElseClause.Expressions) > 0 || exp.ElseClause.Span != (ast.Span{}) && len(p.inner(exp.ElseClause.Span)) > 0 {
+			p.write(" else ")
+			p.block(exp.ElseClause)
+		}
+
+	case ast.WhileExpression:
+		p.write("while ")
+		p.expression(exp.Condition, precDisjunctive)
+		p.write(" ")
+		p.block(exp.Body)
+
+	case ast.ReturnExpression:
+		p.write("return")
+		if exp.Value != nil {
+			p.write(" ")
+			p.expression(exp.Value, precStatement)
+		}
+
+	case ast.BreakExpression:
+		p.write("break")
+
+	case ast.ContinueExpression:
+		p.write("continue")
+
+	case ast.Println:
+		p.write("println(")
+		p.element(exp.Arg, precStatement)
+		p.write(")")
+
+	case ast.FunctionCall:
+		switch {
+		case exp.Callee == nil && exp.Name == "println":
+			// NOTE: println( starts a println rather than a call by name
+			p.write("(println)")
+		case exp.Callee == nil:
+			p.write(exp.Name)
+		default:
+			p.expression(exp.Callee, precPostfix)
+		}
+		p.write("(")
+		p.list(exp.Args)
+		p.write(")")
+
+	case ast.FunctionLiteral:
+		p.write("define")
+		p.function(exp.Definition())
+
+	case ast.ListLiteral:
+		p.write("[")
+		p.list(exp.Elements)
+		p.write("]")
+
+	case ast.IndexExpression:
+		p.expression(exp.Collection, precPostfix)
+		p.write("[")
+		p.expression(exp.Index, precStatement)
+		p.write("]")
+
+	case ast.MapLiteral:
+		p.write("#{")
+		for j, key := range exp.Keys {
+			if j > 0 {
+				p.write(", ")
+			}
+			p.element(key, precDisjunctive)
+			p.write(": ")
+			p.element(exp.Values[j], precStatement)
+		}
+		p.write("}")
+
+	case ast.StructLiteral:
+		p.write(exp.Name + "{")
+		for j, field := range exp.Fields {
+			if j > 0 {
+				p.write(", ")
+			}
+			p.write(field + ": ")
+			p.element(exp.Values[j], precStatement)
+		}
+		p.write("}")
+
+	case ast.FieldAccess:
+		p.expression(exp.Object, precPostfix)
+		p.write("." + exp.Field)
+	}
+}
+
+func (p *printer) list(exps []ast.Expression) {
+	for j, exp := range exps {
+		if j > 0 {
+			p.write(", ")
+		}
+		p.element(exp, precStatement)
+	}
+}
+
+// element writes exp in brackets, where lines may break before it.
+func (p *printer) element(exp ast.Expression, prec int) {
+	p.inside(exp.Range().Start, true)
+	p.expression(exp, prec)
+}
+
+// unaryOperand writes the operand of exp. An operand of - which starts with a
+// digit is parenthesized, since - and the digits would be read as a negative
+// number, unless it is a power of a number, after which the parser looks for
+// **.
+func (p *printer) unaryOperand(exp ast.UnaryExpression) {
+	start := p.buf.Len()
+	p.expression(exp.Operand, precUnary)
+	if exp.Operator != ast.Negate || !isDigit(p.buf.Bytes()[start]) {
+		return
+	}
+	if power, ok := exp.Operand.(ast.BinaryExpression); ok && power.Operator == ast.Power && precedence(power.Lhs) == precPostfix {
+		switch power.Lhs.(type) {
+		case ast.IntegerLiteral, ast.FloatLiteral:
+			return
+		}
+	}
+
+	operand := string(p.buf.Bytes()[start:])
+	p.buf.Truncate(start)
+	p.write("(" + operand + ")")
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// formatFloat writes f as the shortest float literal which reads back as f.
+func formatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// quote returns s as a string literal, escaping only what the grammar does not
+// allow in one.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for j := 0; j < len(s); j++ {
+		switch c := s[j]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,417 @@
+package printer_test
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/parser"
+	"github.com/TOMOFUMI-KONDO/toy/printer"
+)
+
+func parse(source string) (ast.Program, error) {
+	toy := &parser.Toy{Buffer: source}
+	if err := toy.Init(); err != nil {
+		return ast.Program{}, err
+	}
+	if err := toy.Parse(); err != nil {
+		return ast.Program{}, err
+	}
+	if err := toy.ConvertAst(); err != nil {
+		return ast.Program{}, err
+	}
+	return toy.Program, nil
+}
+
+// testRoundTrip tests that formatted, the formatted source of program, reads
+// back as program and is formatted the same again.
+func testRoundTrip(t *testing.T, program ast.Program, formatted []byte) {
+	t.Helper()
+
+	again, err := parse(string(formatted))
+	if err != nil {
+		t.Errorf("%v\nformatted = \n%s", err, formatted)
+		return
+	}
+	if !sameAST(reflect.ValueOf(program.Definitions), reflect.ValueOf(again.Definitions)) {
+		t.Errorf("formatted program reads back differently\nformatted = \n%s", formatted)
+	}
+	if twice := printer.Format(again); string(twice) != string(formatted) {
+		t.Errorf("formatted twice = \n%s\nformatted = \n%s", twice, formatted)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			"imports",
+			"import \"a.toy\"\nimport \"b/c.toy\"  as  d\nimport \"e.toy\" as e\n",
+			"import \"a.toy\"\nimport \"b/c.toy\" as d\nimport \"e.toy\"\n",
+		},
+		{
+			"structs",
+			"struct Point {x,y}\nstruct Empty {}\nstruct Wide {\n\ta,\n\tb\n}\n",
+			"struct Point { x, y }\nstruct Empty {}\nstruct Wide { a, b }\n",
+		},
+		{
+			"globals",
+			"global origin: Point=Point{x: 0, y: 0}\nglobal n=1\n",
+			"global origin: Point = Point{x: 0, y: 0}\nglobal n = 1\n",
+		},
+		{
+			"functions",
+			"define add(a,b=n) { a+b }\ndefine sum(xs...) {\n0\n}\n" +
+				"define scale(p: Point,k: int=2): Point {\nreturn Point{x: p.x*k, y: p.y*k}\n}\ndefine nothing() {}\n",
+			"define add(a, b = n) {\n\ta + b\n}\n\ndefine sum(xs...) {\n\t0\n}\n\n" +
+				"define scale(p: Point, k: int = 2): Point {\n\treturn Point{x: p.x * k, y: p.y * k}\n}\n\ndefine nothing() {}\n",
+		},
+		{
+			"kinds",
+			"import \"a.toy\"\nstruct S {x}\nglobal g=1\ndefine main() {\ng\n}\n",
+			"import \"a.toy\"\n\nstruct S { x }\n\nglobal g = 1\n\ndefine main() {\n\tg\n}\n",
+		},
+		{
+			"function literals",
+			"define main() {\nf=define(x,y...) { x }\ng=define(): int {\n1\n}\nf(1)(2)\n}\n",
+			"define main() {\n\tf = define(x, y...) {\n\t\tx\n\t}\n\tg = define(): int {\n\t\t1\n\t}\n\tf(1)(2)\n}\n",
+		},
+		{
+			"control flow",
+			"define main() {\nwhile x>0 {\nif x<0 { continue } else { break }\n}\nif x {}\n{ 1 }\nreturn\n}\n",
+			"define main() {\n\twhile x > 0 {\n\t\tif x < 0 {\n\t\t\tcontinue\n\t\t} else {\n\t\t\tbreak\n\t\t}\n\t}\n" +
+				"\tif x {}\n\t{\n\t\t1\n\t}\n\treturn\n}\n",
+		},
+		{
+			"literals",
+			"define main() {\nm=#{\"a\":1, 2: [1,2.5e10,-3]}\nm[\"c\"]=#{}\np.x=99999999999999999999\nprintln(\"tab\\t\\\"quoted\\\"\")\ntrue||false\n}\n",
+			"define main() {\n\tm = #{\"a\": 1, 2: [1, 2.5e+10, -3]}\n\tm[\"c\"] = #{}\n\tp.x = 99999999999999999999\n" +
+				"\tprintln(\"tab\\t\\\"quoted\\\"\")\n\ttrue || false\n}\n",
+		},
+		{
+			"blank lines",
+			"define main() {\n\n\tx=1\n\n\n\ty=2\n\tz=3\n\n}\n",
+			"define main() {\n\tx = 1\n\n\ty = 2\n\tz = 3\n}\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			program, err := parse(test.source)
+			if err != nil {
+				t.Fatalf("%v\nsource = \n%s", err, test.source)
+			}
+			formatted := printer.Format(program)
+			if string(formatted) != test.expected {
+				t.Errorf("formatted = \n%s\nwant \n%s", formatted, test.expected)
+			}
+			testRoundTrip(t, program, formatted)
+		})
+	}
+}
+
+// programs are sources which use every kind of definition and expression, in
+// the spacing the parser accepts.
+var programs = []string{
+	`define main() {
+	1+2*3-4/2%3
+}`,
+	`define main() {
+	x= 3
+	x -1
+}`,
+	`define main() {
+	(1+2)*(3-(4-5))
+}`,
+	`define main() {
+	-2**2+(-2)**2-(-x)**2+2**-1+2**3**2+(2**3)**2
+}`,
+	`define main() {
+	!(a&&b)||!c&&d==(e<f)
+}`,
+	`define main() {
+	--2 - -x - -(2)
+}`,
+	`define main() {
+	xs=[1, [2, 3], []]
+	xs[1][0]=#{"k": define(a, b=2) { a+b }}
+	xs[1][0]["k"](1)
+}`,
+	`struct Node {value, next}
+global head: Node=Node{value: 1, next: Node{value: 2, next: 0}}
+define main() {
+	head.next.value=3
+	head.next.value
+}`,
+	`define fact(n: int): int {
+	if n<2 {
+		return 1
+	}
+	n*fact(n-1)
+}
+define main() {
+	fact(5)
+}`,
+	`define main() {
+	n=0
+	while 1 {
+		n=n+1
+		if n%2==0 {
+			continue
+		} else {
+			if n>9 { break }
+		}
+	}
+	n
+}`,
+	`import "lib.toy" as l
+define main() {
+	l.f(1 , 2)+l.g
+}`,
+	`define main() {
+	println(println(1.5e-7)+0.1)
+	(println)(2)
+	(f)(2)
+	(define() { 3 })()
+}`,
+	`define main() {
+	99999999999999999999-(-99999999999999999999)
+}`,
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	for _, source := range programs {
+		program, err := parse(source)
+		if err != nil {
+			t.Errorf("%v\nsource = \n%s", err, source)
+			continue
+		}
+		testRoundTrip(t, program, printer.Format(program))
+	}
+}
+
+// TestFormatParentheses tests that the printer writes the parentheses which
+// an AST made without the parser needs, and no others.
+func TestFormatParentheses(t *testing.T) {
+	x, y, z := ast.NewIdentifier("x"), ast.NewIdentifier("y"), ast.NewIdentifier("z")
+	two := ast.NewInteger(2)
+
+	tests := []struct {
+		exp      ast.Expression
+		expected string
+	}{
+		{ast.NewMultiply(ast.NewAdd(x, y), z), "(x + y) * z"},
+		{ast.NewAdd(x, ast.NewMultiply(y, z)), "x + y * z"},
+		{ast.NewSubtract(x, ast.NewSubtract(y, z)), "x - (y - z)"},
+		{ast.NewSubtract(ast.NewSubtract(x, y), z), "x - y - z"},
+		{ast.NewDivide(x, ast.NewMultiply(y, z)), "x / (y * z)"},
+		{ast.NewModulo(ast.NewDivide(x, y), z), "x / y % z"},
+		{ast.NewPower(ast.NewPower(x, y), z), "(x ** y) ** z"},
+		{ast.NewPower(x, ast.NewPower(y, z)), "x ** y ** z"},
+		{ast.NewPower(ast.NewNegate(x), two), "(-x) ** 2"},
+		{ast.NewPower(ast.NewInteger(-2), two), "(-2) ** 2"},
+		{ast.NewPower(ast.NewBigInteger(new(big.Int).Lsh(big.NewInt(-1), 70)), two), "(-1180591620717411303424) ** 2"},
+		{ast.NewPower(x, ast.NewNegate(y)), "x ** -y"},
+		{ast.NewMultiply(ast.NewNegate(x), ast.NewNot(y)), "-x * !y"},
+		{ast.NewNegate(two), "-(2)"},
+		{ast.NewNegate(ast.NewFloat(0)), "-(0.0)"},
+		{ast.NewNegate(ast.NewPower(two, two)), "-2 ** 2"},
+		{ast.NewNegate(ast.NewIndex(ast.NewListLiteral([]ast.Expression{two}), ast.NewInteger(0))), "-[2][0]"},
+		{ast.NewNegate(ast.NewInteger(-2)), "--2"},
+		{ast.NewNegate(ast.NewAdd(x, y)), "-(x + y)"},
+		{ast.NewIndex(ast.NewInteger(-1), two), "(-1)[2]"},
+		{ast.NewIndex(ast.NewAdd(x, y), z), "(x + y)[z]"},
+		{ast.NewCall(ast.NewPrintln(x), nil), "(println(x))()"},
+		{ast.NewCall(ast.NewFuncCall("x", nil), nil), "x()()"},
+		{ast.NewFuncCall("println", []ast.Expression{x}), "(println)(x)"},
+		{ast.NewPrintln(ast.NewAdd(x, y)), "println(x + y)"},
+		{ast.NewFieldAccess(ast.NewAdd(x, y), "z"), "(x + y).z"},
+		{ast.NewFieldAccess(ast.NewIndex(x, two), "z"), "x[2].z"},
+		{ast.NewNot(ast.NewOr(x, ast.NewAnd(y, z))), "!(x || y && z)"},
+		{ast.NewOr(ast.NewAnd(x, y), z), "x && y || z"},
+		{ast.NewAnd(ast.NewOr(x, y), ast.NewLessThan(y, z)), "(x || y) && y < z"},
+		{ast.NewEqual(ast.NewLessThan(x, y), ast.NewBool(true)), "x < y == true"},
+		{ast.NewLessThan(x, ast.NewEqual(y, z)), "x < (y == z)"},
+		{ast.NewAssignment("x", ast.NewOr(y, z)), "x = y || z"},
+		{ast.NewListLiteral([]ast.Expression{ast.NewOr(x, y)}), "[x || y]"},
+	}
+
+	for _, test := range tests {
+		got := printer.Expression(test.exp)
+		if got != test.expected {
+			t.Errorf("Expression(%#v) = %s; want %s", test.exp, got, test.expected)
+			continue
+		}
+
+		program, err := parse(fmt.Sprintf("define main() {\n\t%s\n}", got))
+		if err != nil {
+			t.Errorf("%v\nexpression = %s", err, got)
+			continue
+		}
+		body := program.Definitions[0].(ast.FunctionDefinition).Body.Expressions
+		if len(body) != 1 || !sameAST(reflect.ValueOf(body[0]), reflect.ValueOf(test.exp)) {
+			t.Errorf("%s reads back as %#v; want %#v", got, body, test.exp)
+		}
+	}
+}
+
+func TestFormatComments(t *testing.T) {
+	source := `// Package doc.
+
+import "lib.toy" // the library
+// two is 2.
+global two=2
+
+define main() {
+	/* inline */ x=two
+	xs=[1, // one
+		2]
+	if x {
+		// nothing
+	} else {
+		// nor here
+	}
+
+	// x is the result
+	x
+}
+// the end
+`
+	expected := `// Package doc.
+
+import "lib.toy" // the library
+
+// two is 2.
+global two = 2
+
+define main() {
+	/* inline */ x = two
+	xs = [1, // one
+		2]
+	if x {
+		// nothing
+	} else {
+		// nor here
+	}
+
+	// x is the result
+	x
+}
+// the end
+`
+
+	program, err := parse(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatted := printer.Format(program)
+	if string(formatted) != expected {
+		t.Errorf("formatted = \n%s\nwant \n%s", formatted, expected)
+	}
+	testRoundTrip(t, program, formatted)
+
+	if got := string(printer.Format(ast.Program{})); got != "" {
+		t.Errorf("empty program formatted = %q; want nothing", got)
+	}
+	program, err = parse("// nothing but\n/* comments */\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(printer.Format(program)), "// nothing but\n/* comments */\n"; got != want {
+		t.Errorf("formatted = %q; want %q", got, want)
+	}
+}
+
+// TestFormatInlineComments tests that comments between the parts of an
+// expression stay before the part they were before.
+func TestFormatInlineComments(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"x=/* c */ 1", "x = /* c */ 1"},
+		{"x=1 /* c */ +2", "x = 1 + /* c */ 2"},
+		{"x =1+ /* c */2*3", "x = 1 + /* c */ 2 * 3"},
+		{"f(x, /* arg */ y)", "f(x, /* arg */ y)"},
+		{"f( /* first */ x,y /* last */ )", "f(/* first */ x, y) /* last */"},
+		{"f(x, // a\n\ty)", "f(x, // a\n\t\ty)"},
+		{"println(/* c */ x)", "println(/* c */ x)"},
+		{"xs=[1, // one\n2]", "xs = [1, // one\n\t\t2]"},
+		{"if x>0 /* cond */ {\n\tx\n}", "if x > 0 /* cond */ {\n\t\tx\n\t}"},
+		{"while x /* c */ && /* d */ y {}", "while x && /* c */ /* d */ y {}"},
+		{"g=define(a, // first\n\tb=2) { a }", "g = define(// first\n\t\ta, b = 2) {\n\t\ta\n\t}"},
+		{"g=define(a, /* b */ b=/* two */ 2) { a }", "g = define(a, b = /* b */ /* two */ 2) {\n\t\ta\n\t}"},
+	}
+
+	for _, test := range tests {
+		source := "define main() {\n\t" + test.source + "\n}\n"
+		program, err := parse(source)
+		if err != nil {
+			t.Errorf("%v\nsource = \n%s", err, source)
+			continue
+		}
+		formatted := printer.Format(program)
+		if expected := "define main() {\n\t" + test.expected + "\n}\n"; string(formatted) != expected {
+			t.Errorf("formatted = \n%s\nwant \n%s", formatted, expected)
+		}
+		testRoundTrip(t, program, formatted)
+	}
+}
+
+var (
+	spanType   = reflect.TypeOf(ast.Span{})
+	bigIntType = reflect.TypeOf(&big.Int{})
+)
+
+// sameAST reports whether a and b are deeply equal but for their spans.
+func sameAST(a, b reflect.Value) bool {
+	if a.IsValid() != b.IsValid() {
+		return false
+	}
+	if !a.IsValid() {
+		return true
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		if a.Type() == bigIntType {
+			return a.Interface().(*big.Int).Cmp(b.Interface().(*big.Int)) == 0
+		}
+		return sameAST(a.Elem(), b.Elem())
+
+	case reflect.Struct:
+		for j := 0; j < a.NumField(); j++ {
+			if a.Type().Field(j).Type == spanType {
+				continue
+			}
+			if !sameAST(a.Field(j), b.Field(j)) {
+				return false
+			}
+		}
+		return true
+
+	case reflect.Slice:
+		if a.IsNil() != b.IsNil() || a.Len() != b.Len() {
+			return false
+		}
+		for j := 0; j < a.Len(); j++ {
+			if !sameAST(a.Index(j), b.Index(j)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
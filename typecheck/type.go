@@ -0,0 +1,74 @@
+package typecheck
+
+import "github.com/TOMOFUMI-KONDO/toy/builtins"
+
+// Type is the static type of an expression. Types are named as value.Value
+// names the types of runtime values, so the type of a struct is its name.
+type Type string
+
+const (
+	// Any is the type of what may be any value, such as an unannotated
+	// argument or an element of a list. No use of it is an error.
+	Any      Type = "any"
+	Int      Type = "int"
+	Float    Type = "float"
+	Bool     Type = "bool"
+	String   Type = "string"
+	List     Type = "list"
+	Map      Type = "map"
+	Function Type = "function"
+
+	// never is the type of return, break and continue, which leave the
+	// expression instead of making a value.
+	never Type = "never"
+)
+
+// basicTypes are the types which can be annotated besides struct names.
+var basicTypes = map[string]Type{
+	"any":      Any,
+	"int":      Int,
+	"float":    Float,
+	"bool":     Bool,
+	"string":   String,
+	"list":     List,
+	"map":      Map,
+	"function": Function,
+}
+
+// assignable reports whether a value of type from can be used where a value of
+// type to is expected.
+func assignable(from, to Type) bool {
+	switch {
+	case from == to, from == Any, to == Any, from == never:
+		return true
+	default:
+		// NOTE: an int is accepted as a float as operators promote it
+		return from == Int && to == Float
+	}
+}
+
+// join returns the type of a value which is either an a or a b.
+func join(a, b Type) Type {
+	switch {
+	case a == b, b == never:
+		return a
+	case a == never:
+		return b
+	default:
+		return Any
+	}
+}
+
+func numeric(t Type) bool {
+	return t == Int || t == Float
+}
+
+// signature returns the types of the arguments of f which are checked, the
+// first len(f.ArgTypes), and the type of its result.
+func signature(f *builtins.Func) ([]Type, Type) {
+	args := make([]Type, len(f.ArgTypes))
+	for j, name := range f.ArgTypes {
+		args[j] = basicTypes[name]
+	}
+	return args, basicTypes[f.ReturnType]
+}
@@ -0,0 +1,544 @@
+// Package typecheck finds type errors in toy programs without running them.
+//
+// Type annotations on arguments, results and global variables are optional.
+// The type of an unannotated variable or result is inferred from what is
+// assigned to it or returned, and it becomes Any once values of two types are.
+// An unannotated argument is Any. Since no use of Any is an error, a program
+// without annotations only has errors which fail at runtime once reached.
+//
+// Annotations are only read here: the interpreter and the vm ignore them.
+// Undefined names and wrong numbers of arguments are left to
+// interpreter.Check.
+package typecheck
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/builtins"
+	"github.com/TOMOFUMI-KONDO/toy/interpreter"
+)
+
+// Error is a type error at Span.
+type Error struct {
+	Span ast.Span
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Span.Start, e.Msg)
+}
+
+// Check returns every type error of program in the order of the source. b
+// holds the host functions the program is run with, which hide the builtins of
+// the same name and take and return Any.
+func Check(program ast.Program, b *interpreter.Builtins) []*Error {
+	c := &checker{
+		funcs:    map[string]*function{},
+		structs:  map[string]ast.StructDefinition{},
+		builtins: b,
+		globals:  newScope(nil),
+	}
+	for _, topLevel := range program.Definitions {
+		switch def := topLevel.(type) {
+		case ast.FunctionDefinition:
+			c.funcs[def.Name] = &function{def: def}
+		case ast.StructDefinition:
+			c.structs[def.Name] = def
+		}
+	}
+
+	// NOTE: global variables are initialized in order, before any function runs
+	for _, topLevel := range program.Definitions {
+		if def, ok := topLevel.(ast.GlobalVariableDefinition); ok {
+			t := c.expression(c.globals, def.Expression)
+			v := &variable{typ: t}
+			if def.Type != "" {
+				v.typ, v.annotated = c.annotation(def.Span, def.Type), true
+				if !assignable(t, v.typ) {
+					c.errorf(def.Expression.Range(), "cannot assign %s to %s of type %s", t, def.Name, v.typ)
+				}
+			}
+			c.globals.vars[def.Name] = v
+		}
+	}
+
+	for _, topLevel := range program.Definitions {
+		if def, ok := topLevel.(ast.FunctionDefinition); ok {
+			c.check(c.funcs[def.Name])
+		}
+	}
+
+	// NOTE: a program linked from modules has definitions of several files
+	files := map[string]int{}
+	for _, topLevel := range program.Definitions {
+		if _, ok := files[topLevel.Pos().File]; !ok {
+			files[topLevel.Pos().File] = len(files)
+		}
+	}
+	sort.SliceStable(c.errors, func(i, j int) bool {
+		a, b := c.errors[i].Span.Start, c.errors[j].Span.Start
+		if a.File != b.File {
+			return files[a.File] < files[b.File]
+		}
+		return a.Offset < b.Offset
+	})
+	return c.errors
+}
+
+type checker struct {
+	funcs    map[string]*function
+	structs  map[string]ast.StructDefinition
+	builtins *interpreter.Builtins
+	globals  *scope
+	errors   []*Error
+}
+
+// function is a defined function. Its body is checked once, when it is first
+// called or else in the order of the source, which infers its result.
+type function struct {
+	def      ast.FunctionDefinition
+	result   Type
+	checking bool
+	checked  bool
+}
+
+type variable struct {
+	typ Type
+	// annotated is set if typ is annotated, so that assigning a value of any
+	// other type is an error rather than making the variable Any.
+	annotated bool
+}
+
+type scope struct {
+	vars   map[string]*variable
+	parent *scope
+	// frame is the function the scope is the body of.
+	frame *frame
+}
+
+// frame is what the checker knows about the result of the function being
+// checked.
+type frame struct {
+	name string
+	// result is the annotated result type, or "" if not annotated.
+	result Type
+	// returns is the type of the values of the return expressions.
+	returns Type
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{
+		vars:   map[string]*variable{},
+		parent: parent,
+	}
+}
+
+func (s *scope) lookup(name string) *variable {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+func (c *checker) errorf(span ast.Span, format string, a ...interface{}) {
+	c.errors = append(c.errors, &Error{Span: span, Msg: fmt.Sprintf(format, a...)})
+}
+
+// lookupType returns the type named name.
+func (c *checker) lookupType(name string) (Type, bool) {
+	if t, ok := basicTypes[name]; ok {
+		return t, true
+	}
+	if _, ok := c.structs[name]; ok {
+		return Type(name), true
+	}
+	return Any, false
+}
+
+// annotation returns the type named name, reporting it at span if there is no
+// such type.
+func (c *checker) annotation(span ast.Span, name string) Type {
+	t, ok := c.lookupType(name)
+	if !ok {
+		c.errorf(span, "type %s is not defined", name)
+	}
+	return t
+}
+
+func (c *checker) check(f *function) {
+	if f.checking || f.checked {
+		return
+	}
+
+	f.checking = true
+	f.result = c.checkFunction(c.globals, f.def)
+	f.checking, f.checked = false, true
+}
+
+// resultOf returns the result type of f, checking f first unless annotated.
+func (c *checker) resultOf(f *function) Type {
+	if f.def.ReturnType != "" {
+		t, _ := c.lookupType(f.def.ReturnType)
+		return t
+	}
+
+	c.check(f)
+	if f.checking {
+		// NOTE: the result of a recursive call is not known until f is checked
+		return Any
+	}
+	return f.result
+}
+
+// checkFunction checks the body of def in a scope enclosed by parent, and
+// returns the type of its result.
+func (c *checker) checkFunction(parent *scope, def ast.FunctionDefinition) Type {
+	name := def.Name
+	if name == "" {
+		name = "function literal"
+	}
+
+	fs := newScope(parent)
+	fs.frame = &frame{name: name, returns: never}
+	if def.ReturnType != "" {
+		fs.frame.result = c.annotation(def.Span, def.ReturnType)
+	}
+
+	for j, arg := range def.Args {
+		v := &variable{typ: Any}
+		if def.Variadic && j == len(def.Args)-1 {
+			v.typ = List
+		}
+		if typeName := def.ArgType(j); typeName != "" {
+			v.typ, v.annotated = c.annotation(def.Span, typeName), true
+		}
+
+		if defaultValue := def.Default(j); defaultValue != nil {
+			if t := c.expression(fs, defaultValue); v.annotated && !assignable(t, v.typ) {
+				c.errorf(defaultValue.Range(), "default value of %s must be %s, not %s", arg, v.typ, t)
+			}
+		}
+		fs.vars[arg] = v
+	}
+
+	t := c.expression(fs, def.Body)
+	if fs.frame.result == "" {
+		return join(t, fs.frame.returns)
+	}
+
+	if !assignable(t, fs.frame.result) {
+		span := def.Body.Span
+		if n := len(def.Body.Expressions); n > 0 {
+			span = def.Body.Expressions[n-1].Range()
+		}
+		c.errorf(span, "%s must return %s, not %s", name, fs.frame.result, t)
+	}
+	return fs.frame.result
+}
+
+// expression checks exp and returns its type.
+func (c *checker) expression(s *scope, intf ast.Expression) Type {
+	switch exp := intf.(type) {
+	case ast.IntegerLiteral:
+		return Int
+
+	case ast.FloatLiteral:
+		return Float
+
+	case ast.BooleanLiteral:
+		return Bool
+
+	case ast.StringLiteral:
+		return String
+
+	case ast.BinaryExpression:
+		l := c.expression(s, exp.Lhs)
+		r := c.expression(s, exp.Rhs)
+		return c.binary(exp, l, r)
+
+	case ast.UnaryExpression:
+		t := c.expression(s, exp.Operand)
+		if exp.Operator == ast.Not {
+			c.condition(exp.Operand, t)
+			return Bool
+		}
+		if numeric(t) || t == Any || t == never {
+			return t
+		}
+		c.errorf(exp.Span, "unsupported operand type for %s: %s", exp.Operator.Name(), t)
+		return Any
+
+	case ast.Identifier:
+		if v := s.lookup(exp.Name); v != nil {
+			return v.typ
+		}
+		if _, ok := c.funcs[exp.Name]; ok {
+			return Function
+		}
+		if _, ok := c.builtins.Lookup(exp.Name); ok {
+			return Function
+		}
+		return Any
+
+	case ast.Assignment:
+		t := c.expression(s, exp.Expression)
+		v := s.lookup(exp.Name)
+		switch {
+		case v == nil:
+			s.vars[exp.Name] = &variable{typ: t}
+		case v.annotated:
+			if !assignable(t, v.typ) {
+				c.errorf(exp.Span, "cannot assign %s to %s of type %s", t, exp.Name, v.typ)
+			}
+		default:
+			v.typ = join(v.typ, t)
+		}
+		return t
+
+	case ast.IfExpression:
+		c.condition(exp.Condition, c.expression(s, exp.Condition))
+		t := c.expression(s, exp.ThenClause)
+		if exp.ElseClause.Expressions == nil {
+			// NOTE: an if without else evaluates 1 if the condition is false
+			return join(t, Int)
+		}
+		return join(t, c.expression(s, exp.ElseClause))
+
+	case ast.WhileExpression:
+		c.condition(exp.Condition, c.expression(s, exp.Condition))
+		c.expression(s, exp.Body)
+		return Int
+
+	case ast.BlockExpression:
+		t := Int
+		for _, e := range exp.Expressions {
+			t = c.expression(s, e)
+		}
+		return t
+
+	case ast.Println:
+		return c.expression(s, exp.Arg)
+
+	case ast.ReturnExpression:
+		t := Int
+		if exp.Value != nil {
+			t = c.expression(s, exp.Value)
+		}
+		c.checkReturn(s, exp, t)
+		return never
+
+	case ast.BreakExpression, ast.ContinueExpression:
+		return never
+
+	case ast.FunctionLiteral:
+		c.checkFunction(s, exp.Definition())
+		return Function
+
+	case ast.ListLiteral:
+		for _, e := range exp.Elements {
+			c.expression(s, e)
+		}
+		return List
+
+	case ast.MapLiteral:
+		for j := range exp.Keys {
+			c.key(exp.Keys[j], c.expression(s, exp.Keys[j]))
+			c.expression(s, exp.Values[j])
+		}
+		return Map
+
+	case ast.StructLiteral:
+		for _, v := range exp.Values {
+			c.expression(s, v)
+		}
+		if _, ok := c.structs[exp.Name]; !ok {
+			return Any
+		}
+		return Type(exp.Name)
+
+	case ast.FieldAccess:
+		c.field(exp.Span, c.expression(s, exp.Object), exp.Field)
+		return Any
+
+	case ast.FieldAssignment:
+		c.field(exp.Span, c.expression(s, exp.Object), exp.Field)
+		return c.expression(s, exp.Value)
+
+	case ast.IndexExpression:
+		c.index(exp.Index, c.expression(s, exp.Collection), c.expression(s, exp.Index))
+		return Any
+
+	case ast.IndexAssignment:
+		c.index(exp.Index, c.expression(s, exp.Collection), c.expression(s, exp.Index))
+		return c.expression(s, exp.Value)
+
+	case ast.FunctionCall:
+		return c.call(s, exp)
+
+	default:
+		return Any
+	}
+}
+
+func (c *checker) binary(exp ast.BinaryExpression, l, r Type) Type {
+	switch exp.Operator {
+	case ast.And, ast.Or:
+		c.condition(exp.Lhs, l)
+		c.condition(exp.Rhs, r)
+		return Bool
+	case ast.Equal, ast.NotEqual:
+		return Bool
+	}
+
+	if l == never {
+		l = Any
+	}
+	if r == never {
+		r = Any
+	}
+
+	if (numeric(l) || l == Any) && (numeric(r) || r == Any) {
+		switch exp.Operator {
+		case ast.LessThan, ast.LessOrEqual, ast.GreaterThan, ast.GreaterOrEqual:
+			return Bool
+		}
+		switch {
+		case l == Float || r == Float:
+			return Float
+		case l == Int && r == Int:
+			return Int
+		default:
+			return Any
+		}
+	}
+
+	if exp.Operator == ast.Add {
+		if l == String || r == String {
+			return String
+		}
+		// NOTE: the other operand may be a string at runtime
+		if l == Any || r == Any {
+			return Any
+		}
+	}
+
+	c.errorf(exp.Span, "unsupported operand types for %s: %s and %s", exp.Operator.Name(), l, r)
+	return Any
+}
+
+// condition reports exp of type t unless it can be a condition.
+func (c *checker) condition(exp ast.Expression, t Type) {
+	if !assignable(t, Bool) && !assignable(t, Int) {
+		c.errorf(exp.Range(), "condition must be bool or int, not %s", t)
+	}
+}
+
+func (c *checker) key(exp ast.Expression, t Type) {
+	if !assignable(t, Int) && !assignable(t, String) {
+		c.errorf(exp.Range(), "map key must be int or string, not %s", t)
+	}
+}
+
+// index reports indexing a collection of type collection with index of type t.
+func (c *checker) index(index ast.Expression, collection, t Type) {
+	switch collection {
+	case List:
+		if !assignable(t, Int) {
+			c.errorf(index.Range(), "list index must be int, not %s", t)
+		}
+	case Map:
+		c.key(index, t)
+	case Any, never:
+	default:
+		c.errorf(index.Range(), "%s is not indexable", collection)
+	}
+}
+
+// field reports accessing the field named name of a value of type object.
+func (c *checker) field(span ast.Span, object Type, name string) {
+	if object == Any || object == never {
+		return
+	}
+
+	if structDef, ok := c.structs[string(object)]; ok {
+		for _, field := range structDef.Fields {
+			if field == name {
+				return
+			}
+		}
+	}
+	c.errorf(span, "%s has no field %s", object, name)
+}
+
+func (c *checker) checkReturn(s *scope, exp ast.ReturnExpression, t Type) {
+	for ; s != nil; s = s.parent {
+		if f := s.frame; f != nil {
+			if f.result != "" && !assignable(t, f.result) {
+				c.errorf(exp.Span, "%s must return %s, not %s", f.name, f.result, t)
+			}
+			f.returns = join(f.returns, t)
+			return
+		}
+	}
+}
+
+// call checks the arguments of exp against the annotations of the function it
+// calls, and returns the type of its result.
+func (c *checker) call(s *scope, exp ast.FunctionCall) Type {
+	var callee Type
+	if exp.Callee != nil {
+		callee = c.expression(s, exp.Callee)
+	}
+
+	args := make([]Type, len(exp.Args))
+	for j, arg := range exp.Args {
+		args[j] = c.expression(s, arg)
+	}
+
+	if exp.Callee == nil {
+		// NOTE: names resolve to a variable, a defined function, a host function,
+		// then a builtin
+		if v := s.lookup(exp.Name); v != nil {
+			callee = v.typ
+		} else if f, ok := c.funcs[exp.Name]; ok {
+			c.arguments(exp, f.def, args)
+			return c.resultOf(f)
+		} else if b, ok := c.builtins.Lookup(exp.Name); ok && b.Host {
+			return Any
+		} else if f, ok := builtins.Lookup(exp.Name); ok {
+			types, result := signature(f)
+			for j, t := range types {
+				if j < len(args) && !assignable(args[j], t) {
+					c.errorf(exp.Args[j].Range(), "argument %d of %s must be %s, not %s", j+1, exp.Name, t, args[j])
+				}
+			}
+			return result
+		} else {
+			return Any
+		}
+	}
+
+	if callee != Function && callee != Any && callee != never {
+		c.errorf(exp.Span, "%s is not a function", callee)
+	}
+	return Any
+}
+
+func (c *checker) arguments(exp ast.FunctionCall, def ast.FunctionDefinition, args []Type) {
+	for j, t := range args {
+		if j >= len(def.Args) || (def.Variadic && j >= len(def.Args)-1) {
+			return
+		}
+		typeName := def.ArgType(j)
+		if typeName == "" {
+			continue
+		}
+		if want, ok := c.lookupType(typeName); ok && !assignable(t, want) {
+			c.errorf(exp.Args[j].Range(), "argument %s of %s must be %s, not %s", def.Args[j], def.Name, want, t)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package typecheck_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/TOMOFUMI-KONDO/toy/interpreter"
+	"github.com/TOMOFUMI-KONDO/toy/parser"
+	"github.com/TOMOFUMI-KONDO/toy/typecheck"
+	"github.com/TOMOFUMI-KONDO/toy/value"
+)
+
+func check(t *testing.T, source string) []string {
+	t.Helper()
+	return checkBuiltins(t, source, interpreter.NewBuiltins(nil))
+}
+
+func checkBuiltins(t *testing.T, source string, b *interpreter.Builtins) []string {
+	t.Helper()
+
+	toy := &parser.Toy{Buffer: source}
+	if err := toy.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := toy.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if err := toy.ConvertAst(); err != nil {
+		t.Fatal(err)
+	}
+
+	var errs []string
+	for _, err := range typecheck.Check(toy.Program, b) {
+		errs = append(errs, err.Error())
+	}
+	return errs
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected []string
+	}{
+		{
+			`define main() {
+				1+true
+			}`,
+			[]string{"2:5: unsupported operand types for Add: int and bool"},
+		},
+		{
+			`define main() {
+				"n="+1+true
+			}`,
+			nil,
+		},
+		{
+			`define main() {
+				x=1
+				x="a"
+				x-1
+			}`,
+			nil,
+		},
+		{
+			`define main() {
+				if "a" { 1 }
+				while 1.5 { 1 }
+				!"a"
+				-"a"
+			}`,
+			[]string{
+				"2:8: condition must be bool or int, not string",
+				"3:11: condition must be bool or int, not float",
+				"4:6: condition must be bool or int, not string",
+				"5:5: unsupported operand type for Negate: string",
+			},
+		},
+		{
+			`define add(a: int,b: int): int {
+				a+b
+			}
+			define main() {
+				add(1,"2")+add(1.5,2)+add(1,2)
+			}`,
+			[]string{
+				"5:11: argument b of add must be int, not string",
+				"5:20: argument a of add must be int, not float",
+			},
+		},
+		{
+			`define half(x: float): float {
+				x/2
+			}
+			define main() {
+				half(1)
+			}`,
+			nil,
+		},
+		{
+			`define name(n: int): string {
+				if n==0 {
+					return 0
+				}
+				n
+			}`,
+			[]string{
+				"3:6: name must return string, not int",
+				"5:5: name must return string, not int",
+			},
+		},
+		{
+			`define f(n) {
+				if n>0 {
+					return "positive"
+				}
+				"not positive"
+			}
+			define main() {
+				f(1)-1
+			}`,
+			[]string{"8:5: unsupported operand types for Subtract: string and int"},
+		},
+		{
+			`define fact(n: int) {
+				if n<=1 {
+					return 1
+				}
+				n*fact(n-1)
+			}
+			define main() {
+				fact(3)+"!"
+				!fact(3)
+			}`,
+			nil,
+		},
+		{
+			`global limit: int = 10
+			global name: string = 3
+			define main() {
+				limit="x"
+				limit=5
+			}`,
+			[]string{
+				"2:26: cannot assign int to name of type string",
+				"4:5: cannot assign string to limit of type int",
+			},
+		},
+		{
+			`struct Point { x, y }
+			define dist(p: Point,q: Pointer) {
+				p.z
+			}
+			define main() {
+				dist(Point{},1)
+				dist(1,2)
+			}`,
+			[]string{
+				"2:4: type Pointer is not defined",
+				"3:5: Point has no field z",
+				"7:10: argument p of dist must be Point, not int",
+			},
+		},
+		{
+			`define main() {
+				xs=[1, 2]
+				m=#{"a": 1}
+				xs["a"]+m[true]
+				n=1
+				n[0]
+				n.x
+				n(1)
+				#{[1]: 2}
+			}`,
+			[]string{
+				"4:8: list index must be int, not string",
+				"4:15: map key must be int or string, not bool",
+				"6:7: int is not indexable",
+				"7:5: int has no field x",
+				"8:5: int is not a function",
+				"9:7: map key must be int or string, not list",
+			},
+		},
+		{
+			`define main() {
+				push(#{},1)
+				len(keys(#{}))+1
+				has([1],1)
+			}`,
+			[]string{
+				"2:10: argument 1 of push must be list, not map",
+				"4:9: argument 1 of has must be map, not list",
+			},
+		},
+		{
+			`define main() {
+				f=define(x: int,y: bool=1): string { x }
+				f(1)
+			}`,
+			[]string{
+				"2:29: default value of y must be bool, not int",
+				"2:42: function literal must return string, not int",
+			},
+		},
+		{
+			`define g(n) {
+				n+1
+			}
+			define main() {
+				s="a"
+				g(1)+s
+				x=g(1)
+				x-s
+			}`,
+			[]string{"8:5: unsupported operand types for Subtract: any and string"},
+		},
+	}
+
+	for _, test := range tests {
+		errs := check(t, test.source)
+		if strings.Join(errs, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("errors = %q; want %q\nsource = \n%s", errs, test.expected, test.source)
+		}
+	}
+}
+
+func TestCheckHostFuncs(t *testing.T) {
+	source := `define main() {
+		f=host
+		f+1
+		push(1,2)
+	}`
+
+	// host is unknown and push is the builtin
+	expected := []string{"4:8: argument 1 of push must be list, not int"}
+	if errs := check(t, source); strings.Join(errs, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("errors = %q; want %q", errs, expected)
+	}
+
+	b := interpreter.NewBuiltins(nil)
+	fn := func(args []value.Value) (value.Value, error) { return value.Int(0), nil }
+	b.Register("host", 0, 0, fn)
+	b.Register("push", 2, 2, fn)
+	expected = []string{"3:3: unsupported operand types for Add: function and int"}
+	if errs := checkBuiltins(t, source, b); strings.Join(errs, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("errors = %q; want %q", errs, expected)
+	}
+}
@@ -0,0 +1,261 @@
+// Package value defines the runtime values of toy programs, shared by
+// interpreter.Interpreter and vm.VM.
+package value
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+type Value interface {
+	// Type is the name of the type of the value, used in error messages.
+	Type() string
+	// String is how println prints the value.
+	String() string
+}
+
+type Int int
+
+func (Int) Type() string {
+	return "int"
+}
+
+func (i Int) String() string {
+	return strconv.Itoa(int(i))
+}
+
+// BigInt is an int out of the range of Int, which only arithmetic on unbounded
+// ints makes. N is never changed once the BigInt is made.
+type BigInt struct {
+	N *big.Int
+}
+
+func (BigInt) Type() string {
+	return "int"
+}
+
+func (i BigInt) String() string {
+	return i.N.String()
+}
+
+type Float float64
+
+func (Float) Type() string {
+	return "float"
+}
+
+// String returns the shortest representation of f which parses back to f, and
+// which always reads as a float rather than an int.
+func (f Float) String() string {
+	s := strconv.FormatFloat(float64(f), 'g', -1, 64)
+	if strings.ContainsAny(s, ".eIN") {
+		return s
+	}
+	return s + ".0"
+}
+
+type Bool bool
+
+func (Bool) Type() string {
+	return "bool"
+}
+
+func (b Bool) String() string {
+	return strconv.FormatBool(bool(b))
+}
+
+type String string
+
+func (String) Type() string {
+	return "string"
+}
+
+func (s String) String() string {
+	return string(s)
+}
+
+// List is a sequence of values. It is shared by reference, so changes made
+// through one variable are seen through every other.
+type List struct {
+	Elements []Value
+}
+
+func NewList(elements []Value) *List {
+	return &List{Elements: elements}
+}
+
+func (*List) Type() string {
+	return "list"
+}
+
+func (l *List) String() string {
+	return show(l, map[Value]bool{})
+}
+
+// Map maps ints and strings to values. Like List, it is shared by reference.
+// Keys are kept in the order they were first set, which is the order Keys and
+// String return them in.
+type Map struct {
+	keys    []Value
+	entries map[Value]Value
+}
+
+func NewMap() *Map {
+	return &Map{entries: map[Value]Value{}}
+}
+
+func (*Map) Type() string {
+	return "map"
+}
+
+// CheckKey returns an error unless key can be a key of a Map.
+func CheckKey(key Value) error {
+	switch key.(type) {
+	case Int, String:
+		return nil
+	case BigInt:
+		return fmt.Errorf("integer too large for map key")
+	default:
+		return fmt.Errorf("map key must be int or string, not %s", key.Type())
+	}
+}
+
+func (m *Map) Get(key Value) (Value, bool) {
+	v, ok := m.entries[key]
+	return v, ok
+}
+
+func (m *Map) Set(key, v Value) {
+	if _, ok := m.entries[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.entries[key] = v
+}
+
+// Delete removes key from m, and reports whether it was there.
+func (m *Map) Delete(key Value) bool {
+	if _, ok := m.entries[key]; !ok {
+		return false
+	}
+	delete(m.entries, key)
+	for j, k := range m.keys {
+		if k == key {
+			m.keys = append(m.keys[:j], m.keys[j+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Keys returns the keys of m in the order they were first set.
+func (m *Map) Keys() []Value {
+	keys := make([]Value, len(m.keys))
+	copy(keys, m.keys)
+	return keys
+}
+
+func (m *Map) Len() int {
+	return len(m.keys)
+}
+
+func (m *Map) String() string {
+	return show(m, map[Value]bool{})
+}
+
+// show returns how v is shown inside the lists, maps and structs in outer.
+// One which is in outer contains itself, and is shown as [...], #{...} or
+// Name{...} instead of repeating forever.
+func show(v Value, outer map[Value]bool) string {
+	switch v := v.(type) {
+	case *List:
+		if outer[v] {
+			return "[...]"
+		}
+		outer[v] = true
+		defer delete(outer, v)
+
+		elements := make([]string, len(v.Elements))
+		for j, e := range v.Elements {
+			elements[j] = quote(e, outer)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+
+	case *Map:
+		if outer[v] {
+			return "#{...}"
+		}
+		outer[v] = true
+		defer delete(outer, v)
+
+		entries := make([]string, len(v.keys))
+		for j, k := range v.keys {
+			entries[j] = quote(k, outer) + ": " + quote(v.entries[k], outer)
+		}
+		return "#{" + strings.Join(entries, ", ") + "}"
+
+	case *Struct:
+		if outer[v] {
+			return v.Def.Name + "{...}"
+		}
+		outer[v] = true
+		defer delete(outer, v)
+
+		fields := make([]string, len(v.Values))
+		for j, field := range v.Values {
+			fields[j] = v.Def.Fields[j] + ": " + quote(field, outer)
+		}
+		return v.Def.Name + "{" + strings.Join(fields, ", ") + "}"
+	}
+	return v.String()
+}
+
+// quote returns how v is shown as an element of a list, a map or a struct.
+func quote(v Value, outer map[Value]bool) string {
+	// NOTE: quote strings so that ["a, b"] and ["a", "b"] look different
+	if s, ok := v.(String); ok {
+		return strconv.Quote(string(s))
+	}
+	return show(v, outer)
+}
+
+// StructType is a struct definition, which every value of the struct refers to.
+type StructType struct {
+	Name   string
+	Fields []string
+}
+
+// Field returns the index of the field named name.
+func (t *StructType) Field(name string) (int, bool) {
+	for j, field := range t.Fields {
+		if field == name {
+			return j, true
+		}
+	}
+	return 0, false
+}
+
+// Struct is a value of a struct type. Like List, it is shared by reference.
+// Values[i] is the value of the field Def.Fields[i].
+type Struct struct {
+	Def    *StructType
+	Values []Value
+}
+
+func NewStruct(def *StructType) *Struct {
+	values := make([]Value, len(def.Fields))
+	for j := range values {
+		values[j] = Int(0)
+	}
+	return &Struct{Def: def, Values: values}
+}
+
+// Type is the name of the struct type.
+func (s *Struct) Type() string {
+	return s.Def.Name
+}
+
+func (s *Struct) String() string {
+	return show(s, map[Value]bool{})
+}
@@ -0,0 +1,30 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/TOMOFUMI-KONDO/toy/value"
+)
+
+// Closure is a function value: a Function together with the cells of the
+// variables it captured from the functions enclosing it.
+type Closure struct {
+	Fn   *Function
+	free []*cell
+}
+
+func (*Closure) Type() string {
+	return "function"
+}
+
+func (c *Closure) String() string {
+	if c.Fn.Name == "" {
+		return "<function>"
+	}
+	return fmt.Sprintf("<function %s>", c.Fn.Name)
+}
+
+// cell holds a variable which a closure may refer to after its frame is gone.
+type cell struct {
+	v value.Value
+}
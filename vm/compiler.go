@@ -0,0 +1,714 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/builtins"
+	"github.com/TOMOFUMI-KONDO/toy/interpreter"
+	"github.com/TOMOFUMI-KONDO/toy/value"
+)
+
+// Compiler translates ast nodes into Functions. Constants, globals and
+// functions are shared by everything compiled with the same Compiler, so
+// chunks compiled later can refer to what earlier chunks defined.
+type Compiler struct {
+	constants []value.Value
+	// names holds the variable names which load instructions refer to, for
+	// reporting undefined variables, and the names of fields.
+	names   []string
+	globals map[string]int
+	// declared holds the names assigned at top level. Reading an unknown name
+	// allocates a global slot too, but does not make it a global in functions.
+	declared  map[string]bool
+	funcs     map[string]int
+	functions []*Function
+	// funcNames[i] is the name of functions[i], empty for function literals.
+	funcNames []string
+	// structs are resolved when compiled, unlike functions.
+	structs     map[string]int
+	structTypes []*value.StructType
+	// builtins are the functions implemented in Go, with those registered
+	// with VM.RegisterFunc.
+	builtins *interpreter.Builtins
+}
+
+func NewCompiler() *Compiler {
+	return &Compiler{
+		globals:  map[string]int{},
+		declared: map[string]bool{},
+		funcs:    map[string]int{},
+		structs:  map[string]int{},
+		builtins: interpreter.NewBuiltins(builtins.NewEnv(os.Stdin)),
+	}
+}
+
+// CompileProgram returns a chunk which defines functions and initializes
+// global variables in the order they appear in program.
+func (c *Compiler) CompileProgram(program ast.Program) (*Function, error) {
+	// declare every global name first so that function bodies refer to globals
+	// defined after them, just like interpreter.Interpreter does at runtime.
+	for _, topLevel := range program.Definitions {
+		switch def := topLevel.(type) {
+		case ast.FunctionDefinition:
+			c.function(def.Name)
+		case ast.StructDefinition:
+			c.defineStruct(def)
+		case ast.GlobalVariableDefinition:
+			c.declared[def.Name] = true
+		}
+	}
+
+	fs := newFuncState("<init>", nil, nil)
+	fs.topLevel = true
+	for _, topLevel := range program.Definitions {
+		switch def := topLevel.(type) {
+		case ast.FunctionDefinition:
+			fn, err := c.compileFunction(def, nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compile body of FunctionDefinition %s: %w", def.Name, err)
+			}
+			idx := c.function(def.Name)
+			c.functions[idx] = fn
+			fs.emit(OpDefine, idx, 0)
+
+		case ast.StructDefinition:
+			// NOTE: already defined above, before any function is compiled
+
+		case ast.GlobalVariableDefinition:
+			if err := c.compile(fs, def.Expression); err != nil {
+				return nil, fmt.Errorf("failed to compile Expression of GlobalVariable Definition: %w", err)
+			}
+			fs.emit(OpStoreGlobal, c.global(def.Name), 0)
+			fs.emit(OpPop, 0, 0)
+
+		case ast.ImportDeclaration:
+			return nil, &interpreter.RuntimeError{Span: def.Span, Err: &interpreter.UnresolvedImportError{Path: def.Path}}
+
+		default:
+			return nil, fmt.Errorf("unexpected topLevel: %v", def)
+		}
+	}
+	fs.emit(OpConst, c.constant(value.Int(0)), 0)
+	fs.emit(OpReturn, 0, 0)
+
+	return fs.function(), nil
+}
+
+// CompileExpression returns a chunk which evaluates exp at top level, where
+// every variable is global.
+func (c *Compiler) CompileExpression(exp ast.Expression) (*Function, error) {
+	fs := newFuncState("<expression>", nil, nil)
+	fs.topLevel = true
+	if err := c.compile(fs, exp); err != nil {
+		return nil, err
+	}
+	fs.emit(OpReturn, 0, 0)
+
+	return fs.function(), nil
+}
+
+// compileFunction compiles a function definition, or a function literal if
+// parent is the function it appears in.
+func (c *Compiler) compileFunction(funcDef ast.FunctionDefinition, parent *funcState) (*Function, error) {
+	args, body := funcDef.Args, funcDef.Body
+	fs := newFuncState(funcDef.Name, args, parent)
+	fs.minArity, _ = funcDef.Arity()
+	fs.variadic = funcDef.Variadic
+
+	// NOTE: variables are resolved statically. Parameters and names assigned in
+	// the body are frame slots, unless a function literal may refer to them:
+	// those live in cells, which outlive the frame. A name assigned in a
+	// function literal refers to the variable of an enclosing function if there
+	// is one. Everything else refers to a global.
+	captured := map[string]bool{}
+	collectCaptured(body, captured)
+	for _, defaultValue := range funcDef.Defaults {
+		collectCaptured(defaultValue, captured)
+	}
+	for j, arg := range args {
+		if captured[arg] {
+			fs.cell(arg, j)
+		}
+	}
+
+	var locals []string
+	collectAssigned(body, &locals)
+	for _, name := range locals {
+		if _, _, _, ok := fs.lookup(name); ok || c.declared[name] {
+			continue
+		}
+
+		if captured[name] {
+			fs.cell(name, -1)
+		} else {
+			fs.local(name)
+		}
+	}
+
+	// NOTE: default values are evaluated for each call, after the arguments before them are bound
+	for j, arg := range args {
+		defaultValue := funcDef.Default(j)
+		if defaultValue == nil {
+			continue
+		}
+
+		jumpToNext := fs.emit(OpJumpIfArg, 0, j)
+		if err := c.compile(fs, defaultValue); err != nil {
+			return nil, fmt.Errorf("failed to compile default value of %s: %w", arg, err)
+		}
+		_, store, idx, _ := fs.lookup(arg)
+		fs.emit(store, idx, 0)
+		fs.emit(OpPop, 0, 0)
+		fs.patch(jumpToNext)
+	}
+
+	if err := c.compile(fs, body); err != nil {
+		return nil, err
+	}
+	fs.emit(OpReturn, 0, 0)
+
+	return fs.function(), nil
+}
+
+func (c *Compiler) compile(fs *funcState, intf ast.Expression) error {
+	switch exp := intf.(type) {
+	case ast.BinaryExpression:
+		if exp.Operator == ast.And || exp.Operator == ast.Or {
+			return c.compileLogical(fs, exp)
+		}
+
+		if err := c.compile(fs, exp.Lhs); err != nil {
+			return err
+		}
+		if err := c.compile(fs, exp.Rhs); err != nil {
+			return err
+		}
+
+		fs.emitAt(exp.Span, OpBinary, int(exp.Operator), 0)
+
+	case ast.UnaryExpression:
+		if err := c.compile(fs, exp.Operand); err != nil {
+			return err
+		}
+
+		fs.emitAt(exp.Span, OpUnary, int(exp.Operator), 0)
+
+	case ast.IntegerLiteral:
+		if exp.Big != nil {
+			fs.emitAt(exp.Span, OpBigConst, c.constant(value.BigInt{N: exp.Big}), 0)
+			break
+		}
+		fs.emit(OpConst, c.constant(value.Int(exp.Value)), 0)
+
+	case ast.FloatLiteral:
+		fs.emit(OpConst, c.constant(value.Float(exp.Value)), 0)
+
+	case ast.StringLiteral:
+		fs.emit(OpConst, c.constant(value.String(exp.Value)), 0)
+
+	case ast.BooleanLiteral:
+		fs.emit(OpConst, c.constant(value.Bool(exp.Value)), 0)
+
+	case ast.Identifier:
+		if load, _, idx, ok := fs.lookup(exp.Name); ok {
+			fs.emitAt(exp.Span, load, idx, c.name(exp.Name))
+		} else if c.isFunction(exp.Name) && !c.declared[exp.Name] {
+			// NOTE: a function name evaluates to the function unless a variable hides it
+			fs.emitAt(exp.Span, OpFunction, c.function(exp.Name), c.global(exp.Name))
+		} else {
+			fs.emitAt(exp.Span, OpLoadGlobal, c.global(exp.Name), c.name(exp.Name))
+		}
+
+	case ast.Assignment:
+		if err := c.compile(fs, exp.Expression); err != nil {
+			return fmt.Errorf("failed to compile expression of assignment: %w", err)
+		}
+
+		if _, store, idx, ok := fs.lookup(exp.Name); ok {
+			fs.emit(store, idx, 0)
+		} else {
+			if fs.topLevel {
+				c.declared[exp.Name] = true
+			}
+			fs.emit(OpStoreGlobal, c.global(exp.Name), 0)
+		}
+
+	case ast.IfExpression:
+		if err := c.compile(fs, exp.Condition); err != nil {
+			return fmt.Errorf("failed to compile condition of IfExpression: %w", err)
+		}
+		jumpToElse := fs.emitAt(exp.Condition.Range(), OpJumpIfFalse, 0, 0)
+
+		if err := c.compile(fs, exp.ThenClause); err != nil {
+			return fmt.Errorf("failed to compile ThenClause of IfExpression: %w", err)
+		}
+		jumpToEnd := fs.emit(OpJump, 0, 0)
+
+		fs.patch(jumpToElse)
+		if exp.ElseClause.Expressions != nil {
+			if err := c.compile(fs, exp.ElseClause); err != nil {
+				return fmt.Errorf("failed to compile ElseClause of IfExpression: %w", err)
+			}
+		} else {
+			// NOTE: evaluate 1 if cond is false and elseClause is nil
+			fs.emit(OpConst, c.constant(value.Int(1)), 0)
+		}
+		fs.patch(jumpToEnd)
+
+	case ast.WhileExpression:
+		// NOTE: a hidden local remembers the height of the stack before the loop
+		// for break and continue, which may be in the middle of an expression
+		l := &loop{span: exp.Span, mark: fs.local(fmt.Sprintf("<loop %d>", len(fs.code)))}
+		fs.emit(OpMark, l.mark, 0)
+
+		l.start = len(fs.code)
+		if err := c.compile(fs, exp.Condition); err != nil {
+			return fmt.Errorf("failed to compile condition of WhileExpression: %w", err)
+		}
+		jumpToEnd := fs.emitAt(exp.Condition.Range(), OpJumpIfFalse, 0, 0)
+
+		fs.loops = append(fs.loops, l)
+		err := c.compile(fs, exp.Body)
+		fs.loops = fs.loops[:len(fs.loops)-1]
+		if err != nil {
+			return fmt.Errorf("failed to compile body of WhileExpression: %w", err)
+		}
+		fs.emit(OpPop, 0, 0)
+		fs.emitAt(l.span, OpLoop, l.start, 0)
+
+		fs.patch(jumpToEnd)
+		for _, pos := range l.breaks {
+			fs.patch(pos)
+		}
+		fs.emit(OpConst, c.constant(value.Int(1)), 0)
+
+	case ast.ReturnExpression:
+		if fs.topLevel {
+			return fmt.Errorf("return outside function")
+		}
+
+		if exp.Value != nil {
+			if err := c.compile(fs, exp.Value); err != nil {
+				return fmt.Errorf("failed to compile value of ReturnExpression: %w", err)
+			}
+		} else {
+			fs.emit(OpConst, c.constant(value.Int(0)), 0)
+		}
+		fs.emit(OpReturn, 0, 0)
+
+	case ast.BreakExpression:
+		if len(fs.loops) == 0 {
+			return fmt.Errorf("break outside loop")
+		}
+		l := fs.loops[len(fs.loops)-1]
+		fs.emit(OpUnwind, l.mark, 0)
+		l.breaks = append(l.breaks, fs.emit(OpJump, 0, 0))
+
+	case ast.ContinueExpression:
+		if len(fs.loops) == 0 {
+			return fmt.Errorf("continue outside loop")
+		}
+		l := fs.loops[len(fs.loops)-1]
+		fs.emit(OpUnwind, l.mark, 0)
+		fs.emitAt(l.span, OpLoop, l.start, 0)
+
+	case ast.BlockExpression:
+		if len(exp.Expressions) == 0 {
+			fs.emit(OpConst, c.constant(value.Int(0)), 0)
+			return nil
+		}
+
+		for j, e := range exp.Expressions {
+			if j > 0 {
+				fs.emit(OpPop, 0, 0)
+			}
+			if err := c.compile(fs, e); err != nil {
+				return fmt.Errorf("failed to compile one of Expressions of BlockExpression: %w", err)
+			}
+		}
+
+	case ast.Println:
+		if err := c.compile(fs, exp.Arg); err != nil {
+			return fmt.Errorf("failed to compile Println: %w", err)
+		}
+		fs.emit(OpPrintln, 0, 0)
+
+	case ast.FunctionLiteral:
+		fn, err := c.compileFunction(exp.Definition(), fs)
+		if err != nil {
+			return fmt.Errorf("failed to compile body of FunctionLiteral: %w", err)
+		}
+		c.functions = append(c.functions, fn)
+		c.funcNames = append(c.funcNames, "")
+		fs.emit(OpClosure, len(c.functions)-1, 0)
+
+	case ast.ListLiteral:
+		for _, e := range exp.Elements {
+			if err := c.compile(fs, e); err != nil {
+				return fmt.Errorf("failed to compile one of Elements of ListLiteral: %w", err)
+			}
+		}
+		fs.emit(OpList, len(exp.Elements), 0)
+
+	case ast.MapLiteral:
+		fs.emit(OpMap, 0, 0)
+		for j := range exp.Keys {
+			if err := c.compile(fs, exp.Keys[j]); err != nil {
+				return fmt.Errorf("failed to compile one of Keys of MapLiteral: %w", err)
+			}
+			if err := c.compile(fs, exp.Values[j]); err != nil {
+				return fmt.Errorf("failed to compile one of Values of MapLiteral: %w", err)
+			}
+			fs.emitAt(exp.Keys[j].Range(), OpSetEntry, 0, 0)
+		}
+
+	case ast.StructLiteral:
+		idx, ok := c.structs[exp.Name]
+		if !ok {
+			return &interpreter.RuntimeError{Span: exp.Span, Err: &interpreter.UnknownStructError{Name: exp.Name}}
+		}
+		fs.emit(OpStruct, idx, 0)
+		for j, name := range exp.Fields {
+			field, ok := c.structTypes[idx].Field(name)
+			if !ok {
+				return &interpreter.RuntimeError{Span: exp.Span, Err: &interpreter.UnknownFieldError{Type: exp.Name, Field: name}}
+			}
+			if err := c.compile(fs, exp.Values[j]); err != nil {
+				return fmt.Errorf("failed to compile one of Values of StructLiteral: %w", err)
+			}
+			fs.emit(OpInitField, field, 0)
+		}
+
+	case ast.FieldAccess:
+		if err := c.compile(fs, exp.Object); err != nil {
+			return fmt.Errorf("failed to compile object of FieldAccess: %w", err)
+		}
+		fs.emitAt(exp.Span, OpField, c.name(exp.Field), 0)
+
+	case ast.FieldAssignment:
+		if err := c.compile(fs, exp.Object); err != nil {
+			return fmt.Errorf("failed to compile object of FieldAssignment: %w", err)
+		}
+		if err := c.compile(fs, exp.Value); err != nil {
+			return fmt.Errorf("failed to compile value of FieldAssignment: %w", err)
+		}
+		fs.emitAt(exp.Span, OpStoreField, c.name(exp.Field), 0)
+
+	case ast.IndexExpression:
+		if err := c.compile(fs, exp.Collection); err != nil {
+			return fmt.Errorf("failed to compile collection of IndexExpression: %w", err)
+		}
+		if err := c.compile(fs, exp.Index); err != nil {
+			return fmt.Errorf("failed to compile index of IndexExpression: %w", err)
+		}
+		fs.emitAt(exp.Span, OpIndex, 0, 0)
+
+	case ast.IndexAssignment:
+		if err := c.compile(fs, exp.Collection); err != nil {
+			return fmt.Errorf("failed to compile collection of IndexAssignment: %w", err)
+		}
+		if err := c.compile(fs, exp.Index); err != nil {
+			return fmt.Errorf("failed to compile index of IndexAssignment: %w", err)
+		}
+		if err := c.compile(fs, exp.Value); err != nil {
+			return fmt.Errorf("failed to compile value of IndexAssignment: %w", err)
+		}
+		fs.emitAt(exp.Span, OpStoreIndex, 0, 0)
+
+	case ast.FunctionCall:
+		// a name refers to a variable holding a function before it refers to a
+		// defined function, which is called directly
+		callee := exp.Callee
+		if callee == nil {
+			if _, _, _, ok := fs.lookup(exp.Name); ok || c.declared[exp.Name] {
+				callee = ast.NewIdentifier(exp.Name)
+			}
+		}
+
+		if callee != nil {
+			if err := c.compile(fs, callee); err != nil {
+				return fmt.Errorf("failed to compile callee of FunctionCall: %w", err)
+			}
+		}
+		for _, arg := range exp.Args {
+			if err := c.compile(fs, arg); err != nil {
+				return fmt.Errorf("failed to compile one of FunctionCall Args: %w", err)
+			}
+		}
+
+		if callee != nil {
+			fs.emitAt(exp.Span, OpCallValue, 0, len(exp.Args))
+		} else {
+			fs.emitAt(exp.Span, OpCall, c.function(exp.Name), len(exp.Args))
+		}
+
+	default:
+		return fmt.Errorf("unexpected expression: %v", exp)
+	}
+
+	return nil
+}
+
+// compileLogical compiles && and ||, which evaluate rhs only if lhs does not
+// decide the result.
+func (c *Compiler) compileLogical(fs *funcState, exp ast.BinaryExpression) error {
+	if err := c.compile(fs, exp.Lhs); err != nil {
+		return err
+	}
+	jumpToRhs := fs.emitAt(exp.Lhs.Range(), OpJumpIfFalse, 0, 0)
+
+	// lhs is true
+	var jumpToEnd int
+	if exp.Operator == ast.And {
+		if err := c.compile(fs, exp.Rhs); err != nil {
+			return err
+		}
+		fs.emitAt(exp.Rhs.Range(), OpBool, 0, 0)
+		jumpToEnd = fs.emit(OpJump, 0, 0)
+	} else {
+		fs.emit(OpConst, c.constant(value.Bool(true)), 0)
+		jumpToEnd = fs.emit(OpJump, 0, 0)
+	}
+
+	// lhs is false
+	fs.patch(jumpToRhs)
+	if exp.Operator == ast.And {
+		fs.emit(OpConst, c.constant(value.Bool(false)), 0)
+	} else {
+		if err := c.compile(fs, exp.Rhs); err != nil {
+			return err
+		}
+		fs.emitAt(exp.Rhs.Range(), OpBool, 0, 0)
+	}
+	fs.patch(jumpToEnd)
+
+	return nil
+}
+
+func (c *Compiler) constant(v value.Value) int {
+	for j, constant := range c.constants {
+		if constant == v {
+			return j
+		}
+	}
+	c.constants = append(c.constants, v)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) name(name string) int {
+	for j, n := range c.names {
+		if n == name {
+			return j
+		}
+	}
+	c.names = append(c.names, name)
+	return len(c.names) - 1
+}
+
+func (c *Compiler) global(name string) int {
+	if slot, ok := c.globals[name]; ok {
+		return slot
+	}
+	c.globals[name] = len(c.globals)
+	return c.globals[name]
+}
+
+// defineStruct makes the struct of def known to what is compiled later. A
+// struct defined again replaces the old one for new values only.
+func (c *Compiler) defineStruct(def ast.StructDefinition) {
+	c.structs[def.Name] = len(c.structTypes)
+	c.structTypes = append(c.structTypes, &value.StructType{Name: def.Name, Fields: def.Fields})
+}
+
+// isFunction reports whether name is a function, defined, host or builtin, or
+// has been called as one.
+func (c *Compiler) isFunction(name string) bool {
+	if _, ok := c.funcs[name]; ok {
+		return true
+	}
+	_, ok := c.builtins.Lookup(name)
+	return ok
+}
+
+// function returns the index of the named function. Calls to a function which
+// is never defined still get an index so that they fail only when executed.
+func (c *Compiler) function(name string) int {
+	if idx, ok := c.funcs[name]; ok {
+		return idx
+	}
+	c.funcs[name] = len(c.functions)
+	c.functions = append(c.functions, nil)
+	c.funcNames = append(c.funcNames, name)
+	return c.funcs[name]
+}
+
+type funcState struct {
+	name     string
+	arity    int
+	minArity int
+	variadic bool
+	topLevel bool
+	parent   *funcState
+
+	locals    map[string]int
+	numLocals int
+	cells     map[string]int
+	cellInits []int
+	free      map[string]int
+	captures  []Capture
+
+	// loops are the WhileExpressions enclosing the code being compiled.
+	loops []*loop
+
+	code  []Instruction
+	spans []ast.Span
+}
+
+type loop struct {
+	// span is the WhileExpression, where a limit exceeded by its iterations is
+	// reported.
+	span   ast.Span
+	mark   int
+	start  int
+	breaks []int
+}
+
+func newFuncState(name string, args []string, parent *funcState) *funcState {
+	fs := &funcState{
+		name:   name,
+		arity:  len(args),
+		parent: parent,
+		locals: map[string]int{},
+		cells:  map[string]int{},
+		free:   map[string]int{},
+	}
+	for _, arg := range args {
+		fs.local(arg)
+	}
+	return fs
+}
+
+func (fs *funcState) local(name string) int {
+	if slot, ok := fs.locals[name]; ok {
+		return slot
+	}
+	fs.locals[name] = fs.numLocals
+	fs.numLocals++
+	return fs.locals[name]
+}
+
+// cell makes name a cell, initialized from the parameter slot param or to 0 if
+// param is -1. A parameter keeps its frame slot, which is no longer used.
+func (fs *funcState) cell(name string, param int) {
+	delete(fs.locals, name)
+	fs.cells[name] = len(fs.cellInits)
+	fs.cellInits = append(fs.cellInits, param)
+}
+
+// lookup returns the instructions to load and store name if it is a variable of
+// fs or of a function enclosing it.
+func (fs *funcState) lookup(name string) (Opcode, Opcode, int, bool) {
+	if slot, ok := fs.locals[name]; ok {
+		return OpLoadLocal, OpStoreLocal, slot, true
+	}
+	if idx, ok := fs.cells[name]; ok {
+		return OpLoadCell, OpStoreCell, idx, true
+	}
+	if idx, ok := fs.capture(name); ok {
+		return OpLoadFree, OpStoreFree, idx, true
+	}
+	return 0, 0, 0, false
+}
+
+// capture returns the index of name among the free variables of fs, adding it
+// if an enclosing function has such a variable.
+func (fs *funcState) capture(name string) (int, bool) {
+	if idx, ok := fs.free[name]; ok {
+		return idx, true
+	}
+	if fs.parent == nil {
+		return 0, false
+	}
+
+	var capture Capture
+	if idx, ok := fs.parent.cells[name]; ok {
+		capture = Capture{Cell: true, Index: idx}
+	} else if idx, ok := fs.parent.capture(name); ok {
+		capture = Capture{Index: idx}
+	} else {
+		return 0, false
+	}
+
+	fs.free[name] = len(fs.captures)
+	fs.captures = append(fs.captures, capture)
+	return fs.free[name], true
+}
+
+func (fs *funcState) emit(op Opcode, a, b int) int {
+	return fs.emitAt(ast.Span{}, op, a, b)
+}
+
+// emitAt is like emit but records the source of the instruction for errors.
+func (fs *funcState) emitAt(span ast.Span, op Opcode, a, b int) int {
+	fs.code = append(fs.code, Instruction{Op: op, A: a, B: b})
+	fs.spans = append(fs.spans, span)
+	return len(fs.code) - 1
+}
+
+// patch makes the jump at pos target the next instruction to be emitted.
+func (fs *funcState) patch(pos int) {
+	fs.code[pos].A = len(fs.code)
+}
+
+func (fs *funcState) function() *Function {
+	return &Function{
+		Name:      fs.name,
+		Arity:     fs.arity,
+		MinArity:  fs.minArity,
+		Variadic:  fs.variadic,
+		NumLocals: fs.numLocals,
+		Code:      fs.code,
+		Cells:     fs.cellInits,
+		Captures:  fs.captures,
+		Spans:     fs.spans,
+	}
+}
+
+func collectAssigned(intf ast.Expression, names *[]string) {
+	if assignment, ok := intf.(ast.Assignment); ok {
+		*names = append(*names, assignment.Name)
+	}
+	for _, child := range ast.Children(intf) {
+		collectAssigned(child, names)
+	}
+}
+
+// collectCaptured adds every name mentioned in the function literals of exp.
+// This may include names the literals bind themselves, which only costs a cell.
+func collectCaptured(intf ast.Expression, names map[string]bool) {
+	if literal, ok := intf.(ast.FunctionLiteral); ok {
+		collectNames(literal.Body, names)
+		return
+	}
+	for _, child := range ast.Children(intf) {
+		collectCaptured(child, names)
+	}
+}
+
+func collectNames(intf ast.Expression, names map[string]bool) {
+	switch exp := intf.(type) {
+	case ast.Identifier:
+		names[exp.Name] = true
+	case ast.Assignment:
+		names[exp.Name] = true
+	case ast.FunctionCall:
+		names[exp.Name] = true
+	case ast.FunctionLiteral:
+		collectNames(exp.Body, names)
+	}
+	for _, child := range ast.Children(intf) {
+		collectNames(child, names)
+	}
+}
@@ -0,0 +1,140 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+)
+
+type Opcode byte
+
+const (
+	OpConst Opcode = iota
+	OpBigConst
+	OpPop
+	OpLoadLocal
+	OpStoreLocal
+	OpLoadGlobal
+	OpStoreGlobal
+	OpLoadCell
+	OpStoreCell
+	OpLoadFree
+	OpStoreFree
+	OpBinary
+	OpUnary
+	OpBool
+	OpList
+	OpMap
+	OpSetEntry
+	OpIndex
+	OpStoreIndex
+	OpStruct
+	OpInitField
+	OpField
+	OpStoreField
+	OpJump
+	OpJumpIfFalse
+	OpJumpIfArg
+	OpLoop
+	OpMark
+	OpUnwind
+	OpDefine
+	OpFunction
+	OpClosure
+	OpCall
+	OpCallValue
+	OpReturn
+	OpPrintln
+)
+
+func (o Opcode) Name() string {
+	return [...]string{
+		"Const",
+		"BigConst",
+		"Pop",
+		"LoadLocal",
+		"StoreLocal",
+		"LoadGlobal",
+		"StoreGlobal",
+		"LoadCell",
+		"StoreCell",
+		"LoadFree",
+		"StoreFree",
+		"Binary",
+		"Unary",
+		"Bool",
+		"List",
+		"Map",
+		"SetEntry",
+		"Index",
+		"StoreIndex",
+		"Struct",
+		"InitField",
+		"Field",
+		"StoreField",
+		"Jump",
+		"JumpIfFalse",
+		"JumpIfArg",
+		"Loop",
+		"Mark",
+		"Unwind",
+		"Define",
+		"Function",
+		"Closure",
+		"Call",
+		"CallValue",
+		"Return",
+		"Println",
+	}[o]
+}
+
+// Instruction is a single VM instruction. The meaning of A and B depends on Op:
+// an index into the constant pool, a local, global, cell or free variable slot,
+// a jump target, an ast.Operator for OpBinary and OpUnary, a function index, or
+// a count of values for OpCall, OpCallValue and OpList, which collects that many
+// values into a list. OpMap pushes an empty map, and OpSetEntry pops a key and a
+// value and sets them in the map below them. OpStruct pushes a value of the
+// struct type A, and OpInitField pops a value into its field A. The A of OpField
+// and OpStoreField is the name of the field. OpJumpIfArg jumps to A if the argument B was passed,
+// skipping the code which computes its default value. OpLoop jumps back to A,
+// the start of a loop, and counts a step of the program. OpMark saves the height of
+// the stack in the local slot A, and OpUnwind drops what was pushed since then,
+// for break and continue to leave a loop in the middle of an expression.
+// OpFunction takes a function index and the global slot to read if no such
+// function is defined. The B of the other load instructions is the name of the
+// variable, to report it if it is undefined. OpBigConst pushes a constant which
+// is an integer literal too large for an int, if the arithmetic allows it.
+type Instruction struct {
+	Op Opcode
+	A  int
+	B  int
+}
+
+func (i Instruction) String() string {
+	return fmt.Sprintf("%-14s %d %d", i.Op.Name(), i.A, i.B)
+}
+
+type Function struct {
+	Name string
+	// Arity is the number of parameters, including a variadic one, and
+	// MinArity is the number of those without a default value.
+	Arity     int
+	MinArity  int
+	Variadic  bool
+	NumLocals int
+	Code      []Instruction
+	// Cells holds, for each variable captured by a function literal inside
+	// this one, the parameter slot it is initialized from, or -1.
+	Cells []int
+	// Captures describes the variables a closure of this function refers to.
+	Captures []Capture
+	// Spans[i] is the source of Code[i], used to report runtime errors.
+	Spans []ast.Span
+}
+
+// Capture refers to a variable of the function enclosing a function literal:
+// one of its cells if Cell is set, otherwise one of its own captures.
+type Capture struct {
+	Cell  bool
+	Index int
+}
@@ -0,0 +1,559 @@
+// Package vm compiles toy programs into bytecode and executes them on a stack
+// machine. It is an alternative to interpreter.Interpreter with the same
+// semantics, except that scopes are always lexical: there is no equivalent of
+// Interpreter.DynamicScope.
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/TOMOFUMI-KONDO/toy/ast"
+	"github.com/TOMOFUMI-KONDO/toy/builtins"
+	"github.com/TOMOFUMI-KONDO/toy/interpreter"
+	"github.com/TOMOFUMI-KONDO/toy/value"
+)
+
+const MainFuncName = "main"
+
+type frame struct {
+	fn    *Function
+	ip    int
+	base  int
+	argc  int
+	cells []*cell
+	free  []*cell
+}
+
+type VM struct {
+	// Arithmetic selects what operations on ints do when they overflow, as
+	// Interpreter.Arithmetic does.
+	Arithmetic interpreter.Arithmetic
+	// Limits stop a program as Interpreter.Limits do.
+	Limits interpreter.Limits
+
+	compiler *Compiler
+	env      *builtins.Env
+	globals  []value.Value
+	defined  []*Closure
+	stack    []value.Value
+	writer   io.Writer
+	meter    *interpreter.Meter
+}
+
+func NewVM() VM {
+	env := builtins.NewEnv(os.Stdin)
+	compiler := NewCompiler()
+	compiler.builtins = interpreter.NewBuiltins(env)
+	return VM{
+		compiler: compiler,
+		env:      env,
+		writer:   os.Stdout,
+	}
+}
+
+// SetReader makes readln read from r instead of os.Stdin.
+func (m *VM) SetReader(r io.Reader) {
+	*m.env = *builtins.NewEnv(r)
+}
+
+func NewVMWithWriter(w io.Writer) VM {
+	m := NewVM()
+	m.writer = w
+	return m
+}
+
+// RegisterFunc makes the Go function fn callable from toy as name, as
+// Interpreter.RegisterFunc does.
+func (m *VM) RegisterFunc(name string, minArity, maxArity int, fn func(args []value.Value) (value.Value, error)) {
+	m.compiler.builtins.Register(name, minArity, maxArity, fn)
+}
+
+func (m *VM) Interpret(exp ast.Expression) (value.Value, error) {
+	return m.InterpretContext(context.Background(), exp)
+}
+
+// InterpretContext is Interpret which stops with an error when ctx is done or
+// a limit of m.Limits is exceeded.
+func (m *VM) InterpretContext(ctx context.Context, exp ast.Expression) (value.Value, error) {
+	defer m.start(ctx)()
+
+	fn, err := m.compiler.CompileExpression(exp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile expression: %w", err)
+	}
+
+	return m.Run(fn)
+}
+
+// CallMain defines everything in program and calls its main function, after
+// checking program with interpreter.CheckBuiltins, which returns the first
+// error found.
+func (m *VM) CallMain(program ast.Program) (value.Value, error) {
+	return m.CallMainContext(context.Background(), program)
+}
+
+// CallMainContext is CallMain which stops with an error when ctx is done or a
+// limit of m.Limits is exceeded.
+func (m *VM) CallMainContext(ctx context.Context, program ast.Program) (value.Value, error) {
+	defer m.start(ctx)()
+
+	if errs := interpreter.CheckBuiltins(program, m.compiler.builtins); len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	init, err := m.compiler.CompileProgram(program)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile program: %w", err)
+	}
+
+	if _, err := m.Run(init); err != nil {
+		return nil, err
+	}
+
+	idx, ok := m.compiler.funcs[MainFuncName]
+	if !ok || m.defined[idx] == nil {
+		return nil, fmt.Errorf("this program doesn't have %s() function", MainFuncName)
+	}
+
+	if err := m.meter.Enter(); err != nil {
+		return nil, &interpreter.RuntimeError{Err: err}
+	}
+	result, err := m.Run(m.defined[idx].Fn)
+	m.meter.Leave()
+	if err != nil {
+		var rerr *interpreter.RuntimeError
+		if errors.As(err, &rerr) {
+			rerr.Trace = append(rerr.Trace, interpreter.Frame{Function: MainFuncName})
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+// start meters what m runs until the returned function is called.
+func (m *VM) start(ctx context.Context) func() {
+	meter, cancel := interpreter.NewMeter(ctx, m.Limits)
+	m.meter = meter
+	m.env.Negate = m.Arithmetic.Negate
+	return func() {
+		m.meter = nil
+		cancel()
+	}
+}
+
+// Run executes fn, which takes no arguments, and returns its result. A
+// RuntimeError records the functions called from fn in its Trace.
+func (m *VM) Run(fn *Function) (_ value.Value, err error) {
+	m.grow()
+
+	base := len(m.stack)
+	defer func() { m.stack = m.stack[:base] }()
+
+	// parameters of a function run directly are unbound, or default
+	m.arguments(fn, 0)
+
+	frames := []frame{m.enter(fn, nil, base, 0)}
+	defer func() {
+		var rerr *interpreter.RuntimeError
+		if errors.As(err, &rerr) {
+			rerr.Trace = append(rerr.Trace, m.trace(frames)...)
+		}
+	}()
+	for {
+		f := &frames[len(frames)-1]
+		ins := f.fn.Code[f.ip]
+		f.ip++
+
+		switch ins.Op {
+		case OpConst:
+			m.push(m.compiler.constants[ins.A])
+
+		case OpBigConst:
+			result, err := m.Arithmetic.Literal(m.compiler.constants[ins.A].(value.BigInt).N)
+			if err != nil {
+				return nil, m.wrapError(f, err)
+			}
+			m.push(result)
+
+		case OpPop:
+			m.pop()
+
+		case OpLoadLocal:
+			if err := m.load(f, m.stack[f.base+ins.A], ins.B); err != nil {
+				return nil, err
+			}
+
+		case OpStoreLocal:
+			m.stack[f.base+ins.A] = m.top()
+
+		case OpLoadGlobal:
+			if err := m.load(f, m.globals[ins.A], ins.B); err != nil {
+				return nil, err
+			}
+
+		case OpStoreGlobal:
+			m.globals[ins.A] = m.top()
+
+		case OpLoadCell:
+			if err := m.load(f, f.cells[ins.A].v, ins.B); err != nil {
+				return nil, err
+			}
+
+		case OpStoreCell:
+			f.cells[ins.A].v = m.top()
+
+		case OpLoadFree:
+			if err := m.load(f, f.free[ins.A].v, ins.B); err != nil {
+				return nil, err
+			}
+
+		case OpStoreFree:
+			f.free[ins.A].v = m.top()
+
+		case OpBinary:
+			rhs := m.pop()
+			lhs := m.pop()
+			result, err := m.Arithmetic.BinaryOperation(ast.Operator(ins.A), lhs, rhs)
+			if err != nil {
+				return nil, m.wrapError(f, err)
+			}
+			m.push(result)
+
+		case OpUnary:
+			result, err := m.Arithmetic.UnaryOperation(ast.Operator(ins.A), m.pop())
+			if err != nil {
+				return nil, m.wrapError(f, err)
+			}
+			m.push(result)
+
+		case OpBool:
+			b, err := interpreter.Truthy(m.pop())
+			if err != nil {
+				return nil, m.wrapError(f, err)
+			}
+			m.push(value.Bool(b))
+
+		case OpList:
+			elements := make([]value.Value, ins.A)
+			copy(elements, m.stack[len(m.stack)-ins.A:])
+			m.stack = m.stack[:len(m.stack)-ins.A]
+			m.push(value.NewList(elements))
+
+		case OpMap:
+			m.push(value.NewMap())
+
+		case OpSetEntry:
+			v := m.pop()
+			key := m.pop()
+			if err := interpreter.SetIndex(m.top(), key, v); err != nil {
+				return nil, m.wrapError(f, err)
+			}
+
+		case OpStruct:
+			m.push(value.NewStruct(m.compiler.structTypes[ins.A]))
+
+		case OpInitField:
+			v := m.pop()
+			m.top().(*value.Struct).Values[ins.A] = v
+
+		case OpField:
+			result, err := interpreter.Field(m.pop(), m.compiler.names[ins.A])
+			if err != nil {
+				return nil, m.wrapError(f, err)
+			}
+			m.push(result)
+
+		case OpStoreField:
+			v := m.pop()
+			if err := interpreter.SetField(m.pop(), m.compiler.names[ins.A], v); err != nil {
+				return nil, m.wrapError(f, err)
+			}
+			m.push(v)
+
+		case OpIndex:
+			index := m.pop()
+			result, err := interpreter.Index(m.pop(), index)
+			if err != nil {
+				return nil, m.wrapError(f, err)
+			}
+			m.push(result)
+
+		case OpStoreIndex:
+			v := m.pop()
+			index := m.pop()
+			if err := interpreter.SetIndex(m.pop(), index, v); err != nil {
+				return nil, m.wrapError(f, err)
+			}
+			m.push(v)
+
+		case OpJump:
+			f.ip = ins.A
+
+		case OpLoop:
+			if err := m.meter.Step(); err != nil {
+				return nil, m.wrapError(f, err)
+			}
+			f.ip = ins.A
+
+		case OpJumpIfArg:
+			if f.argc > ins.B {
+				f.ip = ins.A
+			}
+
+		case OpMark:
+			m.stack[f.base+ins.A] = value.Int(len(m.stack) - f.base)
+
+		case OpUnwind:
+			m.stack = m.stack[:f.base+int(m.stack[f.base+ins.A].(value.Int))]
+
+		case OpJumpIfFalse:
+			cond, err := interpreter.Truthy(m.pop())
+			if err != nil {
+				return nil, m.wrapError(f, err)
+			}
+			if !cond {
+				f.ip = ins.A
+			}
+
+		case OpDefine:
+			m.defined[ins.A] = &Closure{Fn: m.compiler.functions[ins.A]}
+
+		case OpFunction:
+			if closure := m.defined[ins.A]; closure != nil {
+				m.push(closure)
+			} else if m.globals[ins.B] != nil {
+				m.push(m.globals[ins.B])
+			} else if b, ok := m.compiler.builtins.Lookup(m.functionName(ins.A)); ok {
+				m.push(b)
+			} else {
+				return nil, interpreter.NewUndefinedVariableError(f.fn.Spans[f.ip-1], m.functionName(ins.A))
+			}
+
+		case OpClosure:
+			fn := m.compiler.functions[ins.A]
+			free := make([]*cell, len(fn.Captures))
+			for j, capture := range fn.Captures {
+				if capture.Cell {
+					free[j] = f.cells[capture.Index]
+				} else {
+					free[j] = f.free[capture.Index]
+				}
+			}
+			m.push(&Closure{Fn: fn, free: free})
+
+		case OpCall:
+			if err := m.meter.Step(); err != nil {
+				return nil, m.wrapError(f, err)
+			}
+			callee := m.defined[ins.A]
+			if callee == nil {
+				b, ok := m.compiler.builtins.Lookup(m.functionName(ins.A))
+				if !ok {
+					return nil, m.wrapError(f, &interpreter.UnknownFunctionError{Name: m.functionName(ins.A)})
+				}
+				if err := m.callBuiltin(f, b, ins.B); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			next, err := m.call(f, callee, ins.B)
+			if err != nil {
+				return nil, err
+			}
+			frames = append(frames, next)
+
+		case OpCallValue:
+			if err := m.meter.Step(); err != nil {
+				return nil, m.wrapError(f, err)
+			}
+			// the callee is below the arguments; take it out of the stack
+			at := len(m.stack) - ins.B - 1
+			if b, ok := m.stack[at].(*interpreter.Builtin); ok {
+				copy(m.stack[at:], m.stack[at+1:])
+				m.stack = m.stack[:len(m.stack)-1]
+				if err := m.callBuiltin(f, b, ins.B); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			callee, ok := m.stack[at].(*Closure)
+			if !ok {
+				return nil, m.runtimeError(f, "%s is not a function", m.stack[at].Type())
+			}
+			copy(m.stack[at:], m.stack[at+1:])
+			m.stack = m.stack[:len(m.stack)-1]
+
+			next, err := m.call(f, callee, ins.B)
+			if err != nil {
+				return nil, err
+			}
+			frames = append(frames, next)
+
+		case OpReturn:
+			result := m.pop()
+			m.stack = m.stack[:f.base]
+			frames = frames[:len(frames)-1]
+			if len(frames) == 0 {
+				return result, nil
+			}
+			m.meter.Leave()
+			m.push(result)
+
+		case OpPrintln:
+			if _, err := fmt.Fprint(m.writer, m.top()); err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, fmt.Errorf("unexpected instruction: %v", ins)
+		}
+	}
+}
+
+// call returns the frame for calling callee with the argc arguments on top of
+// the stack, from the instruction f has just fetched.
+func (m *VM) call(f *frame, callee *Closure, argc int) (frame, error) {
+	fn := callee.Fn
+	max := fn.Arity
+	if fn.Variadic {
+		max = -1
+	}
+	if argc < fn.MinArity || (max >= 0 && argc > max) {
+		return frame{}, m.wrapError(f, &interpreter.ArityMismatchError{Function: fn.Name, Min: fn.MinArity, Max: max, Got: argc})
+	}
+	if err := m.meter.Enter(); err != nil {
+		return frame{}, m.wrapError(f, err)
+	}
+
+	m.arguments(fn, argc)
+	return m.enter(fn, callee.free, len(m.stack)-fn.Arity, argc), nil
+}
+
+// callBuiltin replaces the argc arguments on top of the stack with the result
+// of calling b with them, from the instruction f has just fetched.
+func (m *VM) callBuiltin(f *frame, b *interpreter.Builtin, argc int) error {
+	args := make([]value.Value, argc)
+	copy(args, m.stack[len(m.stack)-argc:])
+	m.stack = m.stack[:len(m.stack)-argc]
+
+	result, err := b.Call(args)
+	if err != nil {
+		return interpreter.NewCallError(b, f.fn.Spans[f.ip-1], err)
+	}
+	m.push(result)
+	return nil
+}
+
+// arguments turns the argc arguments on top of the stack into the parameter
+// slots of fn: missing ones are left unset for their default values, and the
+// remaining ones are collected into a list for a variadic parameter.
+func (m *VM) arguments(fn *Function, argc int) {
+	fixed := fn.Arity
+	if fn.Variadic {
+		fixed--
+	}
+
+	var rest []value.Value
+	if argc > fixed {
+		rest = append(rest, m.stack[len(m.stack)-(argc-fixed):]...)
+		m.stack = m.stack[:len(m.stack)-(argc-fixed)]
+	}
+	for j := argc; j < fixed; j++ {
+		m.push(nil)
+	}
+
+	if fn.Variadic {
+		m.push(value.NewList(rest))
+	}
+}
+
+// enter reserves the local slots and cells of fn, whose arguments are already
+// on the stack from base.
+func (m *VM) enter(fn *Function, free []*cell, base, argc int) frame {
+	for j := fn.Arity; j < fn.NumLocals; j++ {
+		m.push(nil)
+	}
+
+	var cells []*cell
+	if len(fn.Cells) > 0 {
+		cells = make([]*cell, len(fn.Cells))
+		for j, param := range fn.Cells {
+			if param < 0 {
+				cells[j] = &cell{}
+			} else {
+				cells[j] = &cell{v: m.stack[base+param]}
+			}
+		}
+	}
+
+	return frame{fn: fn, base: base, argc: argc, cells: cells, free: free}
+}
+
+// grow extends globals and defined functions to cover everything compiled so far.
+func (m *VM) grow() {
+	for len(m.globals) < len(m.compiler.globals) {
+		m.globals = append(m.globals, nil)
+	}
+	for len(m.defined) < len(m.compiler.functions) {
+		m.defined = append(m.defined, nil)
+	}
+}
+
+// load pushes v, the variable named by name, unless nothing is assigned to it.
+func (m *VM) load(f *frame, v value.Value, name int) error {
+	if v == nil {
+		return interpreter.NewUndefinedVariableError(f.fn.Spans[f.ip-1], m.compiler.names[name])
+	}
+	m.push(v)
+	return nil
+}
+
+// trace returns the functions called in frames, innermost first, with the
+// instruction of the caller which called each.
+func (m *VM) trace(frames []frame) []interpreter.Frame {
+	var trace []interpreter.Frame
+	for j := len(frames) - 1; j > 0; j-- {
+		caller := frames[j-1]
+		trace = append(trace, interpreter.Frame{
+			Function: frames[j].fn.Name,
+			Call:     caller.fn.Spans[caller.ip-1],
+		})
+	}
+	return trace
+}
+
+// runtimeError reports an error at the instruction f has just fetched.
+func (m *VM) runtimeError(f *frame, format string, a ...interface{}) error {
+	return m.wrapError(f, fmt.Errorf(format, a...))
+}
+
+func (m *VM) wrapError(f *frame, err error) error {
+	return &interpreter.RuntimeError{
+		Span: f.fn.Spans[f.ip-1],
+		Err:  err,
+	}
+}
+
+// builtin returns the host function or else the builtin named name.
+func (m *VM) functionName(idx int) string {
+	return m.compiler.funcNames[idx]
+}
+
+func (m *VM) push(v value.Value) {
+	m.stack = append(m.stack, v)
+}
+
+func (m *VM) pop() value.Value {
+	v := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return v
+}
+
+func (m *VM) top() value.Value {
+	return m.stack[len(m.stack)-1]
+}